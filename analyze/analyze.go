@@ -0,0 +1,301 @@
+// Package analyze runs ffmpeg's detection filters and returns their findings
+// as typed results.
+//
+// Any combination of detectors runs in a single decode pass: black frames,
+// frozen video, silence, scene changes, crop borders and interlacing.
+package analyze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Options selects the detectors to run. Nil detectors are skipped
+type Options struct {
+	Black     *BlackOptions
+	Freeze    *FreezeOptions
+	Silence   *SilenceOptions
+	Scene     *SceneOptions
+	Crop      *CropOptions
+	Interlace bool
+}
+
+// BlackOptions configures blackdetect
+type BlackOptions struct {
+	// MinDuration is the shortest black segment reported, in seconds.
+	// Defaults to 2
+	MinDuration float64
+	// PixelThreshold is the luminance below which a pixel is black, from 0
+	// to 1. Defaults to 0.10
+	PixelThreshold float64
+}
+
+// FreezeOptions configures freezedetect
+type FreezeOptions struct {
+	// Noise is the tolerated difference between frames in dB. Defaults to -60
+	Noise float64
+	// MinDuration is the shortest freeze reported, in seconds. Defaults to 2
+	MinDuration float64
+}
+
+// SilenceOptions configures silencedetect
+type SilenceOptions struct {
+	// Noise is the level below which audio is silent in dB. Defaults to -60
+	Noise float64
+	// MinDuration is the shortest silence reported, in seconds. Defaults to 2
+	MinDuration float64
+}
+
+// SceneOptions configures scene change detection
+type SceneOptions struct {
+	// Threshold is the minimum scene score, from 0 to 1, of a cut.
+	// Defaults to 0.4
+	Threshold float64
+}
+
+// CropOptions configures cropdetect
+type CropOptions struct {
+	// Limit is the black threshold, from 0 to 255. Defaults to 24
+	Limit int
+	// Round makes the suggested width and height divisible by it.
+	// Defaults to 2
+	Round int
+}
+
+// Interval is a detected segment, in seconds. End is zero when the segment
+// was still open when the input ended
+type Interval struct {
+	Start    float64
+	End      float64
+	Duration float64
+}
+
+// SceneCut is a detected scene change
+type SceneCut struct {
+	Time  float64
+	Score float64
+}
+
+// Crop is a suggested crop rectangle
+type Crop struct {
+	Width  int
+	Height int
+	X      int
+	Y      int
+}
+
+// Filter returns the crop as a crop filter
+func (c Crop) Filter() string {
+	return fmt.Sprintf("crop=%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// Interlace holds idet's multi-frame detection counts
+type Interlace struct {
+	TFF          int
+	BFF          int
+	Progressive  int
+	Undetermined int
+}
+
+// Interlaced reports whether most frames were detected as interlaced
+func (i Interlace) Interlaced() bool {
+	return i.TFF+i.BFF > i.Progressive
+}
+
+// Report holds the results of the detectors that ran
+type Report struct {
+	Black     []Interval
+	Freeze    []Interval
+	Silence   []Interval
+	SceneCuts []SceneCut
+	Crop      *Crop
+	Interlace *Interlace
+}
+
+// Run decodes input once through the selected detectors
+func Run(ctx context.Context, cfg *ffmpeg.Config, input string, opts Options) (*Report, error) {
+	video, audio := opts.filters()
+	if len(video) == 0 && len(audio) == 0 {
+		return nil, errors.New("no detectors selected")
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-i", input}
+	if len(video) > 0 {
+		args = append(args, "-vf", strings.Join(video, ","))
+	} else {
+		args = append(args, "-vn")
+	}
+	if len(audio) > 0 {
+		args = append(args, "-af", strings.Join(audio, ","))
+	} else {
+		args = append(args, "-an")
+	}
+	args = append(args, "-f", "null", "-")
+
+	_, stderr, err := ffmpeg.Exec(ctx, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return opts.parse(stderr), nil
+}
+
+// filters builds the video and audio filter chains. Scene detection drops
+// frames, so it comes last
+func (o Options) filters() (video, audio []string) {
+	if b := o.Black; b != nil {
+		video = append(video, fmt.Sprintf("blackdetect=d=%s:pix_th=%s",
+			num(b.MinDuration, 2), num(b.PixelThreshold, 0.10)))
+	}
+	if f := o.Freeze; f != nil {
+		video = append(video, fmt.Sprintf("freezedetect=n=%sdB:d=%s",
+			num(f.Noise, -60), num(f.MinDuration, 2)))
+	}
+	if c := o.Crop; c != nil {
+		limit, round := c.Limit, c.Round
+		if limit <= 0 {
+			limit = 24
+		}
+		if round <= 0 {
+			round = 2
+		}
+		video = append(video, fmt.Sprintf("cropdetect=limit=%d:round=%d:reset=0", limit, round))
+	}
+	if o.Interlace {
+		video = append(video, "idet")
+	}
+	if s := o.Scene; s != nil {
+		video = append(video, fmt.Sprintf("select='gt(scene,%s)'", num(s.Threshold, 0.4)), "metadata=print")
+	}
+	if s := o.Silence; s != nil {
+		audio = append(audio, fmt.Sprintf("silencedetect=n=%sdB:d=%s",
+			num(s.Noise, -60), num(s.MinDuration, 2)))
+	}
+	return video, audio
+}
+
+var (
+	blackRe     = regexp.MustCompile(`black_start:\s*([\d.]+)\s+black_end:\s*([\d.]+)\s+black_duration:\s*([\d.]+)`)
+	freezeRe    = regexp.MustCompile(`freezedetect\.freeze_(start|duration|end):\s*([\d.]+)`)
+	silenceRe   = regexp.MustCompile(`silence_(start|end):\s*(-?[\d.]+)(?:\s*\|\s*silence_duration:\s*([\d.]+))?`)
+	ptsTimeRe   = regexp.MustCompile(`Parsed_metadata.*pts_time:\s*(-?[\d.]+)`)
+	sceneRe     = regexp.MustCompile(`lavfi\.scene_score=([\d.]+)`)
+	cropRe      = regexp.MustCompile(`crop=(\d+):(\d+):(\d+):(\d+)`)
+	interlaceRe = regexp.MustCompile(`Multi frame detection:\s*TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)\s*Undetermined:\s*(\d+)`)
+)
+
+// parse reads the detector output from ffmpeg's log
+func (o Options) parse(stderr string) *Report {
+	r := &Report{}
+	crops := map[Crop]int{}
+	sceneTime := -1.0
+
+	for _, line := range strings.Split(stderr, "\n") {
+		switch {
+		case o.Black != nil && blackRe.MatchString(line):
+			m := blackRe.FindStringSubmatch(line)
+			r.Black = append(r.Black, Interval{Start: atof(m[1]), End: atof(m[2]), Duration: atof(m[3])})
+
+		case o.Freeze != nil && freezeRe.MatchString(line):
+			m := freezeRe.FindStringSubmatch(line)
+			r.Freeze = openOrClose(r.Freeze, m[1], atof(m[2]))
+
+		case o.Silence != nil && silenceRe.MatchString(line):
+			m := silenceRe.FindStringSubmatch(line)
+			r.Silence = openOrClose(r.Silence, m[1], atof(m[2]))
+			if m[3] != "" {
+				r.Silence[len(r.Silence)-1].Duration = atof(m[3])
+			}
+
+		case o.Scene != nil && ptsTimeRe.MatchString(line):
+			sceneTime = atof(ptsTimeRe.FindStringSubmatch(line)[1])
+
+		case o.Scene != nil && sceneRe.MatchString(line) && sceneTime >= 0:
+			r.SceneCuts = append(r.SceneCuts, SceneCut{Time: sceneTime, Score: atof(sceneRe.FindStringSubmatch(line)[1])})
+			sceneTime = -1
+
+		case o.Crop != nil && strings.Contains(line, "cropdetect") && cropRe.MatchString(line):
+			m := cropRe.FindStringSubmatch(line)
+			crops[Crop{Width: atoi(m[1]), Height: atoi(m[2]), X: atoi(m[3]), Y: atoi(m[4])}]++
+
+		case o.Interlace && interlaceRe.MatchString(line):
+			m := interlaceRe.FindStringSubmatch(line)
+			r.Interlace = &Interlace{TFF: atoi(m[1]), BFF: atoi(m[2]), Progressive: atoi(m[3]), Undetermined: atoi(m[4])}
+		}
+	}
+
+	r.Crop = mostFrequent(crops)
+
+	return r
+}
+
+// openOrClose starts a new interval on a start event and completes the last
+// one on an end or duration event
+func openOrClose(intervals []Interval, event string, value float64) []Interval {
+	if event == "start" {
+		return append(intervals, Interval{Start: value})
+	}
+	if len(intervals) == 0 {
+		return intervals
+	}
+	last := &intervals[len(intervals)-1]
+	switch event {
+	case "end":
+		last.End = value
+		if last.Duration == 0 {
+			last.Duration = value - last.Start
+		}
+	case "duration":
+		last.Duration = value
+	}
+	return intervals
+}
+
+// mostFrequent returns the crop suggested for the most frames, preferring
+// the larger rectangle on ties
+func mostFrequent(crops map[Crop]int) *Crop {
+	if len(crops) == 0 {
+		return nil
+	}
+
+	candidates := make([]Crop, 0, len(crops))
+	for c := range crops {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if crops[a] != crops[b] {
+			return crops[a] > crops[b]
+		}
+		return a.Width*a.Height > b.Width*b.Height
+	})
+
+	return &candidates[0]
+}
+
+// num formats a filter option, using def when v is zero
+func num(v, def float64) string {
+	if v == 0 {
+		v = def
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// atof ...
+func atof(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// atoi ...
+func atoi(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
@@ -0,0 +1,91 @@
+package analyze_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/floostack/transcoder/analyze"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+const qcLog = `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'programme.mp4':
+[blackdetect @ 0x5581] black_start:0 black_end:2.04 black_duration:2.04
+[Parsed_cropdetect_2 @ 0x5582] x1:0 x2:1919 y1:140 y2:939 w:1920 h:800 x:0 y:140 pts:25 t:1.000000 limit:0.094118 crop=1920:800:0:140
+[Parsed_cropdetect_2 @ 0x5582] x1:0 x2:1919 y1:138 y2:941 w:1920 h:800 x:0 y:140 pts:50 t:2.000000 limit:0.094118 crop=1920:800:0:140
+[Parsed_cropdetect_2 @ 0x5582] x1:0 x2:1919 y1:0 y2:1079 w:1920 h:1072 x:0 y:4 pts:75 t:3.000000 limit:0.094118 crop=1920:1072:0:4
+[Parsed_metadata_5 @ 0x5583] frame:0    pts:118     pts_time:4.72
+[Parsed_metadata_5 @ 0x5583] lavfi.scene_score=0.563694
+[freezedetect @ 0x5584] lavfi.freezedetect.freeze_start: 5.005
+[silencedetect @ 0x5585] silence_start: 6.5
+[freezedetect @ 0x5584] lavfi.freezedetect.freeze_duration: 2.5
+[freezedetect @ 0x5584] lavfi.freezedetect.freeze_end: 7.505
+[silencedetect @ 0x5585] silence_end: 9.25 | silence_duration: 2.75
+[Parsed_metadata_5 @ 0x5583] frame:1    pts:300     pts_time:12
+[Parsed_metadata_5 @ 0x5583] lavfi.freezedetect.freeze_start=5.005
+[Parsed_metadata_5 @ 0x5583] lavfi.scene_score=0.912000
+[silencedetect @ 0x5585] silence_start: 58
+[Parsed_idet_3 @ 0x5586] Repeated Fields: Neither:  1500 Top:     0 Bottom:     0
+[Parsed_idet_3 @ 0x5586] Single frame detection: TFF:    12 BFF:     0 Progressive:  1400 Undetermined:    88
+[Parsed_idet_3 @ 0x5586] Multi frame detection: TFF:     3 BFF:     0 Progressive:  1490 Undetermined:     7
+`
+
+func TestRunCombinedDetectors(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{Stderr: qcLog}, transcodertest.Script{})
+
+	report, err := analyze.Run(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner}, "programme.mp4", analyze.Options{
+		Black:     &analyze.BlackOptions{},
+		Freeze:    &analyze.FreezeOptions{},
+		Silence:   &analyze.SilenceOptions{MinDuration: 1},
+		Scene:     &analyze.SceneOptions{Threshold: 0.5},
+		Crop:      &analyze.CropOptions{},
+		Interlace: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(runner.Calls()[0].Args, " ")
+	wantArgs := "-vf blackdetect=d=2:pix_th=0.1,freezedetect=n=-60dB:d=2,cropdetect=limit=24:round=2:reset=0,idet,select='gt(scene,0.5)',metadata=print -af silencedetect=n=-60dB:d=1 -f null -"
+	if !strings.HasSuffix(args, wantArgs) {
+		t.Errorf("args = %s\nwant suffix %s", args, wantArgs)
+	}
+
+	want := &analyze.Report{
+		Black:     []analyze.Interval{{Start: 0, End: 2.04, Duration: 2.04}},
+		Freeze:    []analyze.Interval{{Start: 5.005, End: 7.505, Duration: 2.5}},
+		Silence:   []analyze.Interval{{Start: 6.5, End: 9.25, Duration: 2.75}, {Start: 58}},
+		SceneCuts: []analyze.SceneCut{{Time: 4.72, Score: 0.563694}, {Time: 12, Score: 0.912}},
+		Crop:      &analyze.Crop{Width: 1920, Height: 800, X: 0, Y: 140},
+		Interlace: &analyze.Interlace{TFF: 3, Progressive: 1490, Undetermined: 7},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v\nwant %+v", report, want)
+	}
+	if report.Interlace.Interlaced() {
+		t.Error("mostly progressive input reported as interlaced")
+	}
+	if f := report.Crop.Filter(); f != "crop=1920:800:0:140" {
+		t.Errorf("crop filter = %s", f)
+	}
+}
+
+func TestRunSingleDetector(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{Stderr: qcLog}, transcodertest.Script{})
+
+	report, err := analyze.Run(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner}, "programme.mp4", analyze.Options{
+		Silence: &analyze.SilenceOptions{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args := strings.Join(runner.Calls()[0].Args, " "); !strings.Contains(args, "-vn -af silencedetect") {
+		t.Errorf("video should not be decoded: %s", args)
+	}
+	if len(report.Silence) != 2 || report.Black != nil || report.Crop != nil {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,505 @@
+// Package chunked speeds up long encodes by splitting them across a worker
+// pool. The input is split at keyframes, the video chunks are transcoded in
+// parallel with identical options while the audio is encoded in one piece,
+// and everything is losslessly joined with the concat demuxer. The result
+// is verified against the input's duration and frame count.
+//
+// Only the first video and audio streams of the input are encoded; other
+// streams, such as subtitles or alternate audio tracks, are dropped. Inputs
+// cannot be trimmed with SeekTime or Duration, which would shift the chunk
+// boundaries, so trim them beforehand, e.g. with the clip package.
+//
+// Chunks are encoded to Matroska and stream copied into the output, so codecs
+// left unset are the output container's defaults: H.264 and AAC for MP4 and
+// QuickTime, VP9 and Opus for WebM, H.264 and Vorbis for Matroska. Other
+// containers need explicit codecs.
+package chunked
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/queue"
+	"github.com/floostack/transcoder/utils"
+)
+
+// ErrVerification is returned when the joined output does not match the
+// input's duration or frame count
+var ErrVerification = errors.New("chunked output verification failed")
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Queue runs the chunk encodes. When nil a queue with one slot per CPU is
+	// created for the duration of the encode
+	Queue *queue.Queue
+	// ChunkDuration is the target length of a chunk. Chunks start on
+	// keyframes, so they are at least this long. Defaults to 2 minutes
+	ChunkDuration time.Duration
+	// WorkDir holds the intermediate chunk files. Defaults to the system
+	// temporary directory
+	WorkDir string
+	// Retries is how often a failed chunk is submitted again before the
+	// encode is abandoned. Defaults to 2
+	Retries int
+	// OnProgress receives the aggregate progress percentage of the chunks
+	OnProgress func(percent float64)
+}
+
+// Result describes a finished chunked encode
+type Result struct {
+	Chunks int
+	// Retried counts chunk resubmissions after failures
+	Retried  int
+	Duration float64
+	Frames   int
+}
+
+// chunk is a keyframe-aligned range of the input, in seconds
+type chunk struct {
+	start, end float64
+	path       string
+	id         string
+	attempts   int
+}
+
+// Encode transcodes input to output with opts, in parallel chunks
+func Encode(ctx context.Context, cfg Config, input, output string, opts ffmpeg.Options) (*Result, error) {
+	if cfg.ChunkDuration <= 0 {
+		cfg.ChunkDuration = 2 * time.Minute
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = 2
+	}
+	if opts.SeekTime != nil || opts.Duration != nil {
+		return nil, fmt.Errorf("%w: chunked encodes cover the whole input and cannot seek or set a duration", ffmpeg.ErrInvalidArgument)
+	}
+	if err := defaultCodecs(output, &opts); err != nil {
+		return nil, err
+	}
+	if cfg.Queue == nil {
+		cfg.Queue = queue.New(queue.Config{FFmpeg: cfg.FFmpeg})
+		defer cfg.Queue.Close()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	metadata, err := ffmpeg.New(cfg.FFmpeg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	duration, err := strconv.ParseFloat(metadata.GetFormat().GetDuration(), 64)
+	if err != nil {
+		return nil, fmt.Errorf("input has no duration: %v", err)
+	}
+	hasAudio := false
+	for _, s := range metadata.GetStreams() {
+		hasAudio = hasAudio || s.GetCodecType() == "audio"
+	}
+
+	keyframes, err := ffmpeg.Keyframes(ctx, cfg.FFmpeg, input)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir(cfg.WorkDir, "chunked")
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := split(keyframes, duration, cfg.ChunkDuration.Seconds())
+	for i := range chunks {
+		chunks[i].path = filepath.Join(dir, fmt.Sprintf("chunk-%05d.mkv", i))
+	}
+
+	e := &encoder{cfg: cfg, input: input, opts: opts, chunks: chunks}
+	// Jobs still writing into dir are stopped before it is removed
+	defer func() {
+		cancel()
+		e.drain()
+		os.RemoveAll(dir)
+	}()
+
+	for i := range chunks {
+		if err := e.submit(ctx, i); err != nil {
+			return nil, err
+		}
+	}
+
+	audio := ""
+	if hasAudio && opts.SkipAudio == nil {
+		audio = filepath.Join(dir, "audio.mka")
+		id, err := cfg.Queue.Submit(ctx, audioSpec(input, audio, opts))
+		if err != nil {
+			return nil, err
+		}
+		e.mu.Lock()
+		e.audioID = id
+		e.mu.Unlock()
+	}
+
+	if cfg.OnProgress != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go e.report(done)
+	}
+
+	if err := e.wait(ctx); err != nil {
+		return nil, err
+	}
+	if e.audioID != "" {
+		if err := wait(ctx, cfg.Queue, e.audioID); err != nil {
+			return nil, fmt.Errorf("audio: %v", err)
+		}
+	}
+
+	if err := join(ctx, cfg.FFmpeg, dir, chunks, audio, output, opts); err != nil {
+		return nil, err
+	}
+
+	result, err := verify(ctx, cfg.FFmpeg, input, output, duration, len(chunks), opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Retried = e.retried
+
+	return result, nil
+}
+
+// containerCodecs are the default video and audio encoders of output
+// formats, which differ from those the Matroska chunks would get
+var containerCodecs = map[string][2]string{
+	"mp4":      {"libx264", "aac"},
+	"mov":      {"libx264", "aac"},
+	"ipod":     {"libx264", "aac"},
+	"webm":     {"libvpx-vp9", "libopus"},
+	"matroska": {"libx264", "libvorbis"},
+}
+
+// defaultCodecs sets the codecs opts leaves unset to the defaults of the
+// output's container, named by opts.OutputFormat or the extension
+func defaultCodecs(output string, opts *ffmpeg.Options) error {
+	if opts.VideoCodec != nil && (opts.AudioCodec != nil || opts.SkipAudio != nil) {
+		return nil
+	}
+
+	format := ""
+	if opts.OutputFormat != nil {
+		format = *opts.OutputFormat
+	} else {
+		switch strings.ToLower(filepath.Ext(output)) {
+		case ".mp4", ".m4v":
+			format = "mp4"
+		case ".mov":
+			format = "mov"
+		case ".webm":
+			format = "webm"
+		case ".mkv":
+			format = "matroska"
+		}
+	}
+
+	codecs, ok := containerCodecs[format]
+	if !ok {
+		return fmt.Errorf("%w: set the video and audio codecs of %s", ffmpeg.ErrInvalidArgument, output)
+	}
+	if opts.VideoCodec == nil {
+		opts.VideoCodec = &codecs[0]
+	}
+	if opts.AudioCodec == nil && opts.SkipAudio == nil {
+		opts.AudioCodec = &codecs[1]
+	}
+	return nil
+}
+
+// split cuts [0, duration) into chunks of at least length seconds, starting
+// on keyframes
+func split(keyframes []float64, duration, length float64) []chunk {
+	chunks := []chunk{{start: 0}}
+
+	for _, kf := range keyframes {
+		last := &chunks[len(chunks)-1]
+		if kf-last.start >= length && duration-kf >= length/2 {
+			last.end = kf
+			chunks = append(chunks, chunk{start: kf})
+		}
+	}
+	chunks[len(chunks)-1].end = duration
+
+	return chunks
+}
+
+// encoder tracks the chunk jobs of one encode
+type encoder struct {
+	cfg   Config
+	input string
+	opts  ffmpeg.Options
+
+	mu      sync.Mutex
+	chunks  []chunk
+	audioID string
+	retried int
+}
+
+// submit queues the encode of chunk i
+func (e *encoder) submit(ctx context.Context, i int) error {
+	e.mu.Lock()
+	c := e.chunks[i]
+	e.mu.Unlock()
+
+	id, err := e.cfg.Queue.Submit(ctx, chunkSpec(e.input, c, e.opts))
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.chunks[i].id = id
+	e.chunks[i].attempts++
+	e.mu.Unlock()
+
+	return nil
+}
+
+// wait waits for every chunk, resubmitting failed ones while they have
+// retries left
+func (e *encoder) wait(ctx context.Context) error {
+	for i := range e.chunks {
+		for {
+			e.mu.Lock()
+			c := e.chunks[i]
+			e.mu.Unlock()
+
+			err := wait(ctx, e.cfg.Queue, c.id)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil || c.attempts > e.cfg.Retries {
+				return fmt.Errorf("chunk %d (%.3fs-%.3fs): %v", i, c.start, c.end, err)
+			}
+
+			if err := e.submit(ctx, i); err != nil {
+				return err
+			}
+			e.mu.Lock()
+			e.retried++
+			e.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// drain waits for the chunk and audio jobs to finish, once they have been
+// cancelled
+func (e *encoder) drain() {
+	e.mu.Lock()
+	ids := []string{e.audioID}
+	for _, c := range e.chunks {
+		ids = append(ids, c.id)
+	}
+	e.mu.Unlock()
+
+	for _, id := range ids {
+		if id != "" {
+			e.cfg.Queue.Wait(context.Background(), id)
+		}
+	}
+}
+
+// report sends the duration weighted progress of the chunks until done
+func (e *encoder) report(done chan struct{}) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		e.mu.Lock()
+		var encoded, total float64
+		for _, c := range e.chunks {
+			length := c.end - c.start
+			total += length
+			if status, err := e.cfg.Queue.Status(c.id); err == nil {
+				// Progress percentages are relative to the whole input, so
+				// use the chunk's encoded time instead
+				if status.State == queue.StateDone {
+					encoded += length
+				} else {
+					encoded += math.Min(utils.DurToSec(status.Progress.CurrentTime), length)
+				}
+			}
+		}
+		e.mu.Unlock()
+
+		if total > 0 {
+			e.cfg.OnProgress(encoded * 100 / total)
+		}
+	}
+}
+
+// wait waits for a queued job and converts its failure into an error
+func wait(ctx context.Context, q *queue.Queue, id string) error {
+	status, err := q.Wait(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status.State != queue.StateDone {
+		return fmt.Errorf("job %s %s: %s", id, status.State, status.Error)
+	}
+	return nil
+}
+
+// chunkSpec encodes the video of one chunk into a Matroska file
+func chunkSpec(input string, c chunk, opts ffmpeg.Options) queue.Spec {
+	var inputOpts ffmpeg.Options
+	if c.start > 0 {
+		start := ffmpeg.FormatSeconds(c.start)
+		inputOpts.SeekTime = &start
+	}
+
+	length := ffmpeg.FormatSeconds(c.end - c.start)
+	format := "matroska"
+	yes := true
+
+	opts.Duration = &length
+	opts.OutputFormat = &format
+	opts.MovFlags = nil
+	opts.SkipAudio = &yes
+	opts.Overwrite = &yes
+	opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-map", "0:v:0")
+
+	return queue.Spec{
+		Input:        input,
+		InputOptions: &inputOpts,
+		Outputs:      []queue.Output{{Path: c.path, Options: opts}},
+	}
+}
+
+// audioSpec encodes the whole audio track in one piece, avoiding the gaps
+// encoder priming would leave at chunk boundaries
+func audioSpec(input, output string, opts ffmpeg.Options) queue.Spec {
+	format := "matroska"
+	yes := true
+
+	audio := ffmpeg.Options{
+		AudioCodec:    opts.AudioCodec,
+		AudioBitrate:  opts.AudioBitrate,
+		AudioChannels: opts.AudioChannels,
+		AudioRate:     opts.AudioRate,
+		AudioProfile:  opts.AudioProfile,
+		AudioFilter:   opts.AudioFilter,
+		OutputFormat:  &format,
+		SkipVideo:     &yes,
+		Overwrite:     &yes,
+		ExtraArgs:     map[string]interface{}{"-map": "0:a:0"},
+	}
+
+	return queue.Spec{Input: input, Outputs: []queue.Output{{Path: output, Options: audio}}}
+}
+
+// join concatenates the chunks, muxing in the audio, without re-encoding
+func join(ctx context.Context, cfg *ffmpeg.Config, dir string, chunks []chunk, audio, output string, opts ffmpeg.Options) error {
+	list := filepath.Join(dir, "chunks.ffconcat")
+
+	f, err := os.Create(list)
+	if err != nil {
+		return err
+	}
+	entries := make([]ffmpeg.ConcatEntry, len(chunks))
+	for i, c := range chunks {
+		entries[i] = ffmpeg.ConcatEntry{Path: c.path}
+	}
+	err = ffmpeg.WriteConcatList(f, entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-y", "-f", "concat", "-safe", "0", "-i", list}
+	if audio != "" {
+		args = append(args, "-i", audio, "-map", "0:v", "-map", "1:a")
+	}
+	args = append(args, "-c", "copy")
+	args = append(args, ffmpeg.Options{OutputFormat: opts.OutputFormat, MovFlags: opts.MovFlags}.GetStrArguments()...)
+	args = append(args, output)
+
+	_, _, err = ffmpeg.Exec(ctx, cfg, args...)
+	return err
+}
+
+// verify checks the joined output against the input. Outputs whose frame
+// rate is changed by opts are expected to have duration times that rate
+// frames; with rate changing filters only the duration is checked
+func verify(ctx context.Context, cfg *ffmpeg.Config, input, output string, duration float64, chunks int, opts ffmpeg.Options) (*Result, error) {
+	outDuration, err := ffmpeg.Duration(ctx, cfg, output)
+	if err != nil {
+		return nil, err
+	}
+	// Allow a frame of rounding per chunk boundary
+	if math.Abs(outDuration-duration) > 0.1+0.05*float64(chunks) {
+		return nil, fmt.Errorf("%w: duration %.3fs, input %.3fs", ErrVerification, outDuration, duration)
+	}
+
+	outFrames, err := ffmpeg.FrameCount(ctx, cfg, output)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.VideoFilter != nil && changesFrameRate(*opts.VideoFilter):
+	case opts.FrameRate != nil:
+		// Frames are dropped or duplicated independently in each chunk
+		want := int(math.Round(duration * float64(*opts.FrameRate)))
+		if diff := outFrames - want; diff < -chunks || diff > chunks {
+			return nil, fmt.Errorf("%w: %d frames, want %d at %d fps", ErrVerification, outFrames, want, *opts.FrameRate)
+		}
+	default:
+		inFrames, err := ffmpeg.FrameCount(ctx, cfg, input)
+		if err != nil {
+			return nil, err
+		}
+		if inFrames != outFrames {
+			return nil, fmt.Errorf("%w: %d frames, input %d", ErrVerification, outFrames, inFrames)
+		}
+	}
+
+	return &Result{Chunks: chunks, Duration: outDuration, Frames: outFrames}, nil
+}
+
+// rateFilters change the number of frames of a stream
+var rateFilters = []string{"fps", "framerate", "select", "minterpolate", "decimate", "framestep", "mpdecimate", "tinterlace", "interlace", "telecine", "yadif", "bwdif"}
+
+// changesFrameRate reports whether the filter graph uses a rate changing
+// filter
+func changesFrameRate(graph string) bool {
+	names := strings.FieldsFunc(graph, func(r rune) bool { return r == ',' || r == ';' || r == ']' || r == '[' })
+	for _, f := range names {
+		name := strings.TrimSpace(f)
+		if i := strings.IndexAny(name, "=@"); i >= 0 {
+			name = name[:i]
+		}
+		for _, rf := range rateFilters {
+			if name == rf {
+				return true
+			}
+		}
+	}
+	return false
+}
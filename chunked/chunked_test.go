@@ -0,0 +1,200 @@
+package chunked_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/chunked"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/queue"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+// fakeTitle answers the probes and encodes of a ten minute title with a
+// keyframe every two seconds. The encode of the chunk starting at 240s
+// fails once with an error the queue does not retry by itself
+func fakeTitle(frames int) *ffmpeg.FakeRunner {
+	var mu sync.Mutex
+	failed := false
+
+	runner := &ffmpeg.FakeRunner{}
+	runner.Script = func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		args := strings.Join(cmd.Args, " ")
+
+		switch {
+		case strings.Contains(args, "-show_streams"):
+			return ffmpeg.FakeResult{Stdout: transcodertest.Probe(10*time.Minute,
+				transcodertest.VideoStream("h264", 3840, 2160), transcodertest.AudioStream("aac"))}
+		case strings.Contains(args, "packet=pts_time,flags"):
+			var b strings.Builder
+			for t := 0; t < 600; t += 2 {
+				fmt.Fprintf(&b, "%d.000000,K_\n%d.040000,__\n", t, t)
+			}
+			return ffmpeg.FakeResult{Stdout: b.String()}
+		case strings.Contains(args, "format=duration"):
+			return ffmpeg.FakeResult{Stdout: "600.000000\n"}
+		case strings.Contains(args, "nb_read_packets"):
+			if strings.HasSuffix(args, "out.mp4") {
+				return ffmpeg.FakeResult{Stdout: fmt.Sprintf("%d\n", frames)}
+			}
+			return ffmpeg.FakeResult{Stdout: "15000\n"}
+		case strings.Contains(args, "-ss 240.000000"):
+			mu.Lock()
+			defer mu.Unlock()
+			if !failed {
+				failed = true
+				return ffmpeg.FakeResult{Stderr: "Error while decoding stream #0:0: Invalid data found when processing input\n", ExitCode: 1}
+			}
+		}
+
+		return ffmpeg.FakeResult{Stderr: transcodertest.Progress(2*time.Minute, 2)}
+	}
+
+	return runner
+}
+
+func TestEncode(t *testing.T) {
+	runner := fakeTitle(15000)
+	codec := "libx265"
+
+	result, err := chunked.Encode(context.Background(), chunked.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+	}, "master.mov", "out.mp4", ffmpeg.Options{VideoCodec: &codec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Chunks != 5 || result.Retried != 1 || result.Frames != 15000 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	var chunkEncodes, concat int
+	for _, call := range runner.Calls() {
+		args := strings.Join(call.Args, " ")
+		if strings.Contains(args, "-map 0:v:0") && strings.Contains(args, "-c:v libx265") {
+			chunkEncodes++
+		}
+		if strings.Contains(args, "-f concat -safe 0") && strings.Contains(args, "-c copy") {
+			concat++
+		}
+	}
+	if chunkEncodes != 6 || concat != 1 {
+		t.Errorf("%d chunk encodes and %d joins, want 6 and 1", chunkEncodes, concat)
+	}
+}
+
+func TestEncodeVerifiesFrameCount(t *testing.T) {
+	_, err := chunked.Encode(context.Background(), chunked.Config{
+		FFmpeg:  &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: fakeTitle(14999)},
+		Retries: 1,
+	}, "master.mov", "out.mp4", ffmpeg.Options{})
+	if !errors.Is(err, chunked.ErrVerification) {
+		t.Errorf("got %v, want a verification error", err)
+	}
+}
+
+func TestEncodeChangingFrameRate(t *testing.T) {
+	cfg := chunked.Config{FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: fakeTitle(18002)}}
+
+	// 600 seconds at 30 fps, within a frame per chunk
+	rate := 30
+	result, err := chunked.Encode(context.Background(), cfg, "master.mov", "out.mp4", ffmpeg.Options{FrameRate: &rate})
+	if err != nil || result.Frames != 18002 {
+		t.Errorf("Encode() = %+v, %v", result, err)
+	}
+
+	// Frame counts after rate changing filters are not checked
+	cfg.FFmpeg.Runner = fakeTitle(9000)
+	filter := "scale=-2:720,fps=15"
+	if _, err := chunked.Encode(context.Background(), cfg, "master.mov", "out.mp4", ffmpeg.Options{VideoFilter: &filter}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncodeDefaultCodecs(t *testing.T) {
+	for _, c := range []struct{ output, video, audio string }{
+		{"out.mp4", "-c:v libx264", "-c:a aac"},
+		{"out.webm", "-c:v libvpx-vp9", "-c:a libopus"},
+	} {
+		runner := fakeTitle(15000)
+		chunked.Encode(context.Background(), chunked.Config{
+			FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		}, "master.mov", c.output, ffmpeg.Options{})
+
+		var video, audio bool
+		for _, call := range runner.Calls() {
+			args := strings.Join(call.Args, " ")
+			video = video || strings.Contains(args, "-map 0:v:0") && strings.Contains(args, c.video)
+			audio = audio || strings.Contains(args, "-map 0:a:0") && strings.Contains(args, c.audio)
+		}
+		if !video || !audio {
+			t.Errorf("%s: chunks not encoded with %s and %s", c.output, c.video, c.audio)
+		}
+	}
+
+	_, err := chunked.Encode(context.Background(), chunked.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: fakeTitle(15000)},
+	}, "master.mov", "out.avi", ffmpeg.Options{})
+	if !errors.Is(err, ffmpeg.ErrInvalidArgument) {
+		t.Errorf("out.avi: got %v, want an invalid argument error", err)
+	}
+}
+
+func TestEncodeStopsChunksWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runner := fakeTitle(15000)
+	script := runner.Script
+	runner.Script = func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if strings.Contains(strings.Join(cmd.Args, " "), "-map 0:v:0") {
+			// The chunk is still starting when the encode is cancelled
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+		}
+		return script(cmd)
+	}
+
+	cfg := &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}
+	q := queue.New(queue.Config{FFmpeg: cfg, Slots: 2})
+	defer q.Close()
+
+	dir, err := ioutil.TempDir("", "chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = chunked.Encode(ctx, chunked.Config{FFmpeg: cfg, Queue: q, WorkDir: dir}, "master.mov", "out.mp4", ffmpeg.Options{})
+	t.Log(err, runner.Calls())
+	if err == nil {
+		t.Fatal("cancellation not reported")
+	}
+
+	t.Logf("%+v", q.Stats())
+	// The chunk jobs have stopped before their directory was removed
+	if stats := q.Stats(); stats.Running != 0 || stats.Queued != 0 {
+		t.Errorf("jobs left behind: %+v", stats)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("work directory not removed")
+	}
+}
+
+func TestEncodeRejectsTrimming(t *testing.T) {
+	runner := fakeTitle(15000)
+	start := "10"
+	_, err := chunked.Encode(context.Background(), chunked.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+	}, "master.mov", "out.mp4", ffmpeg.Options{SeekTime: &start})
+	if !errors.Is(err, ffmpeg.ErrInvalidArgument) || len(runner.Calls()) != 0 {
+		t.Errorf("got %v after %d calls, want an invalid argument error", err, len(runner.Calls()))
+	}
+}
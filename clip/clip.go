@@ -0,0 +1,348 @@
+// Package clip cuts sections out of media files.
+//
+// Cutting with stream copy is fast but can only start on a keyframe, while
+// re-encoding is frame accurate but slow. Clip offers both, plus a smart
+// mode that re-encodes only the partial GOP before the first keyframe and
+// stream copies the rest.
+//
+// Smart cuts join two MPEG-TS parts carrying their H.264, HEVC or MPEG-2
+// parameter sets in-band, so the copied part decodes with its own parameters
+// after the re-encoded one. They keep the video and audio streams only, and
+// other video codecs, or joins not matching the source, are re-encoded whole.
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Mode selects how a clip is cut
+type Mode string
+
+// Clip modes
+const (
+	// ModeFast stream copies from the keyframe at or before the start, so the
+	// clip may begin slightly early
+	ModeFast Mode = "fast"
+	// ModeAccurate re-encodes the whole clip and starts exactly at the start
+	ModeAccurate Mode = "accurate"
+	// ModeSmart re-encodes from the start to the next keyframe and stream
+	// copies the rest, starting exactly at the start
+	ModeSmart Mode = "smart"
+)
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Options are the output options of re-encoded clips in accurate mode
+	Options ffmpeg.Options
+	// WorkDir holds the intermediate parts of smart cuts. Defaults to the
+	// system temporary directory
+	WorkDir string
+}
+
+// Result reports the section actually cut, in seconds of the input
+type Result struct {
+	Mode  Mode
+	Start float64
+	End   float64
+}
+
+// videoEncoder re-encodes the head of smart cuts of one video codec
+type videoEncoder struct {
+	encoder string
+	// params is the encoder's private options flag, which repeats the
+	// parameter sets before every keyframe
+	params string
+	// bsf converts copied packets to Annex B, with in-band parameter sets
+	bsf string
+	// tag signals in-band parameter sets in MP4 and QuickTime outputs
+	tag string
+}
+
+// videoEncoders lists the video codecs smart cuts can join in MPEG-TS
+var videoEncoders = map[string]videoEncoder{
+	"h264":       {encoder: "libx264", params: "-x264-params", bsf: "h264_mp4toannexb", tag: "avc3"},
+	"hevc":       {encoder: "libx265", params: "-x265-params", bsf: "hevc_mp4toannexb", tag: "hev1"},
+	"mpeg2video": {encoder: "mpeg2video"},
+}
+
+// audioEncoders maps decoders to the encoder used to re-encode the audio of
+// the head of smart cuts
+var audioEncoders = map[string]string{
+	"aac":  "aac",
+	"mp3":  "libmp3lame",
+	"opus": "libopus",
+	"ac3":  "ac3",
+}
+
+// Clip cuts input between start and end, in seconds, into output
+func Clip(ctx context.Context, cfg Config, input, output string, start, end float64, mode Mode) (*Result, error) {
+	if end <= start {
+		return nil, errors.New("clip end must be after its start")
+	}
+
+	switch mode {
+	case ModeAccurate:
+		return accurate(ctx, cfg, input, output, start, end)
+	case ModeFast, ModeSmart:
+	default:
+		return nil, fmt.Errorf("unknown clip mode %q", mode)
+	}
+
+	keyframes, err := ffmpeg.Keyframes(ctx, cfg.FFmpeg, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == ModeFast {
+		return copyCut(ctx, cfg, input, output, keyframeBefore(keyframes, start), end, ModeFast)
+	}
+
+	next := keyframeAfter(keyframes, start)
+	if next == start || next >= end {
+		// Already aligned, or no keyframe inside the clip to copy from
+		if next == start {
+			return copyCut(ctx, cfg, input, output, start, end, ModeSmart)
+		}
+		return accurate(ctx, cfg, input, output, start, end)
+	}
+
+	return smart(ctx, cfg, input, output, start, next, end)
+}
+
+// accurate re-encodes the clip
+func accurate(ctx context.Context, cfg Config, input, output string, start, end float64) (*Result, error) {
+	args := []string{"-y", "-ss", ffmpeg.FormatSeconds(start), "-i", input, "-t", ffmpeg.FormatSeconds(end - start)}
+	args = append(args, cfg.Options.GetStrArguments()...)
+	args = append(args, output)
+
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	return result(ctx, cfg, output, ModeAccurate, start)
+}
+
+// copyCut stream copies from start, which must be a keyframe, to end
+func copyCut(ctx context.Context, cfg Config, input, output string, start, end float64, mode Mode) (*Result, error) {
+	args := []string{"-y", "-ss", ffmpeg.FormatSeconds(start), "-i", input, "-t", ffmpeg.FormatSeconds(end - start),
+		"-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero", output}
+
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	return result(ctx, cfg, output, mode, start)
+}
+
+// smart re-encodes [start, keyframe) with parameters matching the source,
+// stream copies [keyframe, end) and joins both with the concat demuxer.
+// The join is verified against the source, and the clip re-encoded whole
+// when its video parameters differ
+func smart(ctx context.Context, cfg Config, input, output string, start, keyframe, end float64) (*Result, error) {
+	metadata, err := ffmpeg.New(cfg.FFmpeg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	source := videoStream(metadata)
+	headArgs, ok := matchingEncode(metadata)
+	if !ok {
+		// The head could not be encoded compatibly with the copied tail
+		return accurate(ctx, cfg, input, output, start, end)
+	}
+	enc := videoEncoders[source.GetCodecName()]
+
+	dir, err := ioutil.TempDir(cfg.WorkDir, "clip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	head := filepath.Join(dir, "head.ts")
+	tail := filepath.Join(dir, "tail.ts")
+
+	args := []string{"-y", "-ss", ffmpeg.FormatSeconds(start), "-i", input, "-t", ffmpeg.FormatSeconds(keyframe - start),
+		"-map", "0:v", "-map", "0:a?"}
+	args = append(args, headArgs...)
+	args = append(args, "-f", "mpegts", head)
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	args = []string{"-y", "-ss", ffmpeg.FormatSeconds(keyframe), "-i", input, "-t", ffmpeg.FormatSeconds(end - keyframe),
+		"-map", "0:v", "-map", "0:a?", "-c", "copy"}
+	if enc.bsf != "" {
+		args = append(args, "-bsf:v", enc.bsf)
+	}
+	args = append(args, "-avoid_negative_ts", "make_zero", "-f", "mpegts", tail)
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	list := filepath.Join(dir, "parts.ffconcat")
+	f, err := os.Create(list)
+	if err != nil {
+		return nil, err
+	}
+	err = ffmpeg.WriteConcatList(f, []ffmpeg.ConcatEntry{{Path: head}, {Path: tail}})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args = []string{"-y", "-f", "concat", "-safe", "0", "-i", list, "-map", "0", "-c", "copy"}
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".mp4", ".m4v", ".mov":
+		if enc.tag != "" {
+			args = append(args, "-tag:v", enc.tag)
+		}
+	}
+	args = append(args, output)
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	joined, err := ffmpeg.New(cfg.FFmpeg).Input(output).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if v := videoStream(joined); v == nil || videoKey(v) != videoKey(source) {
+		return accurate(ctx, cfg, input, output, start, end)
+	}
+
+	return result(ctx, cfg, output, ModeSmart, start)
+}
+
+// matchingEncode returns encoder arguments reproducing the codecs and
+// parameters of the input's video and audio streams, so the encoded head
+// can be joined with a stream copied tail
+func matchingEncode(metadata transcoder.Metadata) ([]string, bool) {
+	var args []string
+	video, audio := 0, 0
+
+	for _, s := range metadata.GetStreams() {
+		switch s.GetCodecType() {
+		case "video":
+			enc, ok := videoEncoders[s.GetCodecName()]
+			if !ok {
+				return nil, false
+			}
+			spec := fmt.Sprintf(":v:%d", video)
+			args = append(args, "-c"+spec, enc.encoder, "-pix_fmt"+spec, s.GetPixFmt())
+			if rate := s.GetRFrameRrate(); rate != "" && rate != "0/0" {
+				args = append(args, "-r"+spec, rate)
+			}
+
+			params := []string{"repeat-headers=1"}
+			if profile := encoderProfile(s.GetProfile()); profile != "" && enc.params != "" {
+				args = append(args, "-profile"+spec, profile)
+			}
+			switch level := s.GetLevel(); {
+			case level <= 0:
+			case s.GetCodecName() == "h264":
+				args = append(args, "-level"+spec, fmt.Sprintf("%d.%d", level/10, level%10))
+			case s.GetCodecName() == "hevc":
+				params = append(params, fmt.Sprintf("level-idc=%d.%d", level/30, level%30/3))
+			}
+			if enc.params != "" {
+				args = append(args, enc.params+spec, strings.Join(params, ":"))
+			}
+			video++
+		case "audio":
+			encoder, ok := audioEncoders[s.GetCodecName()]
+			if !ok {
+				return nil, false
+			}
+			spec := fmt.Sprintf(":a:%d", audio)
+			args = append(args, "-c"+spec, encoder)
+			if a, ok := s.(transcoder.AudioStreams); ok {
+				if rate := a.GetSampleRate(); rate != "" {
+					args = append(args, "-ar"+spec, rate)
+				}
+				if channels := a.GetChannels(); channels > 0 {
+					args = append(args, "-ac"+spec, strconv.Itoa(channels))
+				}
+			}
+			audio++
+		}
+	}
+
+	return args, video == 1
+}
+
+// encoderProfile converts a profile reported by ffprobe, such as "High" or
+// "Main 10", to the libx264 and libx265 profile names
+func encoderProfile(profile string) string {
+	switch p := strings.ToLower(strings.Replace(profile, " ", "", -1)); p {
+	case "constrainedbaseline":
+		return "baseline"
+	case "baseline", "main", "high", "high10", "main10", "mainstillpicture":
+		return p
+	case "high4:2:2":
+		return "high422"
+	case "high4:4:4predictive":
+		return "high444"
+	}
+	return ""
+}
+
+// videoStream returns the first video stream
+func videoStream(metadata transcoder.Metadata) transcoder.Streams {
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			return s
+		}
+	}
+	return nil
+}
+
+// videoKey lists the parameters a smart cut must preserve
+func videoKey(s transcoder.Streams) string {
+	return fmt.Sprintf("%s/%s/%d/%dx%d/%s", s.GetCodecName(), s.GetProfile(), s.GetLevel(),
+		s.GetWidth(), s.GetHeight(), s.GetPixFmt())
+}
+
+// result measures the cut output
+func result(ctx context.Context, cfg Config, output string, mode Mode, start float64) (*Result, error) {
+	duration, err := ffmpeg.Duration(ctx, cfg.FFmpeg, output)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Mode: mode, Start: start, End: start + duration}, nil
+}
+
+// keyframeBefore returns the last keyframe at or before t
+func keyframeBefore(keyframes []float64, t float64) float64 {
+	kf := 0.0
+	for _, k := range keyframes {
+		if k > t {
+			break
+		}
+		kf = k
+	}
+	return kf
+}
+
+// keyframeAfter returns the first keyframe at or after t, or +Inf
+func keyframeAfter(keyframes []float64, t float64) float64 {
+	for _, k := range keyframes {
+		if k >= t {
+			return k
+		}
+	}
+	return math.Inf(1)
+}
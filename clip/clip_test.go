@@ -0,0 +1,144 @@
+package clip_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/clip"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+// fakeSource has a keyframe every two seconds. Cut outputs report the
+// duration requested with -t, summed across the parts of smart cuts
+func fakeSource() *ffmpeg.FakeRunner {
+	runner := &ffmpeg.FakeRunner{}
+	runner.Script = func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		args := strings.Join(cmd.Args, " ")
+		switch {
+		case strings.Contains(args, "-show_streams"):
+			return ffmpeg.FakeResult{Stdout: transcodertest.Probe(time.Minute,
+				transcodertest.VideoStream("h264", 1920, 1080), transcodertest.AudioStream("aac"))}
+		case strings.Contains(args, "packet=pts_time,flags"):
+			var b strings.Builder
+			for t := 0; t < 60; t += 2 {
+				fmt.Fprintf(&b, "%d.000000,K_\n", t)
+			}
+			return ffmpeg.FakeResult{Stdout: b.String()}
+		case strings.Contains(args, "format=duration"):
+			return ffmpeg.FakeResult{Stdout: "4.500000\n"}
+		}
+		return ffmpeg.FakeResult{}
+	}
+	return runner
+}
+
+func TestClipModes(t *testing.T) {
+	cases := []struct {
+		mode  clip.Mode
+		start float64
+		want  []string
+	}{
+		{clip.ModeFast, 5.5, []string{"-ss 4.000000 -i in.mp4 -t 6.000000 -map 0 -c copy"}},
+		{clip.ModeAccurate, 5.5, []string{"-ss 5.500000 -i in.mp4 -t 4.500000"}},
+		{clip.ModeSmart, 5.5, []string{
+			"-ss 5.500000 -i in.mp4 -t 0.500000 -map 0:v -map 0:a? -c:v:0 libx264 -pix_fmt:v:0 yuv420p -r:v:0 25/1 " +
+				"-x264-params:v:0 repeat-headers=1 -c:a:0 aac -ar:a:0 48000 -ac:a:0 2 -f mpegts",
+			"-ss 6.000000 -i in.mp4 -t 4.000000 -map 0:v -map 0:a? -c copy -bsf:v h264_mp4toannexb -avoid_negative_ts make_zero -f mpegts",
+			"-f concat -safe 0",
+		}},
+		{clip.ModeSmart, 6, []string{"-ss 6.000000 -i in.mp4 -t 4.000000 -map 0 -c copy"}},
+	}
+
+	for _, c := range cases {
+		runner := fakeSource()
+		result, err := clip.Clip(context.Background(), clip.Config{
+			FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		}, "in.mp4", "out.mp4", c.start, 10, c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var encodes []string
+		for _, call := range runner.Calls() {
+			if call.Path == "ffmpeg" {
+				encodes = append(encodes, strings.Join(call.Args, " "))
+			}
+		}
+		if len(encodes) != len(c.want) {
+			t.Fatalf("%s at %v: ran %q", c.mode, c.start, encodes)
+		}
+		for i, want := range c.want {
+			if !strings.Contains(encodes[i], want) {
+				t.Errorf("%s at %v: command %d = %q, want %q", c.mode, c.start, i, encodes[i], want)
+			}
+		}
+
+		wantStart := c.start
+		if c.mode == clip.ModeFast {
+			wantStart = 4
+		}
+		if result.Start != wantStart || result.End != wantStart+4.5 {
+			t.Errorf("%s at %v: achieved %v-%v", c.mode, c.start, result.Start, result.End)
+		}
+	}
+}
+
+func TestSmartClipVerifiesJoin(t *testing.T) {
+	source := transcodertest.VideoStream("h264", 1920, 1080)
+	source.Profile, source.Level = "High", 40
+
+	cases := []struct {
+		joined ffmpeg.Streams
+		mode   clip.Mode
+	}{
+		{source, clip.ModeSmart},
+		// A head encoded with other parameters than the copied tail
+		{transcodertest.VideoStream("h264", 1920, 1080), clip.ModeAccurate},
+	}
+
+	for _, c := range cases {
+		runner := fakeSource()
+		script := runner.Script
+		joined := c.joined
+		runner.Script = func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+			if strings.Contains(strings.Join(cmd.Args, " "), "-show_streams") {
+				stream := source
+				if cmd.Args[1] == "out.mp4" {
+					stream = joined
+				}
+				return ffmpeg.FakeResult{Stdout: transcodertest.Probe(time.Minute, stream, transcodertest.AudioStream("aac"))}
+			}
+			return script(cmd)
+		}
+
+		result, err := clip.Clip(context.Background(), clip.Config{
+			FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		}, "in.mp4", "out.mp4", 5.5, 10, clip.ModeSmart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Mode != c.mode {
+			t.Errorf("joined %s/%d: mode %s, want %s", c.joined.Profile, c.joined.Level, result.Mode, c.mode)
+		}
+
+		var encodes []string
+		for _, call := range runner.Calls() {
+			if call.Path == "ffmpeg" {
+				encodes = append(encodes, strings.Join(call.Args, " "))
+			}
+		}
+		if !strings.Contains(encodes[0], "-profile:v:0 high -level:v:0 4.0") {
+			t.Errorf("head encode %q does not match the source profile and level", encodes[0])
+		}
+		if !strings.Contains(encodes[2], "-tag:v avc3 out.mp4") {
+			t.Errorf("join %q does not signal in-band parameter sets", encodes[2])
+		}
+		if c.mode == clip.ModeAccurate && (len(encodes) != 4 || !strings.Contains(encodes[3], "-ss 5.500000 -i in.mp4 -t 4.500000")) {
+			t.Errorf("mismatched join not re-encoded: %q", encodes)
+		}
+	}
+}
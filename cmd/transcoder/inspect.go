@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/utils"
+)
+
+// probe prints the metadata of files
+func (a *app) probe(ctx context.Context, args []string) error {
+	fs, bins := a.flags("probe", "[flags] file...")
+	asJSON := fs.Bool("json", false, "print ffprobe's metadata as JSON")
+	if err := parse(fs, args, 1, -1); err != nil {
+		return err
+	}
+
+	cfg := a.config(bins)
+
+	var all []transcoder.Metadata
+	for _, file := range fs.Args() {
+		metadata, err := ffmpeg.New(cfg).Input(file).WithContext(&ctx).GetMetadata()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		all = append(all, metadata)
+	}
+
+	if *asJSON {
+		if len(all) == 1 {
+			return writeJSON(a.stdout, all[0])
+		}
+		return writeJSON(a.stdout, all)
+	}
+
+	for i, metadata := range all {
+		if i > 0 {
+			fmt.Fprintln(a.stdout)
+		}
+		printMetadata(a.stdout, fs.Arg(i), metadata)
+	}
+	return nil
+}
+
+// printMetadata prints a format summary and a line per stream
+func printMetadata(w io.Writer, file string, m transcoder.Metadata) {
+	f := m.GetFormat()
+
+	fmt.Fprintf(w, "%s\n", file)
+	fmt.Fprintf(w, "  format:   %s\n", f.GetFormatName())
+	if d, err := strconv.ParseFloat(f.GetDuration(), 64); err == nil {
+		fmt.Fprintf(w, "  duration: %s\n", time.Duration(d*float64(time.Second)).Round(time.Millisecond))
+	}
+	if br, err := strconv.Atoi(f.GetBitRate()); err == nil {
+		fmt.Fprintf(w, "  bitrate:  %d kb/s\n", br/1000)
+	}
+
+	for _, s := range m.GetStreams() {
+		details := []string{s.GetCodecName()}
+		switch s.GetCodecType() {
+		case "video":
+			details = append(details, fmt.Sprintf("%dx%d", s.GetWidth(), s.GetHeight()))
+			if s.GetPixFmt() != "" {
+				details = append(details, s.GetPixFmt())
+			}
+			if s.GetAvgFrameRate() != "" && s.GetAvgFrameRate() != "0/0" {
+				details = append(details, s.GetAvgFrameRate()+" fps")
+			}
+		case "audio":
+			a, ok := s.(transcoder.AudioStreams)
+			if !ok {
+				break
+			}
+			if a.GetSampleRate() != "" {
+				details = append(details, a.GetSampleRate()+" Hz")
+			}
+			if a.GetChannelLayout() != "" {
+				details = append(details, a.GetChannelLayout())
+			} else if a.GetChannels() > 0 {
+				details = append(details, fmt.Sprintf("%d channels", a.GetChannels()))
+			}
+		}
+		if tagged, ok := s.(transcoder.TaggedStreams); ok {
+			if labels, ok := tagged.GetTags().(transcoder.Labels); ok && labels.GetLanguage() != "" {
+				details = append(details, "("+labels.GetLanguage()+")")
+			}
+		}
+		fmt.Fprintf(w, "  #%d %-9s %s\n", s.GetIndex(), s.GetCodecType()+":", strings.Join(details, ", "))
+	}
+}
+
+// presetInfo is the JSON form of a preset
+type presetInfo struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Extends     string         `json:"extends,omitempty"`
+	Options     ffmpeg.Options `json:"options"`
+}
+
+// listPresets lists the registered presets, or shows the resolved options
+// of one
+func (a *app) listPresets(ctx context.Context, args []string) error {
+	fs, _ := a.flags("presets", "[flags] [name]")
+	asJSON := fs.Bool("json", false, "print presets as JSON")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	if name := fs.Arg(0); name != "" {
+		p, ok := a.presets.Get(name)
+		if !ok {
+			return fmt.Errorf("unknown preset %q", name)
+		}
+		opts, _ := a.presets.Lookup(name)
+
+		if *asJSON {
+			return writeJSON(a.stdout, presetInfo{p.Name, p.Description, p.Extends, opts})
+		}
+		fmt.Fprintf(a.stdout, "%s: %s\n", p.Name, p.Description)
+		if p.Extends != "" {
+			fmt.Fprintf(a.stdout, "extends: %s\n", p.Extends)
+		}
+		fmt.Fprintln(a.stdout, utils.ShellQuote(opts.GetStrArguments()))
+		return nil
+	}
+
+	var infos []presetInfo
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	for _, name := range a.presets.Names() {
+		p, _ := a.presets.Get(name)
+		if *asJSON {
+			opts, _ := a.presets.Lookup(name)
+			infos = append(infos, presetInfo{p.Name, p.Description, p.Extends, opts})
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", p.Name, p.Description)
+	}
+
+	if *asJSON {
+		return writeJSON(a.stdout, infos)
+	}
+	return tw.Flush()
+}
+
+// capabilitiesInfo is the JSON form of ffmpeg.Capabilities
+type capabilitiesInfo struct {
+	Version  string   `json:"version"`
+	Hwaccels []string `json:"hwaccels"`
+	Encoders []string `json:"encoders"`
+	Decoders []string `json:"decoders"`
+	Filters  []string `json:"filters"`
+}
+
+// capabilities prints what the ffmpeg build supports
+func (a *app) capabilities(ctx context.Context, args []string) error {
+	fs, bins := a.flags("capabilities", "[flags]")
+	asJSON := fs.Bool("json", false, "print capabilities as JSON")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	c, err := ffmpeg.DetectCapabilities(ctx, a.config(bins))
+	if err != nil {
+		return err
+	}
+
+	info := capabilitiesInfo{
+		Version:  c.Version,
+		Hwaccels: sorted(c.Hwaccels),
+		Encoders: sorted(c.Encoders),
+		Decoders: sorted(c.Decoders),
+		Filters:  sorted(c.Filters),
+	}
+	if *asJSON {
+		return writeJSON(a.stdout, info)
+	}
+
+	fmt.Fprintf(a.stdout, "version:  %s\n", info.Version)
+	fmt.Fprintf(a.stdout, "hwaccels: %s\n", strings.Join(info.Hwaccels, " "))
+	fmt.Fprintf(a.stdout, "encoders: %s\n", strings.Join(info.Encoders, " "))
+	fmt.Fprintf(a.stdout, "decoders: %d\n", len(info.Decoders))
+	fmt.Fprintf(a.stdout, "filters:  %d\n", len(info.Filters))
+	return nil
+}
+
+// sorted returns the keys of a set in order
+func sorted(set map[string]bool) []string {
+	keys := []string{}
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeJSON writes v indented
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
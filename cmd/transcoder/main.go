@@ -0,0 +1,140 @@
+// Command transcoder runs job specs, probes files and inspects presets and
+// the ffmpeg build, through the same code path as the library.
+//
+// Usage:
+//
+//	transcoder run [-dry-run] [-quiet] spec.yaml
+//	transcoder probe [-json] file...
+//	transcoder presets [-json] [name]
+//	transcoder capabilities [-json]
+//
+// Every command accepts -ffmpeg and -ffprobe to pick the binaries.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/preset"
+)
+
+const usage = `usage: transcoder <command> [flags] [args]
+
+commands:
+  run           run a job spec file (.json, .yaml)
+  probe         print the streams and format of media files
+  presets       list presets or show the options of one
+  capabilities  list what the ffmpeg build supports
+
+Run "transcoder <command> -h" for the flags of a command.
+`
+
+// errUsage reports a command line error, already explained to the user
+var errUsage = errors.New("usage")
+
+// app holds what commands share. Tests swap the runner and outputs
+type app struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	runner  ffmpeg.Runner
+	presets *preset.Registry
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first interrupt stops running jobs gracefully
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+		signal.Stop(sig)
+	}()
+
+	a := &app{stdout: os.Stdout, stderr: os.Stderr, presets: preset.Default}
+	os.Exit(a.run(ctx, os.Args[1:]))
+}
+
+// run dispatches args to a command and returns the exit status
+func (a *app) run(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(a.stderr, usage)
+		return 2
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"run":          a.runJob,
+		"probe":        a.probe,
+		"presets":      a.listPresets,
+		"capabilities": a.capabilities,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		if args[0] != "-h" && args[0] != "-help" && args[0] != "help" {
+			fmt.Fprintf(a.stderr, "unknown command %q\n", args[0])
+		}
+		fmt.Fprint(a.stderr, usage)
+		return 2
+	}
+
+	switch err := command(ctx, args[1:]); {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		return 2
+	default:
+		fmt.Fprintf(a.stderr, "transcoder %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// binaries holds the binary flags every command accepts
+type binaries struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+// flags returns a flag set for a command with the binary flags registered
+func (a *app) flags(name, synopsis string) (*flag.FlagSet, *binaries) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "usage: transcoder %s %s\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+
+	b := &binaries{}
+	fs.StringVar(&b.ffmpeg, "ffmpeg", "ffmpeg", "ffmpeg binary")
+	fs.StringVar(&b.ffprobe, "ffprobe", "ffprobe", "ffprobe binary")
+	return fs, b
+}
+
+// config returns the ffmpeg configuration of a command
+func (a *app) config(b *binaries) *ffmpeg.Config {
+	return &ffmpeg.Config{
+		FfmpegBinPath:  b.ffmpeg,
+		FfprobeBinPath: b.ffprobe,
+		Runner:         a.runner,
+	}
+}
+
+// parse parses a command's flags and checks its argument count
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if n := fs.NArg(); n < min || (max >= 0 && n > max) {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
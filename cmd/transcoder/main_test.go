@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/preset"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+const spec = `
+inputs:
+  - path: in.mov
+outputs:
+  - path: out.mp4
+    preset: web-h264
+    options: {crf: 20}
+`
+
+// newApp returns an app on runner, capturing its output
+func newApp(runner ffmpeg.Runner) (*app, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	return &app{stdout: stdout, stderr: stderr, runner: runner, presets: preset.New()}, stdout, stderr
+}
+
+// writeSpec writes a job spec file, removed when t finishes
+func writeSpec(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "transcoder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "job.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunDryRun(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{})
+	a, stdout, stderr := newApp(runner)
+
+	if code := a.run(context.Background(), []string{"run", "-dry-run", "-ffmpeg", "/opt/ffmpeg", writeSpec(t, spec)}); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+
+	got := strings.TrimSpace(stdout.String())
+	if !strings.HasPrefix(got, "/opt/ffmpeg -i in.mov ") || !strings.Contains(got, "-crf 20") || !strings.HasSuffix(got, " out.mp4") {
+		t.Errorf("argv %s", got)
+	}
+	if calls := runner.Calls(); len(calls) != 0 {
+		t.Errorf("dry run started %d processes", len(calls))
+	}
+}
+
+func TestRun(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(10*time.Second, 4)},
+		transcodertest.Script{Stdout: transcodertest.Probe(10*time.Second, transcodertest.VideoStream("h264", 1920, 1080))},
+	)
+	a, _, stderr := newApp(runner)
+
+	if code := a.run(context.Background(), []string{"run", writeSpec(t, spec)}); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr.String(), "[##############################] 100.0%") {
+		t.Errorf("progress bar %q", stderr)
+	}
+
+	calls := runner.Calls()
+	if last := calls[len(calls)-1]; last.Path != "ffmpeg" || last.Args[len(last.Args)-1] != "out.mp4" {
+		t.Errorf("ran %s %v", last.Path, last.Args)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: "in.mov: No such file or directory\n", ExitCode: 1},
+		transcodertest.Script{},
+	)
+	a, _, stderr := newApp(runner)
+
+	if code := a.run(context.Background(), []string{"run", "-quiet", writeSpec(t, spec)}); code != 1 {
+		t.Fatalf("exit %d", code)
+	}
+	if !strings.Contains(stderr.String(), "transcoder run:") {
+		t.Errorf("stderr %q", stderr)
+	}
+}
+
+func TestRunInvalidSpec(t *testing.T) {
+	a, _, stderr := newApp(nil)
+
+	path := writeSpec(t, "inputs: [{path: in.mov}]\noutputs: [{path: out.mp4, preset: missing}]\n")
+	if code := a.run(context.Background(), []string{"run", "-dry-run", path}); code != 1 {
+		t.Fatalf("exit %d", code)
+	}
+	if !strings.Contains(stderr.String(), `unknown preset "missing"`) {
+		t.Errorf("stderr %q", stderr)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	video := transcodertest.VideoStream("h264", 1280, 720)
+	audio := transcodertest.AudioStream("aac")
+	audio.Tags.Language = "eng"
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{
+		Stdout: transcodertest.Probe(90*time.Second, video, audio),
+	})
+
+	a, stdout, stderr := newApp(runner)
+	if code := a.run(context.Background(), []string{"probe", "in.mp4"}); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	for _, want := range []string{"duration: 1m30s", "#0 video:    h264, 1280x720, yuv420p, 25/1 fps", "#1 audio:    aac, 48000 Hz, stereo, (eng)"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, stdout)
+		}
+	}
+
+	a, stdout, _ = newApp(runner)
+	if code := a.run(context.Background(), []string{"probe", "-json", "in.mp4"}); code != 0 {
+		t.Fatalf("json exit %d", code)
+	}
+	var m ffmpeg.Metadata
+	if err := json.Unmarshal(stdout.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Streams) != 2 || m.Streams[1].CodecName != "aac" {
+		t.Errorf("metadata %+v", m)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	a, stdout, _ := newApp(nil)
+	if code := a.run(context.Background(), []string{"presets"}); code != 0 {
+		t.Fatalf("exit %d", code)
+	}
+	if !strings.Contains(stdout.String(), "opus-voice") || !strings.Contains(stdout.String(), "prores-proxy") {
+		t.Errorf("list:\n%s", stdout)
+	}
+
+	a, stdout, _ = newApp(nil)
+	if code := a.run(context.Background(), []string{"presets", "hls-360p"}); code != 0 {
+		t.Fatalf("show exit %d", code)
+	}
+	if !strings.Contains(stdout.String(), "extends: hls") || !strings.Contains(stdout.String(), "-b:v 365k") {
+		t.Errorf("show:\n%s", stdout)
+	}
+
+	a, _, _ = newApp(nil)
+	if code := a.run(context.Background(), []string{"presets", "missing"}); code != 1 {
+		t.Errorf("missing preset exit %d", code)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		switch cmd.Args[len(cmd.Args)-1] {
+		case "-version":
+			return ffmpeg.FakeResult{Stdout: "ffmpeg version 6.1 Copyright (c) 2000-2023\n"}
+		case "-hwaccels":
+			return ffmpeg.FakeResult{Stdout: "Hardware acceleration methods:\nvaapi\ncuda\n"}
+		case "-encoders":
+			return ffmpeg.FakeResult{Stdout: "Encoders:\n ------\n V....D libx264              libx264 H.264\n A....D aac                  AAC\n"}
+		}
+		return ffmpeg.FakeResult{}
+	}}
+
+	a, stdout, stderr := newApp(runner)
+	if code := a.run(context.Background(), []string{"capabilities", "-json"}); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+
+	var info capabilitiesInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "6.1" || strings.Join(info.Hwaccels, ",") != "cuda,vaapi" || strings.Join(info.Encoders, ",") != "aac,libx264" {
+		t.Errorf("capabilities %+v", info)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	a, _, stderr := newApp(nil)
+	if code := a.run(context.Background(), []string{"transcode"}); code != 2 {
+		t.Errorf("unknown command exit %d", code)
+	}
+	if !strings.Contains(stderr.String(), "usage: transcoder") {
+		t.Errorf("stderr %q", stderr)
+	}
+
+	a, _, _ = newApp(nil)
+	if code := a.run(context.Background(), []string{"run"}); code != 2 {
+		t.Errorf("missing spec exit %d", code)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/job"
+	"github.com/floostack/transcoder/utils"
+)
+
+// runJob loads a job spec and runs it, drawing a progress bar on stderr
+func (a *app) runJob(ctx context.Context, args []string) error {
+	fs, bins := a.flags("run", "[flags] spec")
+	dryRun := fs.Bool("dry-run", false, "print the ffmpeg command instead of running it")
+	quiet := fs.Bool("quiet", false, "do not draw the progress bar")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	spec, err := job.Load(fs.Arg(0), a.presets)
+	if err != nil {
+		return err
+	}
+
+	cfg := a.config(bins)
+	cfg.DryRun = *dryRun
+	cfg.ProgressEnabled = !*quiet
+
+	t, err := spec.Transcoder(cfg, a.presets)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		argv, err := t.(transcoder.Commander).Command()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(a.stdout, utils.ShellQuote(argv))
+		return nil
+	}
+
+	t = t.WithContext(&ctx)
+	progress, err := t.Start()
+	if err != nil {
+		return err
+	}
+
+	bar := &progressBar{w: a.stderr, width: 30}
+	for p := range progress {
+		bar.update(p)
+	}
+	bar.done()
+
+	if err := t.(transcoder.Stopper).Wait(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted, outputs finalized early")
+	}
+	return nil
+}
+
+// progressBar redraws a single status line
+type progressBar struct {
+	w     io.Writer
+	width int
+	drawn bool
+}
+
+// update redraws the bar for p
+func (b *progressBar) update(p transcoder.Progress) {
+	percent := p.GetProgress()
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	filled := int(percent / 100 * float64(b.width))
+	line := fmt.Sprintf("[%s%s] %5.1f%%",
+		strings.Repeat("#", filled), strings.Repeat("-", b.width-filled), percent)
+	timing, timed := p.(transcoder.Timing)
+	if timed {
+		line += fmt.Sprintf(" %s elapsed", timing.GetElapsed().Round(time.Second))
+	}
+	if speed := p.GetSpeed(); speed != "" {
+		line += " speed=" + speed
+	}
+	if timed && timing.GetETA() > 0 {
+		line += fmt.Sprintf(" eta %s", timing.GetETA().Round(time.Second))
+	}
+
+	// Trailing spaces erase what remains of a longer previous line
+	fmt.Fprintf(b.w, "\r%-80s", line)
+	b.drawn = true
+}
+
+// done ends the bar's line
+func (b *progressBar) done() {
+	if b.drawn {
+		fmt.Fprintln(b.w)
+	}
+}
@@ -0,0 +1,154 @@
+package codec
+
+import (
+	"strconv"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// AudioFormat holds the output channel count and sample rate shared by
+// audio encoders
+type AudioFormat struct {
+	Channels   int
+	SampleRate int
+}
+
+// apply ...
+func (f AudioFormat) apply(opts *ffmpeg.Options) {
+	if f.Channels > 0 {
+		channels := f.Channels
+		opts.AudioChannels = &channels
+	}
+	if f.SampleRate > 0 {
+		rate := f.SampleRate
+		opts.AudioRate = &rate
+	}
+}
+
+// AAC configures ffmpeg's native AAC encoder, or libfdk_aac when FDK is set
+type AAC struct {
+	FDK bool
+	// Profile is aac_low, aac_he or aac_he_v2 (the HE profiles need FDK),
+	// or aac_ltp or aac_main
+	Profile string
+	// Bitrate such as "128k"
+	Bitrate string
+	// VBR from 1 to 5, libfdk_aac only
+	VBR *int
+	AudioFormat
+}
+
+// Validate ...
+func (c AAC) Validate() error {
+	profiles := []string{"aac_low", "aac_ltp", "aac_main", "mpeg2_aac_low"}
+	if c.FDK {
+		profiles = []string{"aac_low", "aac_he", "aac_he_v2", "aac_ld", "aac_eld"}
+	}
+
+	err := firstError(
+		oneOf("aac", "profile", c.Profile, profiles...),
+		inRange("aac", "vbr", c.VBR, 1, 5),
+	)
+	if err == nil && c.VBR != nil && !c.FDK {
+		err = invalid("aac", "vbr", "requires libfdk_aac")
+	}
+	return err
+}
+
+// Apply ...
+func (c AAC) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	opts.AudioCodec = str("aac")
+	if c.FDK {
+		opts.AudioCodec = str("libfdk_aac")
+	}
+	if c.Profile != "" {
+		opts.AudioProfile = str(c.Profile)
+	}
+	if c.Bitrate != "" {
+		opts.AudioBitrate = str(c.Bitrate)
+	}
+	if c.VBR != nil {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-vbr", *c.VBR)
+	}
+	c.AudioFormat.apply(opts)
+
+	return nil
+}
+
+// Opus configures libopus
+type Opus struct {
+	// Bitrate such as "96k"
+	Bitrate string
+	// Application is voip, audio or lowdelay
+	Application string
+	// VBR is on, off or constrained
+	VBR string
+	// CompressionLevel from 0 (fastest) to 10
+	CompressionLevel *int
+	// FrameDuration in milliseconds: 2.5, 5, 10, 20, 40, 60, 80, 100 or 120
+	FrameDuration float64
+	AudioFormat
+}
+
+var opusFrameDurations = []float64{2.5, 5, 10, 20, 40, 60, 80, 100, 120}
+
+// Validate ...
+func (c Opus) Validate() error {
+	err := firstError(
+		oneOf("opus", "application", c.Application, "voip", "audio", "lowdelay"),
+		oneOf("opus", "vbr", c.VBR, "on", "off", "constrained"),
+		inRange("opus", "compression level", c.CompressionLevel, 0, 10),
+	)
+	if err != nil {
+		return err
+	}
+
+	switch c.SampleRate {
+	case 0, 8000, 12000, 16000, 24000, 48000:
+	default:
+		return invalid("opus", "sample rate", c.SampleRate)
+	}
+
+	if c.FrameDuration != 0 {
+		for _, d := range opusFrameDurations {
+			if d == c.FrameDuration {
+				return nil
+			}
+		}
+		return invalid("opus", "frame duration", c.FrameDuration)
+	}
+
+	return nil
+}
+
+// Apply ...
+func (c Opus) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	opts.AudioCodec = str("libopus")
+	if c.Bitrate != "" {
+		opts.AudioBitrate = str(c.Bitrate)
+	}
+	if c.Application != "" {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-application", c.Application)
+	}
+	if c.VBR != "" {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-vbr", c.VBR)
+	}
+	if c.CompressionLevel != nil {
+		level := *c.CompressionLevel
+		opts.CompressionLevel = &level
+	}
+	if c.FrameDuration != 0 {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-frame_duration", strconv.FormatFloat(c.FrameDuration, 'f', -1, 64))
+	}
+	c.AudioFormat.apply(opts)
+
+	return nil
+}
@@ -0,0 +1,100 @@
+// Package codec provides typed, validated encoder settings.
+//
+// ffmpeg.Options is a flat set of flags whose values mean different things
+// to different encoders. The configs here name each encoder's options, check
+// their values and write them into Options, as fields where one exists and
+// as extra arguments otherwise:
+//
+//	opts, err := codec.Apply(ffmpeg.Options{}, codec.X264{Preset: codec.Slow, CRF: codec.Int(20)}, codec.AAC{Bitrate: "128k"})
+package codec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Encoder is a typed encoder config
+type Encoder interface {
+	// Validate reports the first invalid setting
+	Validate() error
+	// Apply validates the config and writes it into opts
+	Apply(opts *ffmpeg.Options) error
+}
+
+// Apply returns a copy of opts with encoders applied in order
+func Apply(opts ffmpeg.Options, encoders ...Encoder) (ffmpeg.Options, error) {
+	for _, e := range encoders {
+		if err := e.Apply(&opts); err != nil {
+			return ffmpeg.Options{}, err
+		}
+	}
+	return opts, nil
+}
+
+// Int returns a pointer to v, for optional settings where zero is valid
+func Int(v int) *int {
+	return &v
+}
+
+// invalid returns an ffmpeg.ErrInvalidArgument describing a bad setting
+func invalid(encoder, setting string, value interface{}) error {
+	return fmt.Errorf("%w: %s %s %v", ffmpeg.ErrInvalidArgument, encoder, setting, value)
+}
+
+// oneOf checks that value, when set, is one of valid
+func oneOf(encoder, setting, value string, valid ...string) error {
+	if value == "" {
+		return nil
+	}
+	for _, v := range valid {
+		if v == value {
+			return nil
+		}
+	}
+	return invalid(encoder, setting, fmt.Sprintf("%q (want one of %s)", value, strings.Join(valid, ", ")))
+}
+
+// inRange checks that value, when set, lies within [min, max]
+func inRange(encoder, setting string, value *int, min, max int) error {
+	if value == nil || (*value >= min && *value <= max) {
+		return nil
+	}
+	return invalid(encoder, setting, fmt.Sprintf("%d (want %d to %d)", *value, min, max))
+}
+
+// firstError ...
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// params joins key=value pairs with colons, as x264-params and its
+// siblings expect
+func params(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+m[k])
+	}
+	return strings.Join(pairs, ":")
+}
+
+// str returns a pointer to a copy of s, or nil when s is empty
+func str(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,93 @@
+package codec_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/floostack/transcoder/codec"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoders []codec.Encoder
+		want     string
+	}{
+		{"x264", []codec.Encoder{codec.X264{
+			Preset: codec.Slow, Tune: "film", Profile: "high", Level: "4.1", CRF: codec.Int(20),
+			RateControl: codec.RateControl{MaxRate: 6000000, BufSize: 12000000},
+			Keyint:      48, Bframes: codec.Int(3), Params: map[string]string{"rc-lookahead": "40", "aq-mode": "3"},
+		}}, "-maxrate 6000000 -c:v libx264 -g 48 -bufsize 12000000 -preset slow -tune film -profile:v high -crf 20 -bf 3 -level:v 4.1 -x264-params aq-mode=3:rc-lookahead=40"},
+		{"x265", []codec.Encoder{codec.X265{Preset: codec.Medium, Profile: "main10", CRF: codec.Int(0)}},
+			"-c:v libx265 -preset medium -profile:v main10 -crf 0"},
+		{"av1", []codec.Encoder{codec.AV1{Preset: codec.Int(6), CRF: codec.Int(32), FilmGrain: codec.Int(8), Params: map[string]string{"tune": "0"}}},
+			"-c:v libsvtav1 -preset 6 -crf 32 -svtav1-params film-grain=8:tune=0"},
+		{"vp9 constant quality", []codec.Encoder{codec.VP9{Deadline: "good", CPUUsed: codec.Int(2), CRF: codec.Int(31), RowMT: true}},
+			"-b:v 0 -c:v libvpx-vp9 -crf 31 -cpu-used 2 -deadline good -row-mt 1"},
+		{"video and audio", []codec.Encoder{codec.X264{CRF: codec.Int(23)}, codec.AAC{Bitrate: "128k", AudioFormat: codec.AudioFormat{Channels: 2}}},
+			"-c:v libx264 -c:a aac -ab 128k -ac 2 -crf 23"},
+		{"fdk aac", []codec.Encoder{codec.AAC{FDK: true, Profile: "aac_he", VBR: codec.Int(4)}},
+			"-c:a libfdk_aac -profile:a aac_he -vbr 4"},
+		{"opus", []codec.Encoder{codec.Opus{Bitrate: "32k", Application: "voip", VBR: "constrained", CompressionLevel: codec.Int(10), FrameDuration: 60}},
+			"-c:a libopus -ab 32k -compression_level 10 -application voip -frame_duration 60 -vbr constrained"},
+		{"prores", []codec.Encoder{codec.ProRes{Profile: codec.ProRes4444, Alpha: true, Vendor: "apl0"}},
+			"-c:v prores_ks -profile:v 4 -pix_fmt yuva444p10le -vendor apl0"},
+		{"prores default", []codec.Encoder{codec.ProRes{}},
+			"-c:v prores_ks -profile:v 2 -pix_fmt yuv422p10le"},
+		{"dnxhr", []codec.Encoder{codec.DNxHR{Profile: codec.DNxHRHQX}},
+			"-c:v dnxhd -profile:v dnxhr_hqx -pix_fmt yuv422p10le"},
+	}
+
+	for _, tt := range tests {
+		opts, err := codec.Apply(ffmpeg.Options{}, tt.encoders...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := strings.Join(opts.GetStrArguments(), " "); got != tt.want {
+			t.Errorf("%s:\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []codec.Encoder{
+		codec.X264{Preset: "turbo"},
+		codec.X264{CRF: codec.Int(52)},
+		codec.X265{Tune: "film"},
+		codec.AV1{Preset: codec.Int(14)},
+		codec.VP9{CPUUsed: codec.Int(9)},
+		codec.VP9{Deadline: "fast"},
+		codec.AAC{VBR: codec.Int(3)},
+		codec.AAC{Profile: "aac_he"},
+		codec.Opus{FrameDuration: 30},
+		codec.Opus{AudioFormat: codec.AudioFormat{SampleRate: 44100}},
+		codec.ProRes{Profile: "ultra"},
+		codec.ProRes{Profile: codec.ProResHQ, Alpha: true},
+		codec.DNxHR{Profile: "dnxhd_36"},
+	}
+
+	for _, e := range invalid {
+		err := e.Validate()
+		if !errors.Is(err, ffmpeg.ErrInvalidArgument) {
+			t.Errorf("%#v: err = %v, want invalid argument", e, err)
+		}
+		if _, err := codec.Apply(ffmpeg.Options{}, e); err == nil {
+			t.Errorf("%#v applied", e)
+		}
+	}
+}
+
+func TestApplyDoesNotShareExtraArgs(t *testing.T) {
+	base := ffmpeg.Options{ExtraArgs: map[string]interface{}{"-map": "0"}}
+
+	if _, err := codec.Apply(base, codec.VP9{RowMT: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"-map": "0"}; !reflect.DeepEqual(base.ExtraArgs, want) {
+		t.Errorf("base extra args changed: %v", base.ExtraArgs)
+	}
+}
@@ -0,0 +1,133 @@
+package codec
+
+import (
+	"strconv"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// ProResProfile ...
+type ProResProfile string
+
+// ProRes profiles
+const (
+	ProResProxy    ProResProfile = "proxy"
+	ProResLT       ProResProfile = "lt"
+	ProResStandard ProResProfile = "standard"
+	ProResHQ       ProResProfile = "hq"
+	ProRes4444     ProResProfile = "4444"
+	ProRes4444XQ   ProResProfile = "4444xq"
+)
+
+// proresProfiles maps profiles to prores_ks profile numbers
+var proresProfiles = map[ProResProfile]int{
+	ProResProxy:    0,
+	ProResLT:       1,
+	ProResStandard: 2,
+	ProResHQ:       3,
+	ProRes4444:     4,
+	ProRes4444XQ:   5,
+}
+
+// ProRes configures prores_ks
+type ProRes struct {
+	// Profile defaults to standard
+	Profile ProResProfile
+	// Alpha keeps an alpha channel in the 4444 profiles
+	Alpha bool
+	// Vendor is written as the encoder vendor, "apl0" to pass as Apple
+	Vendor string
+}
+
+// Validate ...
+func (c ProRes) Validate() error {
+	if _, ok := proresProfiles[c.Profile]; c.Profile != "" && !ok {
+		return invalid("prores", "profile", c.Profile)
+	}
+	if c.Alpha && c.Profile != ProRes4444 && c.Profile != ProRes4444XQ {
+		return invalid("prores", "alpha", "requires a 4444 profile")
+	}
+	return nil
+}
+
+// Apply ...
+func (c ProRes) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	profile := c.Profile
+	if profile == "" {
+		profile = ProResStandard
+	}
+
+	opts.VideoCodec = str("prores_ks")
+	opts.VideoProfile = str(strconv.Itoa(proresProfiles[profile]))
+
+	switch {
+	case c.Alpha:
+		opts.PixFmt = str("yuva444p10le")
+	case profile == ProRes4444 || profile == ProRes4444XQ:
+		opts.PixFmt = str("yuv444p10le")
+	default:
+		opts.PixFmt = str("yuv422p10le")
+	}
+	if c.Vendor != "" {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-vendor", c.Vendor)
+	}
+
+	return nil
+}
+
+// DNxHRProfile ...
+type DNxHRProfile string
+
+// DNxHR profiles
+const (
+	DNxHRLB  DNxHRProfile = "dnxhr_lb"
+	DNxHRSQ  DNxHRProfile = "dnxhr_sq"
+	DNxHRHQ  DNxHRProfile = "dnxhr_hq"
+	DNxHRHQX DNxHRProfile = "dnxhr_hqx"
+	DNxHR444 DNxHRProfile = "dnxhr_444"
+)
+
+// dnxhrPixFmts maps profiles to the pixel format they encode
+var dnxhrPixFmts = map[DNxHRProfile]string{
+	DNxHRLB:  "yuv422p",
+	DNxHRSQ:  "yuv422p",
+	DNxHRHQ:  "yuv422p",
+	DNxHRHQX: "yuv422p10le",
+	DNxHR444: "yuv444p10le",
+}
+
+// DNxHR configures the dnxhd encoder for resolution independent DNxHR
+type DNxHR struct {
+	// Profile defaults to dnxhr_sq
+	Profile DNxHRProfile
+}
+
+// Validate ...
+func (c DNxHR) Validate() error {
+	if _, ok := dnxhrPixFmts[c.Profile]; c.Profile != "" && !ok {
+		return invalid("dnxhr", "profile", c.Profile)
+	}
+	return nil
+}
+
+// Apply ...
+func (c DNxHR) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	profile := c.Profile
+	if profile == "" {
+		profile = DNxHRSQ
+	}
+
+	opts.VideoCodec = str("dnxhd")
+	opts.VideoProfile = str(string(profile))
+	opts.PixFmt = str(dnxhrPixFmts[profile])
+
+	return nil
+}
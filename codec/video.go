@@ -0,0 +1,298 @@
+package codec
+
+import (
+	"strconv"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Preset is an x264 or x265 speed preset
+type Preset string
+
+// Presets, fastest first
+const (
+	Ultrafast Preset = "ultrafast"
+	Superfast Preset = "superfast"
+	Veryfast  Preset = "veryfast"
+	Faster    Preset = "faster"
+	Fast      Preset = "fast"
+	Medium    Preset = "medium"
+	Slow      Preset = "slow"
+	Slower    Preset = "slower"
+	Veryslow  Preset = "veryslow"
+	Placebo   Preset = "placebo"
+)
+
+var presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow", "placebo"}
+
+// RateControl holds the bitrate settings shared by video encoders. Bitrate
+// takes the ffmpeg form, e.g. "4M"; MaxRate and BufSize are in bits per
+// second
+type RateControl struct {
+	Bitrate string
+	MaxRate int
+	BufSize int
+}
+
+// apply ...
+func (rc RateControl) apply(opts *ffmpeg.Options) {
+	if rc.Bitrate != "" {
+		opts.VideoBitRate = str(rc.Bitrate)
+	}
+	if rc.MaxRate > 0 {
+		maxrate := rc.MaxRate
+		opts.VideoMaxBitRate = &maxrate
+	}
+	if rc.BufSize > 0 {
+		bufsize := rc.BufSize
+		opts.BufferSize = &bufsize
+	}
+}
+
+// X264 configures libx264
+type X264 struct {
+	Preset Preset
+	// Tune is one of film, animation, grain, stillimage, fastdecode,
+	// zerolatency, psnr or ssim
+	Tune string
+	// Profile is one of baseline, main, high, high10, high422 or high444
+	Profile string
+	// Level such as "4.1"
+	Level string
+	// CRF from 0 (lossless) to 51
+	CRF *int
+	RateControl
+	// Keyint is the maximum GOP length in frames
+	Keyint int
+	// Bframes from 0 to 16
+	Bframes *int
+	// Params are passed as -x264-params
+	Params map[string]string
+}
+
+var h264Levels = []string{"1", "1b", "1.1", "1.2", "1.3", "2", "2.1", "2.2", "3", "3.1", "3.2", "4", "4.1", "4.2", "5", "5.1", "5.2", "6", "6.1", "6.2"}
+
+// Validate ...
+func (c X264) Validate() error {
+	return firstError(
+		oneOf("x264", "preset", string(c.Preset), presets...),
+		oneOf("x264", "tune", c.Tune, "film", "animation", "grain", "stillimage", "fastdecode", "zerolatency", "psnr", "ssim"),
+		oneOf("x264", "profile", c.Profile, "baseline", "main", "high", "high10", "high422", "high444"),
+		oneOf("x264", "level", c.Level, h264Levels...),
+		inRange("x264", "crf", c.CRF, 0, 51),
+		inRange("x264", "bframes", c.Bframes, 0, 16),
+	)
+}
+
+// Apply ...
+func (c X264) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	opts.VideoCodec = str("libx264")
+	applyCommon(opts, string(c.Preset), c.Tune, c.Profile, c.CRF, c.Keyint, c.Bframes)
+	c.RateControl.apply(opts)
+	if c.Level != "" {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-level:v", c.Level)
+	}
+	if len(c.Params) > 0 {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-x264-params", params(c.Params))
+	}
+
+	return nil
+}
+
+// X265 configures libx265
+type X265 struct {
+	Preset Preset
+	// Tune is one of psnr, ssim, grain, fastdecode, zerolatency or
+	// animation
+	Tune string
+	// Profile such as main, main10 or main444-10
+	Profile string
+	// CRF from 0 to 51
+	CRF *int
+	RateControl
+	Keyint int
+	// Bframes from 0 to 16
+	Bframes *int
+	// Params are passed as -x265-params
+	Params map[string]string
+}
+
+// Validate ...
+func (c X265) Validate() error {
+	return firstError(
+		oneOf("x265", "preset", string(c.Preset), presets...),
+		oneOf("x265", "tune", c.Tune, "psnr", "ssim", "grain", "fastdecode", "zerolatency", "animation"),
+		oneOf("x265", "profile", c.Profile, "main", "main-intra", "mainstillpicture", "main444-8", "main444-intra",
+			"main10", "main10-intra", "main422-10", "main422-10-intra", "main444-10", "main444-10-intra",
+			"main12", "main12-intra", "main422-12", "main422-12-intra", "main444-12", "main444-12-intra"),
+		inRange("x265", "crf", c.CRF, 0, 51),
+		inRange("x265", "bframes", c.Bframes, 0, 16),
+	)
+}
+
+// Apply ...
+func (c X265) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	opts.VideoCodec = str("libx265")
+	applyCommon(opts, string(c.Preset), c.Tune, c.Profile, c.CRF, c.Keyint, c.Bframes)
+	c.RateControl.apply(opts)
+	if len(c.Params) > 0 {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-x265-params", params(c.Params))
+	}
+
+	return nil
+}
+
+// applyCommon writes the settings x264 and x265 share
+func applyCommon(opts *ffmpeg.Options, preset, tune, profile string, crf *int, keyint int, bframes *int) {
+	if preset != "" {
+		opts.Preset = str(preset)
+	}
+	if tune != "" {
+		opts.Tune = str(tune)
+	}
+	if profile != "" {
+		opts.VideoProfile = str(profile)
+	}
+	if crf != nil {
+		v := uint32(*crf)
+		opts.Crf = &v
+	}
+	if keyint > 0 {
+		opts.KeyframeInterval = &keyint
+	}
+	if bframes != nil {
+		bf := *bframes
+		opts.Bframe = &bf
+	}
+}
+
+// AV1 configures libsvtav1
+type AV1 struct {
+	// Preset from 0 (slowest) to 13
+	Preset *int
+	// CRF from 0 to 63
+	CRF *int
+	RateControl
+	Keyint int
+	// FilmGrain synthesis strength from 0 to 50
+	FilmGrain *int
+	// Params are passed as -svtav1-params
+	Params map[string]string
+}
+
+// Validate ...
+func (c AV1) Validate() error {
+	return firstError(
+		inRange("svt-av1", "preset", c.Preset, 0, 13),
+		inRange("svt-av1", "crf", c.CRF, 0, 63),
+		inRange("svt-av1", "film grain", c.FilmGrain, 0, 50),
+	)
+}
+
+// Apply ...
+func (c AV1) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	opts.VideoCodec = str("libsvtav1")
+	if c.Preset != nil {
+		opts.Preset = str(strconv.Itoa(*c.Preset))
+	}
+	if c.CRF != nil {
+		v := uint32(*c.CRF)
+		opts.Crf = &v
+	}
+	if c.Keyint > 0 {
+		keyint := c.Keyint
+		opts.KeyframeInterval = &keyint
+	}
+	c.RateControl.apply(opts)
+
+	p := map[string]string{}
+	for k, v := range c.Params {
+		p[k] = v
+	}
+	if c.FilmGrain != nil {
+		p["film-grain"] = strconv.Itoa(*c.FilmGrain)
+	}
+	if len(p) > 0 {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-svtav1-params", params(p))
+	}
+
+	return nil
+}
+
+// VP9 configures libvpx-vp9
+type VP9 struct {
+	// Deadline is good, best or realtime
+	Deadline string
+	// CPUUsed from -8 to 8 trades quality for speed
+	CPUUsed *int
+	// CRF from 0 to 63. Without a bitrate, encodes in constant quality mode
+	CRF *int
+	RateControl
+	Keyint int
+	// RowMT enables row based multithreading
+	RowMT bool
+	// TileColumns, as a log2 from 0 to 6
+	TileColumns *int
+	Lossless    bool
+}
+
+// Validate ...
+func (c VP9) Validate() error {
+	return firstError(
+		oneOf("vp9", "deadline", c.Deadline, "good", "best", "realtime"),
+		inRange("vp9", "cpu-used", c.CPUUsed, -8, 8),
+		inRange("vp9", "crf", c.CRF, 0, 63),
+		inRange("vp9", "tile-columns", c.TileColumns, 0, 6),
+	)
+}
+
+// Apply ...
+func (c VP9) Apply(opts *ffmpeg.Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	opts.VideoCodec = str("libvpx-vp9")
+	if c.Deadline != "" {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-deadline", c.Deadline)
+	}
+	if c.CPUUsed != nil {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-cpu-used", *c.CPUUsed)
+	}
+	if c.CRF != nil {
+		v := uint32(*c.CRF)
+		opts.Crf = &v
+		if c.Bitrate == "" {
+			// Constant quality needs an explicit zero bitrate
+			opts.VideoBitRate = str("0")
+		}
+	}
+	if c.Keyint > 0 {
+		keyint := c.Keyint
+		opts.KeyframeInterval = &keyint
+	}
+	c.RateControl.apply(opts)
+	if c.RowMT {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-row-mt", 1)
+	}
+	if c.TileColumns != nil {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-tile-columns", *c.TileColumns)
+	}
+	if c.Lossless {
+		opts.ExtraArgs = ffmpeg.WithArg(opts.ExtraArgs, "-lossless", 1)
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+// Package concat joins clips into a single file.
+//
+// When every clip has the same codecs and stream parameters they are joined
+// with the concat demuxer, copying the streams. Otherwise the concat filter
+// is used, re-encoding all clips normalized to a common resolution, frame
+// rate and sample rate.
+package concat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Method is the way clips are joined
+type Method string
+
+// Join methods
+const (
+	MethodDemuxer Method = "demuxer"
+	MethodFilter  Method = "filter"
+)
+
+// Clip is one input of a join, optionally trimmed
+type Clip struct {
+	Path string
+	// In and Out are the clip's in and out points in seconds. Zero leaves
+	// that end untrimmed
+	In  float64
+	Out float64
+}
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Options are the output options used when clips must be re-encoded.
+	// Stream copies only use their container options (format, movflags)
+	Options ffmpeg.Options
+	// Width, Height, FrameRate and SampleRate override the normalization
+	// targets of re-encoded joins, which default to the first clip's
+	Width      int
+	Height     int
+	FrameRate  string
+	SampleRate int
+	// ForceFilter re-encodes even when the clips could be stream copied, e.g.
+	// for frame-accurate in and out points
+	ForceFilter bool
+	// WorkDir holds the concat list. Defaults to the system temporary directory
+	WorkDir string
+}
+
+// Result describes a finished join
+type Result struct {
+	Method Method
+	Args   []string
+}
+
+// Join concatenates clips into output, in order
+func Join(ctx context.Context, cfg Config, clips []Clip, output string) (*Result, error) {
+	if len(clips) == 0 {
+		return nil, errors.New("no clips to join")
+	}
+	for i, c := range clips {
+		if c.In < 0 || c.Out < 0 || (c.Out > 0 && c.Out <= c.In) {
+			return nil, fmt.Errorf("%w: clip %d has in point %s and out point %s", ffmpeg.ErrInvalidArgument, i,
+				ffmpeg.FormatSeconds(c.In), ffmpeg.FormatSeconds(c.Out))
+		}
+	}
+
+	metas := make([]transcoder.Metadata, len(clips))
+	for i, c := range clips {
+		m, err := ffmpeg.New(cfg.FFmpeg).Input(c.Path).WithContext(&ctx).GetMetadata()
+		if err != nil {
+			return nil, fmt.Errorf("clip %d: %w", i, err)
+		}
+		metas[i] = m
+	}
+
+	if !cfg.ForceFilter && Compatible(metas) {
+		return demux(ctx, cfg, clips, output)
+	}
+
+	args, err := filterArgs(cfg, clips, metas, output)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	return &Result{Method: MethodFilter, Args: args}, nil
+}
+
+// Compatible reports whether clips with the given metadata can be joined by
+// the concat demuxer without re-encoding: same streams in the same order,
+// with the same codecs and parameters. No clips are trivially compatible
+func Compatible(metas []transcoder.Metadata) bool {
+	if len(metas) == 0 {
+		return true
+	}
+	for _, m := range metas[1:] {
+		first, streams := metas[0].GetStreams(), m.GetStreams()
+		if len(first) != len(streams) {
+			return false
+		}
+		for i := range first {
+			if streamKey(first[i]) != streamKey(streams[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// streamKey lists the parameters that must match for a stream copy join
+func streamKey(s transcoder.Streams) string {
+	switch s.GetCodecType() {
+	case "video":
+		return fmt.Sprintf("video/%s/%s/%dx%d/%s/%s/%s", s.GetCodecName(), s.GetProfile(),
+			s.GetWidth(), s.GetHeight(), s.GetPixFmt(), s.GetRFrameRrate(), s.GetSampleAspectRatio())
+	case "audio":
+		key := "audio/" + s.GetCodecName()
+		if a, ok := s.(transcoder.AudioStreams); ok {
+			key += fmt.Sprintf("/%s/%d/%s", a.GetSampleRate(), a.GetChannels(), a.GetChannelLayout())
+		}
+		return key
+	default:
+		return s.GetCodecType() + "/" + s.GetCodecName()
+	}
+}
+
+// demux joins clips with the concat demuxer
+func demux(ctx context.Context, cfg Config, clips []Clip, output string) (*Result, error) {
+	dir, err := ioutil.TempDir(cfg.WorkDir, "concat")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	list := filepath.Join(dir, "clips.ffconcat")
+	f, err := os.Create(list)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ffmpeg.ConcatEntry, len(clips))
+	for i, c := range clips {
+		// Relative paths would be resolved against the list's directory
+		path := c.Path
+		if !strings.Contains(path, "://") {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+		}
+		entries[i] = ffmpeg.ConcatEntry{Path: path, InPoint: c.In, OutPoint: c.Out}
+	}
+
+	err = ffmpeg.WriteConcatList(f, entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-y", "-f", "concat", "-safe", "0", "-i", list, "-map", "0", "-c", "copy"}
+	args = append(args, ffmpeg.Options{OutputFormat: cfg.Options.OutputFormat, MovFlags: cfg.Options.MovFlags}.GetStrArguments()...)
+	args = append(args, output)
+
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, args...); err != nil {
+		return nil, err
+	}
+
+	return &Result{Method: MethodDemuxer, Args: args}, nil
+}
+
+// filterArgs builds a concat filter join normalizing every clip to the
+// target resolution, frame rate and sample rate
+func filterArgs(cfg Config, clips []Clip, metas []transcoder.Metadata, output string) ([]string, error) {
+	width, height, fps, rate := cfg.Width, cfg.Height, cfg.FrameRate, cfg.SampleRate
+
+	// Every clip feeds the video of the graph, while clips without audio
+	// get silence matching their length
+	hasAudio := false
+	for i, m := range metas {
+		if stream(m, "video") == nil {
+			return nil, fmt.Errorf("clip %d has no video stream", i)
+		}
+		if a := stream(m, "audio"); a != nil {
+			if r, err := strconv.Atoi(sampleRate(a)); err != nil || r <= 0 {
+				return nil, fmt.Errorf("clip %d has an invalid audio sample rate %q", i, sampleRate(a))
+			}
+			hasAudio = true
+		}
+	}
+
+	if v := stream(metas[0], "video"); v != nil {
+		if width == 0 || height == 0 {
+			width, height = v.GetWidth(), v.GetHeight()
+		}
+		if fps == "" {
+			fps = v.GetRFrameRrate()
+		}
+	}
+	if width == 0 || height == 0 {
+		return nil, errors.New("first clip has no video dimensions")
+	}
+	if fps == "" || fps == "0/0" {
+		fps = "25"
+	}
+	if rate == 0 {
+		rate = 48000
+		for _, m := range metas {
+			if a := stream(m, "audio"); a != nil {
+				rate, _ = strconv.Atoi(sampleRate(a))
+				break
+			}
+		}
+	}
+
+	args := []string{"-y"}
+	var graph, pads []string
+
+	for i, c := range clips {
+		if c.In > 0 {
+			args = append(args, "-ss", ffmpeg.FormatSeconds(c.In))
+		}
+		if c.Out > 0 {
+			args = append(args, "-t", ffmpeg.FormatSeconds(c.Out-c.In))
+		}
+		args = append(args, "-i", c.Path)
+
+		graph = append(graph, fmt.Sprintf(
+			"[%d:v:0]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%s,format=yuv420p[v%d]",
+			i, width, height, width, height, fps, i))
+		pads = append(pads, fmt.Sprintf("[v%d]", i))
+
+		if !hasAudio {
+			continue
+		}
+		if stream(metas[i], "audio") != nil {
+			graph = append(graph, fmt.Sprintf(
+				"[%d:a:0]aresample=%d,aformat=sample_fmts=fltp:channel_layouts=stereo[a%d]", i, rate, i))
+		} else {
+			// Clips without audio get silence of their length, keeping
+			// the audio in sync with the video
+			duration := clipDuration(c, metas[i])
+			if duration <= 0 {
+				return nil, fmt.Errorf("clip %d has no audio and an unknown duration", i)
+			}
+			graph = append(graph, fmt.Sprintf(
+				"anullsrc=r=%d:cl=stereo,atrim=duration=%s[a%d]", rate, ffmpeg.FormatSeconds(duration), i))
+		}
+		pads = append(pads, fmt.Sprintf("[a%d]", i))
+	}
+
+	audio, outPads := 0, "[v]"
+	if hasAudio {
+		audio, outPads = 1, "[v][a]"
+	}
+	graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=%d%s", strings.Join(pads, ""), len(clips), audio, outPads))
+
+	args = append(args, "-filter_complex", strings.Join(graph, ";"), "-map", "[v]")
+	if hasAudio {
+		args = append(args, "-map", "[a]")
+	}
+	args = append(args, cfg.Options.GetStrArguments()...)
+	args = append(args, output)
+
+	return args, nil
+}
+
+// stream returns the first stream of the given type
+func stream(m transcoder.Metadata, codecType string) transcoder.Streams {
+	for _, s := range m.GetStreams() {
+		if s.GetCodecType() == codecType {
+			return s
+		}
+	}
+	return nil
+}
+
+// sampleRate returns the sample rate of an audio stream, empty when unknown
+func sampleRate(s transcoder.Streams) string {
+	if a, ok := s.(transcoder.AudioStreams); ok {
+		return a.GetSampleRate()
+	}
+	return ""
+}
+
+// clipDuration returns the trimmed length of a clip in seconds
+func clipDuration(c Clip, m transcoder.Metadata) float64 {
+	end := c.Out
+	if end == 0 {
+		end, _ = strconv.ParseFloat(m.GetFormat().GetDuration(), 64)
+	}
+	return end - c.In
+}
@@ -0,0 +1,124 @@
+package concat_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/concat"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+// probes answers ffprobe with the streams of each clip
+func probes(streams map[string][]ffmpeg.Streams) *ffmpeg.FakeRunner {
+	runner := &ffmpeg.FakeRunner{}
+	runner.Script = func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if strings.HasSuffix(cmd.Path, "ffprobe") {
+			return ffmpeg.FakeResult{Stdout: transcodertest.Probe(30*time.Second, streams[cmd.Args[1]]...)}
+		}
+		return ffmpeg.FakeResult{}
+	}
+	return runner
+}
+
+func TestJoinMatchingClipsWithDemuxer(t *testing.T) {
+	hd := []ffmpeg.Streams{transcodertest.VideoStream("h264", 1920, 1080), transcodertest.AudioStream("aac")}
+	runner := probes(map[string][]ffmpeg.Streams{"a.mp4": hd, "b.mp4": hd})
+
+	result, err := concat.Join(context.Background(), concat.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+	}, []concat.Clip{{Path: "a.mp4"}, {Path: "b.mp4", In: 2, Out: 12}}, "out.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Method != concat.MethodDemuxer {
+		t.Errorf("method = %s, want demuxer", result.Method)
+	}
+	if args := strings.Join(result.Args, " "); !strings.Contains(args, "-f concat -safe 0") || !strings.Contains(args, "-c copy") {
+		t.Errorf("unexpected args %s", args)
+	}
+}
+
+func TestJoinMismatchedClipsWithFilter(t *testing.T) {
+	runner := probes(map[string][]ffmpeg.Streams{
+		"a.mp4": {transcodertest.VideoStream("h264", 1920, 1080), transcodertest.AudioStream("aac")},
+		"b.mov": {transcodertest.VideoStream("prores", 1280, 720)},
+	})
+
+	codec := "libx264"
+	result, err := concat.Join(context.Background(), concat.Config{
+		FFmpeg:  &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Options: ffmpeg.Options{VideoCodec: &codec},
+	}, []concat.Clip{{Path: "a.mp4"}, {Path: "b.mov", In: 5}}, "out.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Method != concat.MethodFilter {
+		t.Errorf("method = %s, want filter", result.Method)
+	}
+
+	args := strings.Join(result.Args, " ")
+	for _, want := range []string{
+		"-ss 5.000000 -i b.mov",
+		"[1:v:0]scale=1920:1080:force_original_aspect_ratio=decrease",
+		"fps=25/1",
+		"anullsrc=r=48000:cl=stereo,atrim=duration=25.000000[a1]",
+		"[v0][a0][v1][a1]concat=n=2:v=1:a=1[v][a]",
+		"-map [v] -map [a] -c:v libx264 out.mp4",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args missing %q:\n%s", want, args)
+		}
+	}
+}
+
+func TestJoinRejectsInvalidPoints(t *testing.T) {
+	for _, clip := range []concat.Clip{{Path: "b.mov", In: 5, Out: 5}, {Path: "b.mov", In: 5, Out: 2}, {Path: "b.mov", In: -1}} {
+		runner := probes(nil)
+		_, err := concat.Join(context.Background(), concat.Config{
+			FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		}, []concat.Clip{{Path: "a.mp4"}, clip}, "out.mp4")
+		if !errors.Is(err, ffmpeg.ErrInvalidArgument) || len(runner.Calls()) != 0 {
+			t.Errorf("%+v: got %v after %d calls", clip, err, len(runner.Calls()))
+		}
+	}
+}
+
+func TestCompatibleWithoutClips(t *testing.T) {
+	if !concat.Compatible(nil) {
+		t.Error("no clips reported incompatible")
+	}
+}
+
+func TestJoinRejectsInvalidStreams(t *testing.T) {
+	badRate := transcodertest.AudioStream("aac")
+	badRate.SampleRate = "N/A"
+
+	cases := map[string][]ffmpeg.Streams{
+		"audio only":  {transcodertest.AudioStream("mp3")},
+		"sample rate": {transcodertest.VideoStream("prores", 1280, 720), badRate},
+	}
+	for name, streams := range cases {
+		runner := probes(map[string][]ffmpeg.Streams{
+			"a.mp4": {transcodertest.VideoStream("h264", 1920, 1080), transcodertest.AudioStream("aac")},
+			"b.mov": streams,
+		})
+
+		_, err := concat.Join(context.Background(), concat.Config{
+			FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		}, []concat.Clip{{Path: "a.mp4"}, {Path: "b.mov"}}, "out.mp4")
+		if err == nil || !strings.Contains(err.Error(), "clip 1") {
+			t.Errorf("%s: got %v, want an error about clip 1", name, err)
+		}
+		for _, call := range runner.Calls() {
+			if strings.HasSuffix(call.Path, "ffmpeg") {
+				t.Errorf("%s: ran %v", name, call.Args)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package event delivers job lifecycle events to sinks.
+//
+// A queue configured with a Publisher reports every job as it is queued,
+// started, makes progress and completes, fails or is cancelled. A
+// Dispatcher fans these events out to sinks, each delivered in order on its
+// own goroutine so a slow webhook never holds up the queue:
+//
+//	d := event.NewDispatcher(
+//		event.Func(func(e event.Event) { log.Println(e.Type, e.Job) }),
+//		event.Filter(&event.Webhook{URL: "https://orchestrator/hooks", Secret: "s3cr3t"}, event.Completed, event.Failed),
+//	)
+//	defer d.Close(context.Background())
+//	q := queue.New(queue.Config{FFmpeg: cfg, Events: d})
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Type ...
+type Type string
+
+// Event types
+const (
+	Queued    Type = "job.queued"
+	Started   Type = "job.started"
+	Progress  Type = "job.progress"
+	Completed Type = "job.completed"
+	Failed    Type = "job.failed"
+	Cancelled Type = "job.cancelled"
+)
+
+// Event describes a change in the lifecycle of a job
+type Event struct {
+	// ID is unique to the event, so receivers can discard redeliveries
+	ID   string    `json:"id"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Job  string    `json:"job"`
+	// Attempt counts the runs of the job, starting at 1 once it started
+	Attempt int `json:"attempt"`
+	// Command is the ffmpeg command line, once the job started
+	Command  []string       `json:"command,omitempty"`
+	Timing   Timing         `json:"timing"`
+	Progress *ProgressState `json:"progress,omitempty"`
+	// Error is the failure of failed jobs, or of the previous attempt of
+	// jobs queued again for a retry
+	Error string `json:"error,omitempty"`
+	// Outputs of completed jobs, with their probed metadata
+	Outputs []Output `json:"outputs,omitempty"`
+}
+
+// Timing ...
+type Timing struct {
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Duration is the running time of finished jobs, in seconds
+	Duration float64 `json:"duration_seconds,omitempty"`
+}
+
+// ProgressState is the progress of a running job
+type ProgressState struct {
+	Percent float64 `json:"percent"`
+	Frames  string  `json:"frames,omitempty"`
+	Time    string  `json:"time,omitempty"`
+	Bitrate string  `json:"bitrate,omitempty"`
+	Speed   string  `json:"speed,omitempty"`
+	Elapsed float64 `json:"elapsed_seconds"`
+	ETA     float64 `json:"eta_seconds"`
+}
+
+// NewProgress converts ffmpeg progress
+func NewProgress(p ffmpeg.Progress) *ProgressState {
+	return &ProgressState{
+		Percent: p.Progress,
+		Frames:  p.FramesProcessed,
+		Time:    p.CurrentTime,
+		Bitrate: p.CurrentBitrate,
+		Speed:   p.Speed,
+		Elapsed: p.Elapsed.Seconds(),
+		ETA:     p.ETA.Seconds(),
+	}
+}
+
+// Output is an output file of a completed job
+type Output struct {
+	Path string `json:"path"`
+	// Metadata is nil when the output could not be probed, as with pipes
+	Metadata *ffmpeg.Metadata `json:"metadata,omitempty"`
+}
+
+// Publisher receives events. Publish must not block
+type Publisher interface {
+	Publish(e Event)
+}
+
+// Sink delivers events somewhere
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Func is a Sink calling an in-process handler
+type Func func(e Event)
+
+// Send ...
+func (f Func) Send(ctx context.Context, e Event) error {
+	f(e)
+	return nil
+}
+
+// filtered ...
+type filtered struct {
+	sink  Sink
+	types map[Type]bool
+}
+
+// Filter returns a sink passing only events of the given types to sink
+func Filter(sink Sink, types ...Type) Sink {
+	f := filtered{sink: sink, types: map[Type]bool{}}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+// Send ...
+func (f filtered) Send(ctx context.Context, e Event) error {
+	if !f.types[e.Type] {
+		return nil
+	}
+	return f.sink.Send(ctx, e)
+}
+
+// Dispatcher is a Publisher delivering events to sinks. Publishing never
+// blocks: events are buffered, and a progress event waiting for a slow sink
+// is replaced by the next progress of its job, so a stalled sink holds at
+// most one progress event per job
+type Dispatcher struct {
+	// OnError is called with the events a sink failed to deliver. Optional
+	OnError func(sink Sink, e Event, err error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	subs   []*subscription
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// subscription is the delivery queue of one sink
+type subscription struct {
+	sink    Sink
+	mu      sync.Mutex
+	pending []Event
+	closed  bool
+	wake    chan struct{}
+}
+
+// NewDispatcher returns a dispatcher delivering to sinks
+func NewDispatcher(sinks ...Sink) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{ctx: ctx, cancel: cancel}
+	for _, s := range sinks {
+		d.Subscribe(s)
+	}
+	return d
+}
+
+// Subscribe adds a sink. It receives the events published from then on
+func (d *Dispatcher) Subscribe(sink Sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	s := &subscription{sink: sink, wake: make(chan struct{}, 1)}
+	d.subs = append(d.subs, s)
+	d.wg.Add(1)
+	go d.deliver(s)
+}
+
+// Publish queues e for every sink, filling in its ID and time when unset
+func (d *Dispatcher) Publish(e Event) {
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+	for _, s := range d.subs {
+		s.mu.Lock()
+		s.queue(e)
+		s.mu.Unlock()
+
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queue adds e to the pending events, replacing the job's pending progress
+// when that is its latest event. Callers hold s.mu
+func (s *subscription) queue(e Event) {
+	if e.Type == Progress {
+		for i := len(s.pending) - 1; i >= 0; i-- {
+			if s.pending[i].Job != e.Job {
+				continue
+			}
+			if s.pending[i].Type == Progress {
+				s.pending[i] = e
+				return
+			}
+			break
+		}
+	}
+	s.pending = append(s.pending, e)
+}
+
+// Close stops accepting events and waits until the pending ones are
+// delivered, or ctx is done. Deliveries still running then are cancelled
+// and abandoned: Close returns without waiting for sinks ignoring ctx
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, s := range d.subs {
+			s.mu.Lock()
+			s.closed = true
+			s.mu.Unlock()
+			close(s.wake)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		return ctx.Err()
+	}
+}
+
+// deliver sends the events of a subscription in order until it is closed
+// and drained
+func (d *Dispatcher) deliver(s *subscription) {
+	defer d.wg.Done()
+
+	for {
+		s.mu.Lock()
+		events := s.pending
+		s.pending = nil
+		closed := s.closed
+		s.mu.Unlock()
+
+		for _, e := range events {
+			if d.ctx.Err() != nil {
+				return
+			}
+			if err := s.sink.Send(d.ctx, e); err != nil && d.OnError != nil {
+				d.OnError(s.sink, e, err)
+			}
+		}
+
+		if closed && len(events) == 0 {
+			return
+		}
+		if !closed {
+			// Wakes up for new events, or once closed to drain the rest
+			<-s.wake
+		}
+	}
+}
+
+// newID returns a random event ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,267 @@
+package event_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/event"
+)
+
+// recorder is a sink keeping what it receives
+type recorder struct {
+	mu     sync.Mutex
+	events []event.Event
+}
+
+func (r *recorder) Send(ctx context.Context, e event.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) types() []event.Type {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var types []event.Type
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// tempDir returns a directory removed when t finishes
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestDispatcher(t *testing.T) {
+	all, completions := &recorder{}, &recorder{}
+	var handled int32
+	d := event.NewDispatcher(all, event.Filter(completions, event.Completed, event.Failed))
+	d.Subscribe(event.Func(func(e event.Event) { atomic.AddInt32(&handled, 1) }))
+
+	for _, typ := range []event.Type{event.Queued, event.Started, event.Progress, event.Completed} {
+		d.Publish(event.Event{Type: typ, Job: "a"})
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(event.Event{Type: event.Failed, Job: "b"})
+
+	want := []event.Type{event.Queued, event.Started, event.Progress, event.Completed}
+	if got := all.types(); len(got) != len(want) || got[0] != want[0] || got[3] != want[3] {
+		t.Errorf("all = %v, want %v", got, want)
+	}
+	if got := completions.types(); len(got) != 1 || got[0] != event.Completed {
+		t.Errorf("completions = %v", got)
+	}
+	if handled != 4 {
+		t.Errorf("handler called %d times", handled)
+	}
+	if e := all.events[0]; e.ID == "" || e.Time.IsZero() || e.ID == all.events[1].ID {
+		t.Errorf("event ids %q %q, time %v", e.ID, all.events[1].ID, e.Time)
+	}
+}
+
+func TestDispatcherCloseTimeout(t *testing.T) {
+	// The sink ignores ctx and blocks until the test ends
+	release := make(chan struct{})
+	defer close(release)
+	block := event.Func(func(event.Event) { <-release })
+	d := event.NewDispatcher(block)
+	for i := 0; i < 10; i++ {
+		d.Publish(event.Event{Type: event.Progress})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDispatcherCoalescesProgress(t *testing.T) {
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var received []event.Event
+	d := event.NewDispatcher(event.Func(func(e event.Event) {
+		if e.Type == event.Started {
+			close(blocked)
+			<-release
+		}
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	}))
+
+	d.Publish(event.Event{Type: event.Started, Job: "a"})
+	<-blocked
+	for i := 1; i <= 100; i++ {
+		d.Publish(event.Event{Type: event.Progress, Job: "a", Progress: &event.ProgressState{Percent: float64(i)}})
+		d.Publish(event.Event{Type: event.Progress, Job: "b", Progress: &event.ProgressState{Percent: float64(i)}})
+	}
+	d.Publish(event.Event{Type: event.Completed, Job: "a"})
+	close(release)
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the latest progress of each job was waiting
+	if len(received) != 4 || received[1].Progress.Percent != 100 || received[2].Job != "b" || received[3].Type != event.Completed {
+		t.Errorf("received %+v", received)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var attempts int32
+	received := make(chan event.Event, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if !event.Verify("secret", r.Header, body) {
+			t.Errorf("bad signature %s", r.Header.Get(event.SignatureHeader))
+		}
+		if r.Header.Get(event.EventHeader) != string(event.Completed) || r.Header.Get(event.DeliveryHeader) != "e1" {
+			t.Errorf("headers %v", r.Header)
+		}
+
+		var e event.Event
+		json.Unmarshal(body, &e)
+		received <- e
+	}))
+	defer ts.Close()
+
+	hook := &event.Webhook{URL: ts.URL, Secret: "secret", Backoff: time.Millisecond}
+	e := event.Event{ID: "e1", Type: event.Completed, Job: "a", Command: []string{"ffmpeg", "-i", "in.mov", "out.mp4"}}
+	if err := hook.Send(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+	if got.Job != "a" || len(got.Command) != 4 || attempts != 3 {
+		t.Errorf("received %+v after %d attempts", got, attempts)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var attempts int32
+	status := http.StatusInternalServerError
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	hook := &event.Webhook{URL: ts.URL, Attempts: 3, Backoff: time.Millisecond}
+	if err := hook.Send(context.Background(), event.Event{Type: event.Failed}); err == nil || attempts != 3 {
+		t.Errorf("err = %v after %d attempts", err, attempts)
+	}
+
+	// Client errors are not retried
+	attempts, status = 0, http.StatusBadRequest
+	if err := hook.Send(context.Background(), event.Event{Type: event.Failed}); err == nil || attempts != 1 {
+		t.Errorf("err = %v after %d attempts", err, attempts)
+	}
+}
+
+func TestSign(t *testing.T) {
+	header := http.Header{}
+	header.Set(event.TimestampHeader, "1700000000")
+	header.Set(event.SignatureHeader, event.Sign("secret", "1700000000", []byte(`{}`)))
+
+	if !event.Verify("secret", header, []byte(`{}`)) {
+		t.Error("valid signature rejected")
+	}
+	if event.Verify("other", header, []byte(`{}`)) || event.Verify("secret", header, []byte(`{"a":1}`)) {
+		t.Error("invalid signature accepted")
+	}
+}
+
+func TestOpenLog(t *testing.T) {
+	path := filepath.Join(tempDir(t), "events.log")
+
+	for i := 0; i < 2; i++ {
+		l, err := event.OpenLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Send(context.Background(), event.Event{Type: event.Queued, Job: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		var e event.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Job != "a" {
+			t.Errorf("line %q: %v", scanner.Text(), err)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("%d lines, want 2 appended", lines)
+	}
+}
+
+func TestDialLog(t *testing.T) {
+	path := filepath.Join(tempDir(t), "events.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	l, err := event.DialLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Send(context.Background(), event.Event{Type: event.Started, Job: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var e event.Event
+	if err := json.Unmarshal([]byte(<-received), &e); err != nil || e.Type != event.Started {
+		t.Errorf("received %+v: %v", e, err)
+	}
+}
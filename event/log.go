@@ -0,0 +1,62 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// Log is a Sink writing events as JSON lines, to a file, a Unix socket or
+// any writer
+type Log struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLog returns a log writing to w
+func NewLog(w io.Writer) *Log {
+	return &Log{w: w}
+}
+
+// OpenLog appends events to the file at path, creating it if needed
+func OpenLog(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewLog(f), nil
+}
+
+// DialLog streams events to the Unix socket at path
+func DialLog(path string) (*Log, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLog(conn), nil
+}
+
+// Send ...
+func (l *Log) Send(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the writer of the log when it is an io.Closer
+func (l *Log) Close() error {
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook headers
+const (
+	SignatureHeader = "X-Transcoder-Signature"
+	TimestampHeader = "X-Transcoder-Timestamp"
+	EventHeader     = "X-Transcoder-Event"
+	DeliveryHeader  = "X-Transcoder-Delivery"
+)
+
+// Webhook is a Sink POSTing events as JSON. With a secret, requests carry
+// an HMAC-SHA256 signature of their timestamp and body, see Sign.
+// Network errors, 429 and 5xx responses are retried with exponential
+// backoff; other responses are final
+type Webhook struct {
+	URL    string
+	Secret string
+	// Client defaults to a client with a 30 second timeout
+	Client *http.Client
+	// Attempts is the number of deliveries tried. Defaults to 5
+	Attempts int
+	// Backoff is the delay before the first retry, doubled after each one
+	// up to MaxBackoff. Defaults to 1 second and 1 minute
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// defaultClient keeps a hanging endpoint from stalling deliveries
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// Send ...
+func (w *Webhook) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	attempts := w.Attempts
+	if attempts <= 0 {
+		attempts = 5
+	}
+	delay := w.Backoff
+	if delay <= 0 {
+		delay = time.Second
+	}
+	maxDelay := w.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, e, body)
+		if err == nil || !retry || attempt >= attempts {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// post delivers body once and reports whether a failure is worth retrying
+func (w *Webhook) post(ctx context.Context, e Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(e.Type))
+	req.Header.Set(DeliveryHeader, e.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, timestamp, body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	}
+	return false, fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+}
+
+// Sign returns the signature header of a webhook request: "sha256=" and
+// the hex HMAC-SHA256 of the timestamp header, a dot and the body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received webhook request, whose body
+// has been read. Receivers should also reject stale timestamps
+func Verify(secret string, header http.Header, body []byte) bool {
+	expected := Sign(secret, header.Get(TimestampHeader), body)
+	return hmac.Equal([]byte(expected), []byte(header.Get(SignatureHeader)))
+}
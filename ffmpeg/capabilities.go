@@ -0,0 +1,118 @@
+package ffmpeg
+
+import (
+	"context"
+	"strings"
+)
+
+// Capabilities lists what an ffmpeg build supports
+type Capabilities struct {
+	Version  string
+	Filters  map[string]bool
+	Encoders map[string]bool
+	Decoders map[string]bool
+	Hwaccels map[string]bool
+}
+
+// DetectCapabilities queries the configured ffmpeg binary for its version,
+// filters, encoders, decoders and hardware acceleration methods. Results are
+// not cached; callers running many jobs should keep them
+func DetectCapabilities(ctx context.Context, cfg *Config) (*Capabilities, error) {
+	c := &Capabilities{}
+
+	out, _, err := Exec(ctx, cfg, "-hide_banner", "-version")
+	if err != nil {
+		return nil, err
+	}
+	if fields := strings.Fields(out); len(fields) >= 3 && fields[1] == "version" {
+		c.Version = fields[2]
+	}
+
+	if out, _, err = Exec(ctx, cfg, "-hide_banner", "-filters"); err != nil {
+		return nil, err
+	}
+	c.Filters = parseFilters(out)
+
+	if out, _, err = Exec(ctx, cfg, "-hide_banner", "-encoders"); err != nil {
+		return nil, err
+	}
+	c.Encoders = parseCodecs(out)
+
+	if out, _, err = Exec(ctx, cfg, "-hide_banner", "-decoders"); err != nil {
+		return nil, err
+	}
+	c.Decoders = parseCodecs(out)
+
+	if out, _, err = Exec(ctx, cfg, "-hide_banner", "-hwaccels"); err != nil {
+		return nil, err
+	}
+	c.Hwaccels = parseHwaccels(out)
+
+	return c, nil
+}
+
+// HasFilter ...
+func (c *Capabilities) HasFilter(name string) bool {
+	return c.Filters[name]
+}
+
+// HasEncoder ...
+func (c *Capabilities) HasEncoder(name string) bool {
+	return c.Encoders[name]
+}
+
+// HasDecoder ...
+func (c *Capabilities) HasDecoder(name string) bool {
+	return c.Decoders[name]
+}
+
+// HasHwaccel ...
+func (c *Capabilities) HasHwaccel(name string) bool {
+	return c.Hwaccels[name]
+}
+
+// parseFilters reads "ffmpeg -filters" lines such as
+// " TSC blackdetect        V->V       Detect video intervals..."
+func parseFilters(out string) map[string]bool {
+	filters := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			filters[fields[1]] = true
+		}
+	}
+	return filters
+}
+
+// parseCodecs reads the entries following the "------" separator of
+// "ffmpeg -encoders" and "ffmpeg -decoders"
+func parseCodecs(out string) map[string]bool {
+	codecs := map[string]bool{}
+	listed := false
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "---") {
+			listed = true
+			continue
+		}
+		if listed && len(fields) >= 2 {
+			codecs[fields[1]] = true
+		}
+	}
+	return codecs
+}
+
+// parseHwaccels reads the methods listed by "ffmpeg -hwaccels"
+func parseHwaccels(out string) map[string]bool {
+	hwaccels := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasSuffix(line, ":") {
+			hwaccels[line] = true
+		}
+	}
+	return hwaccels
+}
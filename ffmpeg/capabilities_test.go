@@ -0,0 +1,76 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+var capabilityOutputs = map[string]string{
+	"-version": "ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc 13\n",
+	"-filters": `Filters:
+  T.. = Timeline support
+  | = Source or sink filter
+ TSC blackdetect        V->V       Detect video intervals that are (almost) black.
+ ... libvmaf            VV->V      Calculate the VMAF between two video streams.
+ ... anullsrc           |->A       Null audio source, return empty audio frames.
+`,
+	"-encoders": `Encoders:
+ V..... = Video
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+`,
+	"-decoders": `Decoders:
+ V..... = Video
+ ------
+ VFS..D h264                 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10
+`,
+	"-hwaccels": "Hardware acceleration methods:\nvaapi\ncuda\n\n",
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		return ffmpeg.FakeResult{Stdout: capabilityOutputs[cmd.Args[len(cmd.Args)-1]]}
+	}}
+
+	caps, err := ffmpeg.DetectCapabilities(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if caps.Version != "6.1.1" {
+		t.Errorf("version = %q", caps.Version)
+	}
+	for _, f := range []string{"blackdetect", "libvmaf", "anullsrc"} {
+		if !caps.HasFilter(f) {
+			t.Errorf("missing filter %s", f)
+		}
+	}
+	if caps.HasFilter("T..") || caps.HasFilter("=") {
+		t.Errorf("legend parsed as filter: %v", caps.Filters)
+	}
+	if !caps.HasEncoder("libx264") || !caps.HasEncoder("aac") || caps.HasEncoder("V.....") {
+		t.Errorf("encoders = %v", caps.Encoders)
+	}
+	if !caps.HasDecoder("h264") || caps.HasDecoder("libx264") {
+		t.Errorf("decoders = %v", caps.Decoders)
+	}
+	if !caps.HasHwaccel("vaapi") || !caps.HasHwaccel("cuda") || len(caps.Hwaccels) != 2 {
+		t.Errorf("hwaccels = %v", caps.Hwaccels)
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := map[string]string{
+		"/tmp/stats.log":        "/tmp/stats.log",
+		`C:\logs\psnr.log`:      `C\\:\\\\logs\\\\psnr.log`,
+		"/tmp/it's [a],b;c.log": `/tmp/it\\\'s \[a\]\,b\;c.log`,
+	}
+	for in, want := range tests {
+		if got := ffmpeg.EscapeFilterValue(in); got != want {
+			t.Errorf("EscapeFilterValue(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
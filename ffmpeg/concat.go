@@ -0,0 +1,43 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ConcatEntry is one file of a concat demuxer list
+type ConcatEntry struct {
+	Path string
+	// InPoint and OutPoint trim the file, in seconds. Zero leaves that end
+	// untrimmed
+	InPoint  float64
+	OutPoint float64
+}
+
+// WriteConcatList writes entries as an ffconcat list for the concat
+// demuxer. Paths are quoted and escaped so any file name is safe; lists
+// with absolute paths or unusual characters must be read with "-safe 0"
+func WriteConcatList(w io.Writer, entries []ConcatEntry) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "ffconcat version 1.0")
+	for _, e := range entries {
+		fmt.Fprintf(bw, "file %s\n", concatQuote(e.Path))
+		if e.InPoint > 0 {
+			fmt.Fprintf(bw, "inpoint %s\n", FormatSeconds(e.InPoint))
+		}
+		if e.OutPoint > 0 {
+			fmt.Fprintf(bw, "outpoint %s\n", FormatSeconds(e.OutPoint))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// concatQuote quotes a path for a concat list: single quotes, with embedded
+// single quotes escaped as '\''
+func concatQuote(path string) string {
+	return "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+}
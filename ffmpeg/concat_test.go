@@ -0,0 +1,30 @@
+package ffmpeg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+func TestWriteConcatList(t *testing.T) {
+	var b strings.Builder
+
+	err := ffmpeg.WriteConcatList(&b, []ffmpeg.ConcatEntry{
+		{Path: "/media/intro.mp4"},
+		{Path: "/media/it's a clip.mp4", InPoint: 1.5, OutPoint: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `ffconcat version 1.0
+file '/media/intro.mp4'
+file '/media/it'\''s a clip.mp4'
+inpoint 1.500000
+outpoint 10.000000
+`
+	if b.String() != want {
+		t.Errorf("list =\n%s\nwant\n%s", b.String(), want)
+	}
+}
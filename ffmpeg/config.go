@@ -1,9 +1,35 @@
 package ffmpeg
 
+import "time"
+
 // Config ...
 type Config struct {
 	FfmpegBinPath   string
 	FfprobeBinPath  string
 	ProgressEnabled bool
 	Verbose         bool
+	// DryRun makes Start assemble the command without probing or executing
+	// anything. Use Command or CommandString to inspect the result
+	DryRun bool
+	// Runner starts the ffmpeg and ffprobe processes. Defaults to ExecRunner
+	Runner Runner
+	// GracePeriod is how long Stop waits for ffmpeg to finalize its outputs
+	// before killing it. Defaults to 10 seconds
+	GracePeriod time.Duration
+}
+
+// gracePeriod ...
+func (c *Config) gracePeriod() time.Duration {
+	if c.GracePeriod <= 0 {
+		return 10 * time.Second
+	}
+	return c.GracePeriod
+}
+
+// runner returns the configured Runner or the local exec runner
+func (c *Config) runner() Runner {
+	if c.Runner == nil {
+		return ExecRunner{}
+	}
+	return c.Runner
 }
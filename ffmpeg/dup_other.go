@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package ffmpeg
+
+import (
+	"errors"
+	"os"
+)
+
+// dupFile ...
+func dupFile(f *os.File) (*os.File, error) {
+	return nil, errors.New("duplicating files is not supported on this platform")
+}
@@ -0,0 +1,37 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package ffmpeg
+
+import (
+	"os"
+	"syscall"
+)
+
+// dupFile returns a duplicate of f, open until closed itself
+func dupFile(f *os.File) (*os.File, error) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	var dupErr error
+	syscall.ForkLock.RLock()
+	err = raw.Control(func(s uintptr) {
+		if fd, dupErr = syscall.Dup(int(s)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+
+	// Keep the duplicate pollable so closing it interrupts reads
+	syscall.SetNonblock(fd, true)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
@@ -0,0 +1,221 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Classes of ffmpeg and ffprobe failures. Errors returned by Start, Wait,
+// Stop and GetMetadata match one of them with errors.Is when the cause
+// could be identified from the exit status and stderr output
+var (
+	ErrInvalidInput     = errors.New("invalid input")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrNetwork          = errors.New("network error")
+	ErrDiskFull         = errors.New("disk full")
+	ErrKilled           = errors.New("process killed")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrHardware         = errors.New("hardware acceleration unavailable")
+)
+
+// Error describes a failed ffmpeg or ffprobe process
+type Error struct {
+	// Class is one of the Err* classes, or nil when the failure could not
+	// be classified
+	Class    error
+	Path     string
+	Args     []string
+	ExitCode int
+	// Message holds the last lines ffmpeg wrote to stderr
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s with args (%s) failed: %v", e.Path, e.Args, e.Err)
+	if e.Class != nil {
+		msg += " (" + e.Class.Error() + ")"
+	}
+	if e.Message != "" {
+		msg += " | message: " + e.Message
+	}
+	return msg
+}
+
+// Unwrap ...
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the error class
+func (e *Error) Is(target error) bool {
+	return e.Class != nil && e.Class == target
+}
+
+// IsTransient reports whether err is a failure worth retrying: network
+// errors, a full disk or a killed process (e.g. by the OOM killer)
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrNetwork) || errors.Is(err, ErrDiskFull) || errors.Is(err, ErrKilled)
+}
+
+// errorLines is how many of the last stderr lines are searched for the
+// cause of a failure. ffmpeg reports it at the end of its output, while
+// earlier lines may hold warnings about errors it recovered from
+const errorLines = 3
+
+// classes maps stderr fragments to error classes. Earlier entries win
+var classes = []struct {
+	class    error
+	patterns []string
+}{
+	{ErrDiskFull, []string{
+		"No space left on device",
+		"Disk quota exceeded",
+	}},
+	{ErrHardware, []string{
+		"Device creation failed",
+		"Failed to initialise VAAPI connection",
+		"No VA display found",
+		"Cannot load libcuda",
+		"Cannot load libnvidia-encode",
+		"No NVENC capable devices found",
+		"OpenEncodeSessionEx failed",
+		"Error creating a MFX session",
+		"Error initializing an internal MFX session",
+		"hwaccel initialisation returned error",
+		"Failed setup for format",
+		"cannot create compression session",
+	}},
+	{ErrNetwork, []string{
+		"Connection refused",
+		"Connection timed out",
+		"Connection reset by peer",
+		"Network is unreachable",
+		"Operation timed out",
+		"Failed to resolve hostname",
+		"Name or service not known",
+		"Temporary failure in name resolution",
+		"Server returned 5",
+	}},
+	{ErrUnsupportedCodec, []string{
+		"Unknown encoder",
+		"Unknown decoder",
+		"Encoder not found",
+		"Decoder not found",
+		"not currently supported in container",
+		"Could not find tag for codec",
+		"Unsupported codec",
+		"is not supported by the bitstream filter",
+	}},
+	{ErrInvalidArgument, []string{
+		"Unrecognized option",
+		"Option not found",
+		"Error splitting the argument list",
+		"Invalid value",
+		"No such filter",
+		"Error initializing filter",
+		"Error parsing",
+		"Unable to find a suitable output format",
+		"At least one output file must be specified",
+		"Invalid argument",
+	}},
+	{ErrInvalidInput, []string{
+		"Invalid data found when processing input",
+		"No such file or directory",
+		"moov atom not found",
+		"Server returned 4",
+		"does not contain any stream",
+		"Invalid NAL unit size",
+		"Error while decoding stream",
+	}},
+}
+
+// newError classifies a failed process from its exit status and stderr
+func newError(path string, args []string, err error, stderr string) *Error {
+	e := &Error{Path: path, Args: args, Err: err, Message: strings.TrimSpace(stderr)}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.Code
+	}
+
+	final := lastLines(stderr, errorLines)
+	for _, c := range classes {
+		for _, pattern := range c.patterns {
+			if strings.Contains(final, pattern) {
+				e.Class = c.class
+				return e
+			}
+		}
+	}
+
+	// Killed by a signal (-1), or by SIGKILL through a shell (137)
+	if e.ExitCode == -1 || e.ExitCode == 137 {
+		e.Class = ErrKilled
+	}
+
+	return e
+}
+
+// lastLines returns the last n lines of s holding any text, skipping
+// blank lines and lone JSON braces
+func lastLines(s string, n int) string {
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == '\r' })
+
+	var last []string
+	for i := len(lines) - 1; i >= 0 && len(last) < n; i-- {
+		if strings.IndexFunc(lines[i], unicode.IsLetter) >= 0 {
+			last = append([]string{lines[i]}, last...)
+		}
+	}
+	return strings.Join(last, "\n")
+}
+
+// tailWriter keeps the last lines written to it
+type tailWriter struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+	part  string
+}
+
+// newTailWriter ...
+func newTailWriter(max int) *tailWriter {
+	return &tailWriter{max: max}
+}
+
+// Write ...
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := w.part + string(p)
+	for {
+		i := strings.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		// Progress lines are '\r' terminated and not worth keeping
+		if line := data[:i]; data[i] == '\n' && strings.TrimSpace(line) != "" {
+			w.lines = append(w.lines, line)
+			if len(w.lines) > w.max {
+				w.lines = w.lines[1:]
+			}
+		}
+		data = data[i+1:]
+	}
+	w.part = data
+
+	return len(p), nil
+}
+
+// String ...
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return strings.Join(append(w.lines, w.part), "\n")
+}
@@ -0,0 +1,87 @@
+package ffmpeg_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func TestErrorClassification(t *testing.T) {
+	cases := []struct {
+		stderr   string
+		exitCode int
+		class    error
+		retry    bool
+	}{
+		{"in.mov: Invalid data found when processing input\n", 1, ffmpeg.ErrInvalidInput, false},
+		{"Unknown encoder 'libfoo'\n", 1, ffmpeg.ErrUnsupportedCodec, false},
+		{"[tcp @ 0x1] Connection to tcp://cdn:80 failed: Connection timed out\n", 1, ffmpeg.ErrNetwork, true},
+		{"[https @ 0x1] HTTP error 503 Server returned 5XX Server Error reply\n", 1, ffmpeg.ErrNetwork, true},
+		{"av_interleaved_write_frame(): No space left on device\n", 1, ffmpeg.ErrDiskFull, true},
+		{"Unrecognized option 'crf2'.\nError splitting the argument list: Option not found\n", 1, ffmpeg.ErrInvalidArgument, false},
+		{"[AVHWDeviceContext @ 0x1] Failed to initialise VAAPI connection: -1 (unknown libva error).\nDevice creation failed: -5.\nInvalid argument\n", 1, ffmpeg.ErrHardware, false},
+		{"frame=  10 fps=0.0 q=0.0 size=0kB time=00:00:05.00 bitrate=N/A speed=1x\r", -1, ffmpeg.ErrKilled, true},
+	}
+
+	for _, c := range cases {
+		runner := transcodertest.Runner(
+			transcodertest.Script{Stderr: c.stderr, ExitCode: c.exitCode},
+			transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+		)
+
+		_, err := ffmpeg.New(&ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}).
+			Input("in.mov").
+			Output("out.mp4").
+			Start()
+
+		if !errors.Is(err, c.class) {
+			t.Errorf("%q: got %v, want %v", c.stderr, err, c.class)
+		}
+		if ffmpeg.IsTransient(err) != c.retry {
+			t.Errorf("%q: IsTransient = %v", c.stderr, !c.retry)
+		}
+
+		var ffErr *ffmpeg.Error
+		if !errors.As(err, &ffErr) || ffErr.ExitCode != c.exitCode {
+			t.Errorf("%q: got %#v, want exit code %d", c.stderr, err, c.exitCode)
+		}
+	}
+}
+
+func TestErrorClassificationIgnoresWarnings(t *testing.T) {
+	// Errors ffmpeg recovered from are followed by the actual failure
+	stderr := "[h264 @ 0x1] Invalid NAL unit size (1 > 0).\n" +
+		"Error while decoding stream #0:0: Invalid data found when processing input\n" +
+		"[mp4 @ 0x2] Starting second pass: moving the moov atom to the beginning of the file\n" +
+		"[aac @ 0x3] Qavg: 512.000\n" +
+		"Conversion failed!\n"
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: stderr, ExitCode: 1},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+
+	_, err := ffmpeg.New(&ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}).
+		Input("in.mov").
+		Output("out.mp4").
+		Start()
+
+	var ffErr *ffmpeg.Error
+	if !errors.As(err, &ffErr) || ffErr.Class != nil {
+		t.Errorf("got %v, want an unclassified failure", err)
+	}
+}
+
+func TestGetMetadataErrorClass(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{
+		Stdout:   `{"error":{"code":-2,"string":"No such file or directory"}}`,
+		ExitCode: 1,
+	})
+
+	_, err := ffmpeg.New(&ffmpeg.Config{FfprobeBinPath: "ffprobe", Runner: runner}).Input("missing.mov").GetMetadata()
+	if !errors.Is(err, ffmpeg.ErrInvalidInput) {
+		t.Errorf("got %v, want ErrInvalidInput", err)
+	}
+}
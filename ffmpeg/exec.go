@@ -0,0 +1,43 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// Exec runs ffmpeg with args through the configured Runner and returns what
+// it wrote to stdout and stderr. Unlike Start it neither probes the input
+// nor reports progress, which suits analysis passes that print their results
+// to the log. The process is killed when ctx is done. Failures are *Error
+func Exec(ctx context.Context, cfg *Config, args ...string) (stdout, stderr string, err error) {
+	if cfg.FfmpegBinPath == "" {
+		return "", "", errors.New("ffmpeg binary path not found")
+	}
+	return run(ctx, cfg, cfg.FfmpegBinPath, args)
+}
+
+// ExecProbe runs ffprobe with args like Exec
+func ExecProbe(ctx context.Context, cfg *Config, args ...string) (stdout, stderr string, err error) {
+	if cfg.FfprobeBinPath == "" {
+		return "", "", errors.New("ffprobe binary not found")
+	}
+	return run(ctx, cfg, cfg.FfprobeBinPath, args)
+}
+
+// run ...
+func run(ctx context.Context, cfg *Config, path string, args []string) (string, string, error) {
+	var outb, errb bytes.Buffer
+
+	cmd := Cmd{Path: path, Args: args, Stdout: &outb, Stderr: &errb}
+
+	proc, err := cfg.runner().Start(ctx, cmd)
+	if err == nil {
+		err = proc.Wait()
+	}
+	if err != nil {
+		return outb.String(), errb.String(), newError(path, args, err, outb.String()+errb.String())
+	}
+
+	return outb.String(), errb.String(), nil
+}
@@ -9,10 +9,11 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/floostack/transcoder"
 	"github.com/floostack/transcoder/utils"
@@ -31,14 +32,30 @@ type Transcoder struct {
 	inputPipeWriter  *io.WriteCloser
 	outputPipeWriter *io.WriteCloser
 	commandContext   *context.Context
+	// err is set before done is closed
+	err error
+
+	mu        sync.Mutex
+	process   Process
+	stdin     io.WriteCloser
+	done      chan struct{}
+	state     State
+	startedAt time.Time
+	pausedAt  time.Time
+	paused    time.Duration
 }
 
+var errNotStarted = errors.New("transcoder has not been started")
+
 // New ...
 func New(cfg *Config) transcoder.Transcoder {
 	return &Transcoder{config: cfg}
 }
 
-// Start ...
+// Start runs ffmpeg. With progress enabled it returns as soon as the process
+// is started and the outcome is available from Wait; otherwise it blocks
+// until ffmpeg exits and returns its error. Process failures are *Error
+// values matching one of the Err* classes when the cause is known
 func (t *Transcoder) Start() (<-chan transcoder.Progress, error) {
 
 	var stderrIn io.ReadCloser
@@ -52,12 +69,229 @@ func (t *Transcoder) Start() (<-chan transcoder.Progress, error) {
 		return nil, err
 	}
 
+	// Dry runs stop once the command is assembled
+	if t.config.DryRun {
+		if t.config.Verbose {
+			fmt.Fprintln(os.Stdout, utils.ShellQuote(t.command()))
+		}
+		close(out)
+		return out, nil
+	}
+
 	// Get file metadata
 	_, err := t.GetMetadata()
 	if err != nil {
 		return nil, err
 	}
 
+	args := t.args()
+
+	// Initialize command
+	// ffmpeg reads "q" on stdin as a request to finish, so keep a pipe open
+	// to it for graceful stops
+	stdinIn, stdinOut, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := Cmd{Path: t.config.FfmpegBinPath, Args: args, Stdin: stdinIn}
+
+	// Keep the end of stderr to classify failures
+	tail := newTailWriter(20)
+	cmd.Stderr = tail
+
+	// If progresss enabled, pipe stderr into the progress process
+	var stderrOut *io.PipeWriter
+	if t.config.ProgressEnabled && !t.config.Verbose {
+		stderrIn, stderrOut = io.Pipe()
+		cmd.Stderr = io.MultiWriter(tail, stderrOut)
+	}
+
+	if t.config.Verbose {
+		cmd.Stderr = io.MultiWriter(tail, os.Stdout)
+	}
+
+	// Start process
+	// The process is not bound to the context: when the context expires it is
+	// stopped gracefully by watch instead of being killed outright
+	proc, err := t.config.runner().Start(context.Background(), cmd)
+	stdinIn.Close()
+	if err != nil {
+		stdinOut.Close()
+		return nil, fmt.Errorf("Failed starting transcoding (%s) with args (%s) with error %s", t.config.FfmpegBinPath, args, err)
+	}
+
+	done := make(chan struct{})
+	t.mu.Lock()
+	t.process = proc
+	t.stdin = stdinOut
+	t.done = done
+	t.mu.Unlock()
+	t.setState(StateRunning)
+
+	go t.wait(cmd, tail, stderrOut)
+	go t.watch()
+
+	if t.config.ProgressEnabled && !t.config.Verbose {
+		go func() {
+			defer close(out)
+			t.progress(stderrIn, out)
+		}()
+	} else {
+		<-done
+		close(out)
+		return out, t.err
+	}
+
+	return out, nil
+}
+
+// Wait blocks until the started ffmpeg process exits and returns its error.
+// It may be called from another goroutine while Start blocks
+func (t *Transcoder) Wait() error {
+	_, _, done := t.started()
+	if done == nil {
+		return errNotStarted
+	}
+	<-done
+	return t.err
+}
+
+// Stop asks ffmpeg to finish the output it is writing, so containers get
+// their trailers (MP4 moov atom, HLS #EXT-X-ENDLIST) and remain playable.
+// The request is sent as "q" on stdin, then as SIGINT halfway through the
+// grace period in case ffmpeg does not read stdin. If ffmpeg has not exited
+// once the grace period is over it is killed. Stop blocks until the process
+// has exited
+func (t *Transcoder) Stop() error {
+	proc, stdin, done := t.started()
+	if done == nil {
+		return errNotStarted
+	}
+
+	select {
+	case <-done:
+		return t.err
+	default:
+	}
+
+	// A stopped process cannot read stdin or handle signals
+	if t.State() == StatePaused {
+		t.Resume()
+	}
+	t.setState(StateFinishing)
+
+	// Fall back to SIGINT, which ffmpeg handles the same way, when stdin
+	// is not connected to the process or, as with -nostdin, not read
+	grace := t.config.gracePeriod()
+	if _, err := stdin.Write([]byte("q")); err != nil {
+		proc.Signal(os.Interrupt)
+	} else {
+		select {
+		case <-done:
+			return t.err
+		case <-time.After(grace / 2):
+			proc.Signal(os.Interrupt)
+			grace -= grace / 2
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		proc.Signal(os.Kill)
+		<-done
+	}
+
+	return t.err
+}
+
+// started returns the process started by Start, its stdin and a channel
+// closed once it exits, all nil until then
+func (t *Transcoder) started() (Process, io.WriteCloser, chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.process, t.stdin, t.done
+}
+
+// wait reaps the process, classifies its failure and releases its pipes
+func (t *Transcoder) wait(cmd Cmd, tail *tailWriter, stderr *io.PipeWriter) {
+	proc, stdin, done := t.started()
+	err := proc.Wait()
+
+	// ffmpeg exits with 255 when interrupted, even after finalizing
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && exitErr.Code == 255 && t.State() == StateFinishing {
+		err = nil
+	}
+
+	if err != nil {
+		err = newError(cmd.Path, cmd.Args, err, tail.String())
+	}
+
+	t.err = err
+	if err != nil {
+		t.setState(StateFailed)
+	} else {
+		t.setState(StateDone)
+	}
+
+	stdin.Close()
+	if stderr != nil {
+		stderr.CloseWithError(err)
+	}
+	close(done)
+}
+
+// watch stops the process gracefully when the context supplied with
+// WithContext expires
+func (t *Transcoder) watch() {
+	if t.commandContext == nil {
+		return
+	}
+
+	_, _, done := t.started()
+	select {
+	case <-(*t.commandContext).Done():
+		t.Stop()
+	case <-done:
+	}
+}
+
+// context returns the context supplied with WithContext, if any
+func (t *Transcoder) context() context.Context {
+	if t.commandContext == nil {
+		return context.Background()
+	}
+	return *t.commandContext
+}
+
+// Command returns the ffmpeg command line Start would execute, starting
+// with the binary path. Nothing is executed, so it also works in dry-run mode
+func (t *Transcoder) Command() ([]string, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t.command(), nil
+}
+
+// CommandString returns the command line as a shell-quoted string, suitable
+// for logging or pasting into a terminal
+func (t *Transcoder) CommandString() (string, error) {
+	cmd, err := t.Command()
+	if err != nil {
+		return "", err
+	}
+	return utils.ShellQuote(cmd), nil
+}
+
+// command prepends the ffmpeg binary path to args
+func (t *Transcoder) command() []string {
+	return append([]string{t.config.FfmpegBinPath}, t.args()...)
+}
+
+// args assembles the ffmpeg arguments: input options, input, then each
+// output preceded by its options
+func (t *Transcoder) args() []string {
 	// Append input file and standard options
 	var args []string
 
@@ -90,49 +324,7 @@ func (t *Transcoder) Start() (<-chan transcoder.Progress, error) {
 		}
 	}
 
-	// Initialize command
-	// If a context object was supplied to this Transcoder before
-	// starting, use this context when creating the command to allow
-	// the command to be killed when the context expires
-	var cmd *exec.Cmd
-	if t.commandContext == nil {
-		cmd = exec.Command(t.config.FfmpegBinPath, args...)
-	} else {
-		cmd = exec.CommandContext(*t.commandContext, t.config.FfmpegBinPath, args...)
-	}
-
-	// If progresss enabled, get stderr pipe and start progress process
-	if t.config.ProgressEnabled && !t.config.Verbose {
-		stderrIn, err = cmd.StderrPipe()
-		if err != nil {
-			return nil, fmt.Errorf("Failed getting transcoding progress (%s) with args (%s) with error %s", t.config.FfmpegBinPath, args, err)
-		}
-	}
-
-	if t.config.Verbose {
-		cmd.Stderr = os.Stdout
-	}
-
-	// Start process
-	err = cmd.Start()
-	if err != nil {
-		return nil, fmt.Errorf("Failed starting transcoding (%s) with args (%s) with error %s", t.config.FfmpegBinPath, args, err)
-	}
-
-	if t.config.ProgressEnabled && !t.config.Verbose {
-		go func() {
-			t.progress(stderrIn, out)
-		}()
-
-		go func() {
-			defer close(out)
-			err = cmd.Wait()
-		}()
-	} else {
-		err = cmd.Wait()
-	}
-
-	return out, nil
+	return args
 }
 
 // Input ...
@@ -190,8 +382,9 @@ func (t *Transcoder) WithAdditionalOutputOptions(opts transcoder.Options) transc
 }
 
 // WithContext is to be used on a Transcoder *before Starting* to
-// pass in a context.Context object that can be used to kill
-// a running transcoder process. Usage of this method is optional
+// pass in a context.Context object that can be used to stop
+// a running transcoder process. When the context expires the process
+// is stopped gracefully, as with Stop. Usage of this method is optional
 func (t *Transcoder) WithContext(ctx *context.Context) transcoder.Transcoder {
 	t.commandContext = ctx
 	return t
@@ -232,8 +425,6 @@ func (t *Transcoder) validate() error {
 func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
 
 	if t.config.FfprobeBinPath != "" {
-		var outb, errb bytes.Buffer
-
 		input := t.input
 
 		if t.inputPipeReader != nil {
@@ -242,18 +433,14 @@ func (t *Transcoder) GetMetadata() (transcoder.Metadata, error) {
 
 		args := []string{"-i", input, "-print_format", "json", "-show_format", "-show_streams", "-show_error"}
 
-		cmd := exec.Command(t.config.FfprobeBinPath, args...)
-		cmd.Stdout = &outb
-		cmd.Stderr = &errb
-
-		err := cmd.Run()
+		out, _, err := run(t.context(), t.config, t.config.FfprobeBinPath, args)
 		if err != nil {
-			return nil, fmt.Errorf("error executing (%s) with args (%s) | error: %s | message: %s %s", t.config.FfprobeBinPath, args, err, outb.String(), errb.String())
+			return nil, err
 		}
 
 		var metadata Metadata
 
-		if err = json.Unmarshal([]byte(outb.String()), &metadata); err != nil {
+		if err = json.Unmarshal([]byte(out), &metadata); err != nil {
 			return nil, err
 		}
 
@@ -341,6 +528,18 @@ func (t *Transcoder) progress(stream io.ReadCloser, out chan transcoder.Progress
 			progress := (timesec * 100) / dursec
 			Progress.Progress = progress
 
+			// ffmpeg's own speed counts time spent paused, so recompute it
+			// from the active time once the process has been paused
+			elapsed, paused := t.activity()
+			if paused && elapsed > 0 {
+				currentSpeed = fmt.Sprintf("%.3gx", timesec/elapsed.Seconds())
+			}
+
+			Progress.Elapsed = elapsed
+			if timesec > 0 && dursec > timesec {
+				Progress.ETA = time.Duration((dursec - timesec) / timesec * float64(elapsed))
+			}
+
 			Progress.CurrentBitrate = currentBitrate
 			Progress.FramesProcessed = framesProcessed
 			Progress.CurrentTime = currentTime
@@ -0,0 +1,390 @@
+package ffmpeg_test
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func TestStartRunsAssembledCommand(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(10*time.Second, 4)},
+		transcodertest.Script{Stdout: transcodertest.Probe(10*time.Second, transcodertest.VideoStream("h264", 1920, 1080))},
+	)
+
+	format := "mp4"
+	progress, err := ffmpeg.New(&ffmpeg.Config{
+		FfmpegBinPath:   "/usr/bin/ffmpeg",
+		FfprobeBinPath:  "/usr/bin/ffprobe",
+		ProgressEnabled: true,
+		Runner:          runner,
+	}).
+		Input("in.mov").
+		Output("out.mp4").
+		WithOutputOptions(ffmpeg.Options{OutputFormat: &format}).
+		Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float64
+	for p := range progress {
+		got = append(got, p.GetProgress())
+	}
+
+	if want := []float64{25, 50, 75, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("progress = %v, want %v", got, want)
+	}
+
+	calls := runner.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want ffprobe and ffmpeg", len(calls))
+	}
+	if want := []string{"-i", "in.mov", "-f", "mp4", "out.mp4"}; !reflect.DeepEqual(calls[1].Args, want) {
+		t.Errorf("ffmpeg args = %q, want %q", calls[1].Args, want)
+	}
+}
+
+func TestStartWithExecutables(t *testing.T) {
+	ffmpegBin := transcodertest.FFmpeg(t, transcodertest.Script{Stderr: transcodertest.Progress(2*time.Second, 2)})
+	ffprobeBin := transcodertest.FFprobe(t, transcodertest.Script{Stdout: transcodertest.Probe(2 * time.Second)})
+
+	progress, err := ffmpeg.New(&ffmpeg.Config{
+		FfmpegBinPath:   ffmpegBin.Path,
+		FfprobeBinPath:  ffprobeBin.Path,
+		ProgressEnabled: true,
+	}).
+		Input("in file.mov").
+		Output("out.mp4").
+		Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last float64
+	for p := range progress {
+		last = p.GetProgress()
+	}
+	if last != 100 {
+		t.Errorf("final progress = %v, want 100", last)
+	}
+
+	calls, err := ffmpegBin.Calls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"-i", "in file.mov", "out.mp4"}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("ffmpeg calls = %q, want %q", calls, want)
+	}
+}
+
+func TestProgressFields(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: "frame=  250 fps= 50 q=28.0 size=    1024kB time=00:00:10.00 bitrate= 838.9kbits/s speed=2.00x\r"},
+		transcodertest.Script{Stdout: transcodertest.Probe(40 * time.Second)},
+	)
+
+	progress, err := ffmpeg.New(&ffmpeg.Config{
+		FfmpegBinPath:   "ffmpeg",
+		FfprobeBinPath:  "ffprobe",
+		ProgressEnabled: true,
+		Runner:          runner,
+	}).Input("in.mov").Output("out.mp4").Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := <-progress
+	if p.GetFramesProcessed() != "250" || p.GetCurrentTime() != "00:00:10.00" ||
+		p.GetCurrentBitrate() != "838.9kbits/s" || p.GetSpeed() != "2.00x" || p.GetProgress() != 25 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+}
+
+func TestGetMetadata(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{
+		Stdout: transcodertest.Probe(90*time.Second,
+			transcodertest.VideoStream("hevc", 3840, 2160),
+			transcodertest.AudioStream("aac")),
+	})
+
+	metadata, err := ffmpeg.New(&ffmpeg.Config{FfprobeBinPath: "ffprobe", Runner: runner}).
+		Input("in.mkv").
+		GetMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := metadata.GetFormat().GetDuration(); d != "90.000000" {
+		t.Errorf("duration = %q", d)
+	}
+
+	streams := metadata.GetStreams()
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(streams))
+	}
+	if s := streams[0]; s.GetCodecName() != "hevc" || s.GetWidth() != 3840 || s.GetHeight() != 2160 {
+		t.Errorf("unexpected video stream %+v", s)
+	}
+	if s := streams[1]; s.GetCodecType() != "audio" || s.GetIndex() != 1 {
+		t.Errorf("unexpected audio stream %+v", s)
+	}
+
+	if want := []string{"-i", "in.mkv", "-print_format", "json", "-show_format", "-show_streams", "-show_error"}; !reflect.DeepEqual(runner.Calls()[0].Args, want) {
+		t.Errorf("ffprobe args = %q, want %q", runner.Calls()[0].Args, want)
+	}
+}
+
+func TestGetMetadataFailure(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{
+		Stderr:   "in.mkv: No such file or directory\n",
+		ExitCode: 1,
+	})
+
+	_, err := ffmpeg.New(&ffmpeg.Config{FfprobeBinPath: "ffprobe", Runner: runner}).Input("in.mkv").GetMetadata()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestCommandDryRun(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{})
+
+	codec, preset := "libx264", "slow"
+	trans := ffmpeg.New(&ffmpeg.Config{FfmpegBinPath: "ffmpeg", DryRun: true, Runner: runner}).
+		Input("in put.mov").
+		Output("low.mp4").
+		Output("high.mp4").
+		WithOutputOptions(ffmpeg.Options{VideoCodec: &codec}).
+		WithAdditionalOutputOptions(ffmpeg.Options{Preset: &preset})
+
+	progress, err := trans.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-progress; ok {
+		t.Error("dry run should close the progress channel immediately")
+	}
+	if calls := runner.Calls(); len(calls) != 0 {
+		t.Errorf("dry run started %d processes", len(calls))
+	}
+
+	cmd, err := trans.(transcoder.Commander).Command()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ffmpeg", "-i", "in put.mov", "-c:v", "libx264", "low.mp4", "-preset", "slow", "high.mp4"}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("command = %q, want %q", cmd, want)
+	}
+
+	str, err := trans.(*ffmpeg.Transcoder).CommandString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ffmpeg -i 'in put.mov' -c:v libx264 low.mp4 -preset slow high.mp4"; str != want {
+		t.Errorf("command string = %q, want %q", str, want)
+	}
+}
+
+func TestStopFinalizesGracefully(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 600), LineDelay: 10 * time.Millisecond},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+
+	trans := ffmpeg.New(&ffmpeg.Config{
+		FfmpegBinPath:   "ffmpeg",
+		FfprobeBinPath:  "ffprobe",
+		ProgressEnabled: true,
+		Runner:          runner,
+	}).Input("rtmp://live/stream").Output("recording.mp4").(*ffmpeg.Transcoder)
+
+	progress, err := trans.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-progress
+
+	if err := trans.Stop(); err != nil {
+		t.Errorf("Stop() = %v, want a clean exit", err)
+	}
+	for range progress {
+	}
+	if err := trans.Wait(); err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+	if signals := runner.Signals(); len(signals) != 0 {
+		t.Errorf("signals = %v, want ffmpeg to finish on q", signals)
+	}
+}
+
+func TestStopInterruptsWhenStdinIgnored(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 600), LineDelay: 10 * time.Millisecond, IgnoreStdin: true},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+
+	trans := ffmpeg.New(&ffmpeg.Config{
+		FfmpegBinPath:   "ffmpeg",
+		FfprobeBinPath:  "ffprobe",
+		ProgressEnabled: true,
+		Runner:          runner,
+		GracePeriod:     time.Second,
+	}).Input("in.mov").Output("out.mp4").(*ffmpeg.Transcoder)
+
+	progress, err := trans.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-progress
+
+	drained := make(chan struct{})
+	go func() {
+		for range progress {
+		}
+		close(drained)
+	}()
+
+	// ffmpeg ignores "q" and finalizes on SIGINT, before it would be killed
+	if err := trans.Stop(); err != nil {
+		t.Errorf("Stop() = %v, want a clean exit", err)
+	}
+	<-drained
+	if signals := runner.Signals(); len(signals) != 1 || signals[0] != os.Interrupt {
+		t.Errorf("signals = %v, want an interrupt", signals)
+	}
+}
+
+func TestStopWhileStartBlocks(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 600), LineDelay: 10 * time.Millisecond},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+
+	trans := ffmpeg.New(&ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}).
+		Input("in.mov").
+		Output("out.mp4").(*ffmpeg.Transcoder)
+
+	started := make(chan error, 1)
+	go func() {
+		_, err := trans.Start()
+		started <- err
+	}()
+
+	for trans.State() != ffmpeg.StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	if err := trans.Stop(); err != nil {
+		t.Errorf("Stop() = %v, want a clean exit", err)
+	}
+	if err := trans.Wait(); err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+	if err := <-started; err != nil {
+		t.Errorf("Start() = %v", err)
+	}
+}
+
+func TestContextStopsProcess(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 600), LineDelay: 10 * time.Millisecond},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	trans := ffmpeg.New(&ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}).
+		Input("in.mov").
+		Output("out.mp4").
+		WithContext(&ctx).(*ffmpeg.Transcoder)
+
+	start := time.Now()
+	if _, err := trans.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Wait(); err != nil {
+		t.Errorf("Wait() = %v, want a clean exit", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("process ran for %v after the context expired", elapsed)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 100), LineDelay: 5 * time.Millisecond},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+
+	trans := ffmpeg.New(&ffmpeg.Config{
+		FfmpegBinPath:   "ffmpeg",
+		FfprobeBinPath:  "ffprobe",
+		ProgressEnabled: true,
+		Runner:          runner,
+	}).Input("in.mov").Output("out.mp4").(*ffmpeg.Transcoder)
+
+	if err := trans.Pause(); err == nil {
+		t.Error("Pause() before Start should fail")
+	}
+
+	start := time.Now()
+	progress, err := trans.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-progress
+
+	if err := trans.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if s := trans.State(); s != ffmpeg.StatePaused {
+		t.Errorf("state = %v, want paused", s)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := trans.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	var last ffmpeg.Progress
+	for p := range progress {
+		last = p.(ffmpeg.Progress)
+	}
+
+	if err := trans.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if s := trans.State(); s != ffmpeg.StateDone {
+		t.Errorf("state = %v, want done", s)
+	}
+	if wall := time.Since(start); wall-last.GetElapsed() < 100*time.Millisecond {
+		t.Errorf("elapsed %v includes paused time (wall clock %v)", last.GetElapsed(), wall)
+	}
+	if last.GetSpeed() == "1.00x" {
+		t.Error("speed was not recomputed after a pause")
+	}
+}
+
+func TestFakeProcessSignalAfterExit(t *testing.T) {
+	runner := ffmpeg.NewFakeRunner(ffmpeg.FakeResult{Stderr: transcodertest.Progress(time.Second, 10)}, ffmpeg.FakeResult{})
+
+	p, err := runner.Start(context.Background(), ffmpeg.Cmd{Path: "ffmpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if err := p.Signal(os.Interrupt); err == nil {
+		t.Error("Signal() after exit should fail")
+	}
+}
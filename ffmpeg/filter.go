@@ -0,0 +1,16 @@
+package ffmpeg
+
+import "strings"
+
+var (
+	optionEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`)
+	graphEscaper  = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`)
+)
+
+// EscapeFilterValue escapes a filter option value, such as a file path, for
+// use inside a filtergraph passed to -vf, -af or -filter_complex. Both
+// escaping levels ffmpeg applies are handled: the option value and the
+// filtergraph description
+func EscapeFilterValue(v string) string {
+	return graphEscaper.Replace(optionEscaper.Replace(v))
+}
@@ -53,11 +53,17 @@ type Streams struct {
 	Duration           string      `json:"duration"`
 	Disposition        Disposition `json:"disposition"`
 	BitRate            string      `json:"bit_rate"`
+	SampleRate         string      `json:"sample_rate"`
+	Channels           int         `json:"channels"`
+	ChannelLayout      string      `json:"channel_layout"`
+	Tags               Tags        `json:"tags"`
 }
 
 // Tags ...
 type Tags struct {
-	Encoder string `json:"ENCODER"`
+	Encoder  string `json:"ENCODER"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
 }
 
 // Disposition ...
@@ -142,6 +148,16 @@ func (t Tags) GetEncoder() string {
 	return t.Encoder
 }
 
+// GetLanguage ...
+func (t Tags) GetLanguage() string {
+	return t.Language
+}
+
+// GetTitle ...
+func (t Tags) GetTitle() string {
+	return t.Title
+}
+
 //GetIndex ...
 func (s Streams) GetIndex() int {
 	return s.Index
@@ -287,6 +303,26 @@ func (s Streams) GetBitRate() string {
 	return s.BitRate
 }
 
+// GetSampleRate ...
+func (s Streams) GetSampleRate() string {
+	return s.SampleRate
+}
+
+// GetChannels ...
+func (s Streams) GetChannels() int {
+	return s.Channels
+}
+
+// GetChannelLayout ...
+func (s Streams) GetChannelLayout() string {
+	return s.ChannelLayout
+}
+
+//GetTags ...
+func (s Streams) GetTags() transcoder.Tags {
+	return s.Tags
+}
+
 //GetDefault ...
 func (d Disposition) GetDefault() int {
 	return d.Default
@@ -3,69 +3,86 @@ package ffmpeg
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 )
 
 // Options defines allowed FFmpeg arguments
 type Options struct {
-	Aspect                *string           `flag:"-aspect"`
-	Resolution            *string           `flag:"-s"`
-	VideoBitRate          *string           `flag:"-b:v"`
-	VideoBitRateTolerance *int              `flag:"-bt"`
-	VideoMaxBitRate       *int              `flag:"-maxrate"`
-	VideoMinBitrate       *int              `flag:"-minrate"`
-	VideoCodec            *string           `flag:"-c:v"`
-	Vframes               *int              `flag:"-vframes"`
-	FrameRate             *int              `flag:"-r"`
-	AudioRate             *int              `flag:"-ar"`
-	KeyframeInterval      *int              `flag:"-g"`
-	AudioCodec            *string           `flag:"-c:a"`
-	AudioBitrate          *string           `flag:"-ab"`
-	AudioChannels         *int              `flag:"-ac"`
-	AudioVariableBitrate  *bool             `flag:"-q:a"`
-	BufferSize            *int              `flag:"-bufsize"`
-	Threadset             *bool             `flag:"-threads"`
-	Threads               *int              `flag:"-threads"`
-	Preset                *string           `flag:"-preset"`
-	Tune                  *string           `flag:"-tune"`
-	AudioProfile          *string           `flag:"-profile:a"`
-	VideoProfile          *string           `flag:"-profile:v"`
-	Target                *string           `flag:"-target"`
-	Duration              *string           `flag:"-t"`
-	Qscale                *uint32           `flag:"-qscale"`
-	Crf                   *uint32           `flag:"-crf"`
-	Strict                *int              `flag:"-strict"`
-	MuxDelay              *string           `flag:"-muxdelay"`
-	SeekTime              *string           `flag:"-ss"`
-	SeekUsingTimestamp    *bool             `flag:"-seek_timestamp"`
-	MovFlags              *string           `flag:"-movflags"`
-	HideBanner            *bool             `flag:"-hide_banner"`
-	OutputFormat          *string           `flag:"-f"`
-	CopyTs                *bool             `flag:"-copyts"`
-	NativeFramerateInput  *bool             `flag:"-re"`
-	InputInitialOffset    *string           `flag:"-itsoffset"`
-	RtmpLive              *string           `flag:"-rtmp_live"`
-	HlsPlaylistType       *string           `flag:"-hls_playlist_type"`
-	HlsListSize           *int              `flag:"-hls_list_size"`
-	HlsSegmentDuration    *int              `flag:"-hls_time"`
-	HlsMasterPlaylistName *string           `flag:"-master_pl_name"`
-	HlsSegmentFilename    *string           `flag:"-hls_segment_filename"`
-	HTTPMethod            *string           `flag:"-method"`
-	HTTPKeepAlive         *bool             `flag:"-multiple_requests"`
-	Hwaccel               *string           `flag:"-hwaccel"`
-	StreamIds             map[string]string `flag:"-streamid"`
-	VideoFilter           *string           `flag:"-vf"`
-	AudioFilter           *string           `flag:"-af"`
-	SkipVideo             *bool             `flag:"-vn"`
-	SkipAudio             *bool             `flag:"-an"`
-	CompressionLevel      *int              `flag:"-compression_level"`
-	MapMetadata           *string           `flag:"-map_metadata"`
-	Metadata              map[string]string `flag:"-metadata"`
-	EncryptionKey         *string           `flag:"-hls_key_info_file"`
-	Bframe                *int              `flag:"-bf"`
-	PixFmt                *string           `flag:"-pix_fmt"`
-	WhiteListProtocols    []string          `flag:"-protocol_whitelist"`
-	Overwrite             *bool             `flag:"-y"`
-	ExtraArgs             map[string]interface{}
+	Aspect                *string                `flag:"-aspect" json:"aspect,omitempty" yaml:"aspect,omitempty"`
+	Resolution            *string                `flag:"-s" json:"resolution,omitempty" yaml:"resolution,omitempty"`
+	VideoBitRate          *string                `flag:"-b:v" json:"video_bit_rate,omitempty" yaml:"video_bit_rate,omitempty"`
+	VideoBitRateTolerance *int                   `flag:"-bt" json:"video_bit_rate_tolerance,omitempty" yaml:"video_bit_rate_tolerance,omitempty"`
+	VideoMaxBitRate       *int                   `flag:"-maxrate" json:"video_max_bit_rate,omitempty" yaml:"video_max_bit_rate,omitempty"`
+	VideoMinBitrate       *int                   `flag:"-minrate" json:"video_min_bitrate,omitempty" yaml:"video_min_bitrate,omitempty"`
+	VideoCodec            *string                `flag:"-c:v" json:"video_codec,omitempty" yaml:"video_codec,omitempty"`
+	Vframes               *int                   `flag:"-vframes" json:"vframes,omitempty" yaml:"vframes,omitempty"`
+	FrameRate             *int                   `flag:"-r" json:"frame_rate,omitempty" yaml:"frame_rate,omitempty"`
+	AudioRate             *int                   `flag:"-ar" json:"audio_rate,omitempty" yaml:"audio_rate,omitempty"`
+	KeyframeInterval      *int                   `flag:"-g" json:"keyframe_interval,omitempty" yaml:"keyframe_interval,omitempty"`
+	AudioCodec            *string                `flag:"-c:a" json:"audio_codec,omitempty" yaml:"audio_codec,omitempty"`
+	AudioBitrate          *string                `flag:"-ab" json:"audio_bitrate,omitempty" yaml:"audio_bitrate,omitempty"`
+	AudioChannels         *int                   `flag:"-ac" json:"audio_channels,omitempty" yaml:"audio_channels,omitempty"`
+	AudioVariableBitrate  *bool                  `flag:"-q:a" json:"audio_variable_bitrate,omitempty" yaml:"audio_variable_bitrate,omitempty"`
+	BufferSize            *int                   `flag:"-bufsize" json:"buffer_size,omitempty" yaml:"buffer_size,omitempty"`
+	Threadset             *bool                  `flag:"-threads" json:"threadset,omitempty" yaml:"threadset,omitempty"`
+	Threads               *int                   `flag:"-threads" json:"threads,omitempty" yaml:"threads,omitempty"`
+	Preset                *string                `flag:"-preset" json:"preset,omitempty" yaml:"preset,omitempty"`
+	Tune                  *string                `flag:"-tune" json:"tune,omitempty" yaml:"tune,omitempty"`
+	AudioProfile          *string                `flag:"-profile:a" json:"audio_profile,omitempty" yaml:"audio_profile,omitempty"`
+	VideoProfile          *string                `flag:"-profile:v" json:"video_profile,omitempty" yaml:"video_profile,omitempty"`
+	Target                *string                `flag:"-target" json:"target,omitempty" yaml:"target,omitempty"`
+	Duration              *string                `flag:"-t" json:"duration,omitempty" yaml:"duration,omitempty"`
+	Qscale                *uint32                `flag:"-qscale" json:"qscale,omitempty" yaml:"qscale,omitempty"`
+	Crf                   *uint32                `flag:"-crf" json:"crf,omitempty" yaml:"crf,omitempty"`
+	Strict                *int                   `flag:"-strict" json:"strict,omitempty" yaml:"strict,omitempty"`
+	MuxDelay              *string                `flag:"-muxdelay" json:"mux_delay,omitempty" yaml:"mux_delay,omitempty"`
+	SeekTime              *string                `flag:"-ss" json:"seek_time,omitempty" yaml:"seek_time,omitempty"`
+	SeekUsingTimestamp    *bool                  `flag:"-seek_timestamp" json:"seek_using_timestamp,omitempty" yaml:"seek_using_timestamp,omitempty"`
+	MovFlags              *string                `flag:"-movflags" json:"mov_flags,omitempty" yaml:"mov_flags,omitempty"`
+	HideBanner            *bool                  `flag:"-hide_banner" json:"hide_banner,omitempty" yaml:"hide_banner,omitempty"`
+	OutputFormat          *string                `flag:"-f" json:"output_format,omitempty" yaml:"output_format,omitempty"`
+	CopyTs                *bool                  `flag:"-copyts" json:"copy_ts,omitempty" yaml:"copy_ts,omitempty"`
+	NativeFramerateInput  *bool                  `flag:"-re" json:"native_framerate_input,omitempty" yaml:"native_framerate_input,omitempty"`
+	InputInitialOffset    *string                `flag:"-itsoffset" json:"input_initial_offset,omitempty" yaml:"input_initial_offset,omitempty"`
+	RtmpLive              *string                `flag:"-rtmp_live" json:"rtmp_live,omitempty" yaml:"rtmp_live,omitempty"`
+	HlsPlaylistType       *string                `flag:"-hls_playlist_type" json:"hls_playlist_type,omitempty" yaml:"hls_playlist_type,omitempty"`
+	HlsListSize           *int                   `flag:"-hls_list_size" json:"hls_list_size,omitempty" yaml:"hls_list_size,omitempty"`
+	HlsSegmentDuration    *int                   `flag:"-hls_time" json:"hls_segment_duration,omitempty" yaml:"hls_segment_duration,omitempty"`
+	HlsMasterPlaylistName *string                `flag:"-master_pl_name" json:"hls_master_playlist_name,omitempty" yaml:"hls_master_playlist_name,omitempty"`
+	HlsSegmentFilename    *string                `flag:"-hls_segment_filename" json:"hls_segment_filename,omitempty" yaml:"hls_segment_filename,omitempty"`
+	HTTPMethod            *string                `flag:"-method" json:"http_method,omitempty" yaml:"http_method,omitempty"`
+	HTTPKeepAlive         *bool                  `flag:"-multiple_requests" json:"http_keep_alive,omitempty" yaml:"http_keep_alive,omitempty"`
+	Hwaccel               *string                `flag:"-hwaccel" json:"hwaccel,omitempty" yaml:"hwaccel,omitempty"`
+	StreamIds             map[string]string      `flag:"-streamid" json:"stream_ids,omitempty" yaml:"stream_ids,omitempty"`
+	VideoFilter           *string                `flag:"-vf" json:"video_filter,omitempty" yaml:"video_filter,omitempty"`
+	AudioFilter           *string                `flag:"-af" json:"audio_filter,omitempty" yaml:"audio_filter,omitempty"`
+	SkipVideo             *bool                  `flag:"-vn" json:"skip_video,omitempty" yaml:"skip_video,omitempty"`
+	SkipAudio             *bool                  `flag:"-an" json:"skip_audio,omitempty" yaml:"skip_audio,omitempty"`
+	SkipSubtitle          *bool                  `flag:"-sn" json:"skip_subtitle,omitempty" yaml:"skip_subtitle,omitempty"`
+	SubtitleCodec         *string                `flag:"-c:s" json:"subtitle_codec,omitempty" yaml:"subtitle_codec,omitempty"`
+	CompressionLevel      *int                   `flag:"-compression_level" json:"compression_level,omitempty" yaml:"compression_level,omitempty"`
+	MapMetadata           *string                `flag:"-map_metadata" json:"map_metadata,omitempty" yaml:"map_metadata,omitempty"`
+	MapChapters           *int                   `flag:"-map_chapters" json:"map_chapters,omitempty" yaml:"map_chapters,omitempty"`
+	Metadata              map[string]string      `flag:"-metadata" json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	EncryptionKey         *string                `flag:"-hls_key_info_file" json:"encryption_key,omitempty" yaml:"encryption_key,omitempty"`
+	Bframe                *int                   `flag:"-bf" json:"bframe,omitempty" yaml:"bframe,omitempty"`
+	PixFmt                *string                `flag:"-pix_fmt" json:"pix_fmt,omitempty" yaml:"pix_fmt,omitempty"`
+	WhiteListProtocols    []string               `flag:"-protocol_whitelist" json:"white_list_protocols,omitempty" yaml:"white_list_protocols,omitempty"`
+	Overwrite             *bool                  `flag:"-y" json:"overwrite,omitempty" yaml:"overwrite,omitempty"`
+	ExtraArgs             map[string]interface{} `json:"extra_args,omitempty" yaml:"extra_args,omitempty"`
+
+	// StreamMetadata holds tags keyed by metadata specifier, such as "s:a:0"
+	// for the first audio stream or "c:2" for the third chapter
+	StreamMetadata map[string]map[string]string `flag:"-metadata" json:"stream_metadata,omitempty" yaml:"stream_metadata,omitempty"`
+	// Disposition holds dispositions keyed by stream specifier, such as
+	// "v:1": "attached_pic"
+	Disposition map[string]string `flag:"-disposition" json:"disposition,omitempty" yaml:"disposition,omitempty"`
+
+	HwaccelDevice       *string `flag:"-hwaccel_device" json:"hwaccel_device,omitempty" yaml:"hwaccel_device,omitempty"`
+	HwaccelOutputFormat *string `flag:"-hwaccel_output_format" json:"hwaccel_output_format,omitempty" yaml:"hwaccel_output_format,omitempty"`
+	InitHwDevice        *string `flag:"-init_hw_device" json:"init_hw_device,omitempty" yaml:"init_hw_device,omitempty"`
+	FilterHwDevice      *string `flag:"-filter_hw_device" json:"filter_hw_device,omitempty" yaml:"filter_hw_device,omitempty"`
 }
 
 // GetStrArguments ...
@@ -81,7 +98,8 @@ func (opts Options) GetStrArguments() []string {
 
 		if !v.Field(i).IsNil() {
 
-			if _, ok := value.(*bool); ok {
+			// Specs may set flags to false, which leaves them out
+			if vb, ok := value.(*bool); ok && *vb {
 				values = append(values, flag)
 			}
 
@@ -98,17 +116,136 @@ func (opts Options) GetStrArguments() []string {
 			}
 
 			if vm, ok := value.(map[string]interface{}); ok {
-				for k, v := range vm {
-					values = append(values, k, fmt.Sprintf("%v", v))
+				keys := make([]string, 0, len(vm))
+				for k := range vm {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+
+				for _, k := range keys {
+					// Flags without a value, like "-an", take nil or ""
+					switch v := vm[k]; v := v.(type) {
+					case nil:
+						values = append(values, k)
+					case string:
+						values = append(values, k)
+						if v != "" {
+							values = append(values, v)
+						}
+					case float64:
+						// Decoded JSON numbers, written without an exponent
+						values = append(values, k, strconv.FormatFloat(v, 'f', -1, 64))
+					default:
+						values = append(values, k, fmt.Sprintf("%v", v))
+					}
+				}
+			}
+
+			if vm, ok := value.(map[string]string); ok {
+				keys := make([]string, 0, len(vm))
+				for k := range vm {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+
+				for _, k := range keys {
+					switch flag {
+					case "-disposition":
+						values = append(values, flag+":"+k, vm[k])
+					case "-streamid":
+						values = append(values, flag, k+":"+vm[k])
+					default:
+						values = append(values, flag, k+"="+vm[k])
+					}
+				}
+			}
+
+			if vm, ok := value.(map[string]map[string]string); ok {
+				specs := make([]string, 0, len(vm))
+				for spec := range vm {
+					specs = append(specs, spec)
+				}
+				sort.Strings(specs)
+
+				for _, spec := range specs {
+					keys := make([]string, 0, len(vm[spec]))
+					for k := range vm[spec] {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+
+					for _, k := range keys {
+						values = append(values, flag+":"+spec, k+"="+vm[spec][k])
+					}
 				}
 			}
-			
+
 			if vi, ok := value.(*int); ok {
 				values = append(values, flag, fmt.Sprintf("%d", *vi))
 			}
 
+			if vu, ok := value.(*uint32); ok {
+				values = append(values, flag, fmt.Sprintf("%d", *vu))
+			}
+
 		}
 	}
 
 	return values
 }
+
+// Merge returns opts overridden by every field set in override. Map fields
+// are merged key by key, with override's values winning
+func (opts Options) Merge(override Options) Options {
+	merged := reflect.ValueOf(&opts).Elem()
+	o := reflect.ValueOf(override)
+
+	for i := 0; i < o.NumField(); i++ {
+		field := o.Field(i)
+		switch field.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			if field.IsNil() {
+				continue
+			}
+		default:
+			if field.IsZero() {
+				continue
+			}
+		}
+
+		if field.Kind() != reflect.Map || merged.Field(i).IsNil() {
+			merged.Field(i).Set(field)
+			continue
+		}
+
+		m := reflect.MakeMap(field.Type())
+		for _, src := range []reflect.Value{merged.Field(i), field} {
+			iter := src.MapRange()
+			for iter.Next() {
+				m.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		merged.Field(i).Set(m)
+	}
+
+	return opts
+}
+
+// RawArgs passes arguments as they are where options are expected
+type RawArgs []string
+
+// GetStrArguments ...
+func (a RawArgs) GetStrArguments() []string {
+	return a
+}
+
+// WithArg returns a copy of the ExtraArgs args with key set to value
+func WithArg(args map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	out := map[string]interface{}{key: value}
+	for k, v := range args {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
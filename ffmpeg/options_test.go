@@ -0,0 +1,109 @@
+package ffmpeg_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+func TestGetStrArguments(t *testing.T) {
+	codec, bitrate := "libx264", "2M"
+	threads, crf := 4, uint32(23)
+	overwrite, skipVideo := true, false
+
+	opts := ffmpeg.Options{
+		VideoCodec:         &codec,
+		VideoBitRate:       &bitrate,
+		Threads:            &threads,
+		Crf:                &crf,
+		Overwrite:          &overwrite,
+		SkipVideo:          &skipVideo,
+		WhiteListProtocols: []string{"file", "http"},
+		ExtraArgs:          map[string]interface{}{"-x264opts": "keyint=48", "-an": "", "-sn": nil, "-rc_init_occupancy": float64(17825792)},
+	}
+
+	want := []string{
+		"-b:v", "2M",
+		"-c:v", "libx264",
+		"-threads", "4",
+		"-crf", "23",
+		"-protocol_whitelist", "file",
+		"-protocol_whitelist", "http",
+		"-y",
+		"-an",
+		"-rc_init_occupancy", "17825792",
+		"-sn",
+		"-x264opts", "keyint=48",
+	}
+
+	if got := opts.GetStrArguments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetStrArguments() = %q, want %q", got, want)
+	}
+}
+
+func TestGetStrArgumentsEmpty(t *testing.T) {
+	if got := (ffmpeg.Options{}).GetStrArguments(); len(got) != 0 {
+		t.Errorf("GetStrArguments() = %q, want none", got)
+	}
+}
+
+func TestGetStrArgumentsMetadata(t *testing.T) {
+	chapters := -1
+
+	opts := ffmpeg.Options{
+		MapChapters: &chapters,
+		Metadata:    map[string]string{"title": "Big Buck Bunny", "artist": "Blender"},
+		StreamIds:   map[string]string{"0": "33"},
+		StreamMetadata: map[string]map[string]string{
+			"s:a:0": {"language": "eng", "title": "Stereo"},
+			"c:0":   {"title": "Opening"},
+		},
+		Disposition: map[string]string{"v:1": "attached_pic"},
+	}
+
+	want := []string{
+		"-streamid", "0:33",
+		"-map_chapters", "-1",
+		"-metadata", "artist=Blender",
+		"-metadata", "title=Big Buck Bunny",
+		"-metadata:c:0", "title=Opening",
+		"-metadata:s:a:0", "language=eng",
+		"-metadata:s:a:0", "title=Stereo",
+		"-disposition:v:1", "attached_pic",
+	}
+
+	if got := opts.GetStrArguments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetStrArguments() = %q, want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	codec, preset, faster := "libx264", "slow", "veryfast"
+	crf := uint32(20)
+
+	base := ffmpeg.Options{
+		VideoCodec: &codec,
+		Preset:     &preset,
+		Metadata:   map[string]string{"title": "Base", "artist": "Studio"},
+	}
+	merged := base.Merge(ffmpeg.Options{
+		Preset:   &faster,
+		Crf:      &crf,
+		Metadata: map[string]string{"title": "Override"},
+	})
+
+	want := []string{
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "20",
+		"-metadata", "artist=Studio",
+		"-metadata", "title=Override",
+	}
+	if got := merged.GetStrArguments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %q, want %q", got, want)
+	}
+	if *base.Preset != "slow" || base.Metadata["title"] != "Base" {
+		t.Error("Merge() modified the base options")
+	}
+}
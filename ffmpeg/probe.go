@@ -0,0 +1,83 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Keyframes returns the presentation times, in seconds, of the keyframes of
+// the first video stream of input. Only packets are read, nothing is decoded
+func Keyframes(ctx context.Context, cfg *Config, input string) ([]float64, error) {
+	out, _, err := ExecProbe(ctx, cfg,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=print_section=0",
+		input)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyframes []float64
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 2 || !strings.Contains(fields[1], "K") {
+			continue
+		}
+		pts, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, pts)
+	}
+
+	return keyframes, nil
+}
+
+// FrameCount counts the packets of the first video stream of input, which
+// equals its number of frames
+func FrameCount(ctx context.Context, cfg *Config, input string) (int, error) {
+	out, _, err := ExecProbe(ctx, cfg,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-count_packets",
+		"-show_entries", "stream=nb_read_packets",
+		"-of", "csv=p=0",
+		input)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(strings.Trim(strings.TrimSpace(out), ","))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected frame count %q", out)
+	}
+
+	return n, nil
+}
+
+// Duration returns the container duration of input in seconds
+func Duration(ctx context.Context, cfg *Config, input string) (float64, error) {
+	out, _, err := ExecProbe(ctx, cfg,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "csv=p=0",
+		input)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected duration %q", out)
+	}
+
+	return d, nil
+}
+
+// FormatSeconds formats a time in seconds for ffmpeg options and lists
+func FormatSeconds(sec float64) string {
+	return strconv.FormatFloat(sec, 'f', 6, 64)
+}
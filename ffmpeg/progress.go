@@ -1,5 +1,7 @@
 package ffmpeg
 
+import "time"
+
 // Progress ...
 type Progress struct {
 	FramesProcessed string
@@ -7,6 +9,10 @@ type Progress struct {
 	CurrentBitrate  string
 	Progress        float64
 	Speed           string
+	// Elapsed is the time spent transcoding so far, excluding pauses
+	Elapsed time.Duration
+	// ETA estimates the remaining transcoding time from the progress rate
+	ETA time.Duration
 }
 
 // GetFramesProcessed ...
@@ -33,3 +39,13 @@ func (p Progress) GetProgress() float64 {
 func (p Progress) GetSpeed() string {
 	return p.Speed
 }
+
+// GetElapsed ...
+func (p Progress) GetElapsed() time.Duration {
+	return p.Elapsed
+}
+
+// GetETA ...
+func (p Progress) GetETA() time.Duration {
+	return p.ETA
+}
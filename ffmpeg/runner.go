@@ -0,0 +1,85 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Runner starts the ffmpeg and ffprobe processes used by a Transcoder.
+// Set Config.Runner to execute binaries somewhere other than the local
+// machine, or to replay scripted output in tests
+type Runner interface {
+	Start(ctx context.Context, cmd Cmd) (Process, error)
+}
+
+// Cmd describes a process to start
+type Cmd struct {
+	Path       string
+	Args       []string
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+	ExtraFiles []*os.File
+}
+
+// Process is a process started by a Runner
+type Process interface {
+	// Wait blocks until the process exits. An unsuccessful exit is reported
+	// as an *ExitError
+	Wait() error
+	// Signal delivers sig to the process
+	Signal(sig os.Signal) error
+}
+
+// ExitError reports a process that exited with a non-zero status
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExecRunner runs binaries on the local machine with os/exec
+type ExecRunner struct{}
+
+// Start ...
+func (ExecRunner) Start(ctx context.Context, c Cmd) (Process, error) {
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	cmd.ExtraFiles = c.ExtraFiles
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &execProcess{cmd: cmd}, nil
+}
+
+// execProcess ...
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+// Wait ...
+func (p *execProcess) Wait() error {
+	err := p.cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode()}
+	}
+
+	return err
+}
+
+// Signal ...
+func (p *execProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
@@ -0,0 +1,324 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errProcessDone = errors.New("process already finished")
+
+// FakeResult scripts the behaviour of one fake process
+type FakeResult struct {
+	// Stdout is written to the process stdout before any stderr output
+	Stdout string
+	// Stderr is a recorded stderr transcript, replayed one line at a time.
+	// Lines may be terminated by '\n' or '\r' like ffmpeg progress output
+	Stderr string
+	// LineDelay is slept between stderr lines. Signals are handled between
+	// lines either way
+	LineDelay time.Duration
+	// ExitCode is the status reported by Wait once the transcript is done
+	ExitCode int
+	// StartErr, when set, is returned from Start instead of a process
+	StartErr error
+	// IgnoreStdin keeps stdin open but unread, like ffmpeg -nostdin, so
+	// writes succeed and are ignored. Otherwise a "q" on stdin ends the
+	// process successfully, as it does ffmpeg
+	IgnoreStdin bool
+}
+
+// FakeRunner is a Runner that replays FakeResults instead of executing
+// binaries. Results are keyed by the base name of the binary path, e.g.
+// "ffmpeg" or "ffprobe". Each start consumes the next result for its binary;
+// the last one is repeated once the list is exhausted
+type FakeRunner struct {
+	Results map[string][]FakeResult
+	// Script, when set, picks the result of each command instead of Results,
+	// for tests whose processes must answer according to their arguments
+	Script func(cmd Cmd) FakeResult
+
+	mu      sync.Mutex
+	calls   []Cmd
+	signals []os.Signal
+	next    map[string]int
+}
+
+// NewFakeRunner returns a FakeRunner replaying ffmpeg and ffprobe results
+func NewFakeRunner(ffmpeg, ffprobe FakeResult) *FakeRunner {
+	return &FakeRunner{
+		Results: map[string][]FakeResult{
+			"ffmpeg":  {ffmpeg},
+			"ffprobe": {ffprobe},
+		},
+	}
+}
+
+// Calls returns every command started so far, in order
+func (r *FakeRunner) Calls() []Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Cmd(nil), r.calls...)
+}
+
+// Signals returns every signal delivered to the processes started so far,
+// in order
+func (r *FakeRunner) Signals() []os.Signal {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]os.Signal(nil), r.signals...)
+}
+
+// Start ...
+func (r *FakeRunner) Start(ctx context.Context, cmd Cmd) (Process, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, cmd)
+	script := r.Script
+	var res FakeResult
+	if script == nil {
+		res = r.result(filepath.Base(cmd.Path))
+	}
+	r.mu.Unlock()
+
+	if script != nil {
+		res = script(cmd)
+	}
+
+	if res.StartErr != nil {
+		return nil, res.StartErr
+	}
+
+	p := &fakeProcess{
+		runner: r,
+		result: res,
+		cmd:    cmd,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	// Callers may close their end of an *os.File stdin once the process
+	// started, as they would with a real one, so read from a duplicate.
+	// Where files cannot be duplicated stdin is left unread
+	stdin := cmd.Stdin
+	if f, ok := stdin.(*os.File); ok {
+		if dup, err := dupFile(f); err == nil {
+			p.stdin, stdin = dup, dup
+		} else {
+			stdin = nil
+		}
+	}
+	if stdin != nil && !res.IgnoreStdin {
+		go p.read(stdin)
+	}
+
+	go p.run(ctx)
+
+	return p, nil
+}
+
+// result picks the next scripted result for name. Callers hold r.mu
+func (r *FakeRunner) result(name string) FakeResult {
+	results := r.Results[name]
+	if len(results) == 0 {
+		return FakeResult{}
+	}
+	if r.next == nil {
+		r.next = map[string]int{}
+	}
+	i := r.next[name]
+	if i >= len(results) {
+		i = len(results) - 1
+	}
+	r.next[name] = i + 1
+	return results[i]
+}
+
+// fakeProcess ...
+type fakeProcess struct {
+	runner *FakeRunner
+	result FakeResult
+	cmd    Cmd
+	wake   chan struct{}
+	done   chan struct{}
+	err    error
+	stdin  io.Closer
+
+	mu       sync.Mutex
+	signals  []os.Signal
+	quit     bool
+	finished bool
+}
+
+// run replays the transcript until it ends, a signal arrives or ctx is done.
+// Signals are handled between lines whether or not lines are delayed
+func (p *fakeProcess) run(ctx context.Context) {
+	defer func() {
+		p.mu.Lock()
+		p.finished = true
+		p.mu.Unlock()
+		if p.stdin != nil {
+			p.stdin.Close()
+		}
+		close(p.done)
+	}()
+
+	if p.cmd.Stdout != nil && p.result.Stdout != "" {
+		io.WriteString(p.cmd.Stdout, p.result.Stdout)
+	}
+
+	for _, line := range splitTranscript(p.result.Stderr) {
+		if p.result.LineDelay > 0 {
+			delay := time.NewTimer(p.result.LineDelay)
+		wait:
+			for {
+				if p.interrupted(ctx) {
+					delay.Stop()
+					return
+				}
+				select {
+				case <-delay.C:
+					break wait
+				case <-p.wake:
+				case <-ctx.Done():
+				}
+			}
+		} else if p.interrupted(ctx) {
+			return
+		}
+		if p.cmd.Stderr != nil {
+			io.WriteString(p.cmd.Stderr, line)
+		}
+	}
+
+	// Signals received while the last line was written still count
+	if p.interrupted(ctx) {
+		return
+	}
+	if p.result.ExitCode != 0 {
+		p.err = &ExitError{Code: p.result.ExitCode}
+	}
+}
+
+// interrupted handles pending signals, "q" on stdin and ctx, reporting whether the
+// process must exit. A pause blocks until the process is resumed
+func (p *fakeProcess) interrupted(ctx context.Context) bool {
+	paused := false
+	for {
+		if ctx.Err() != nil {
+			p.err = &ExitError{Code: -1}
+			return true
+		}
+
+		sig, quit := p.next()
+		switch {
+		case sig == nil && !paused:
+			// ffmpeg finishes successfully once it reads "q"
+			return quit
+		case sig == nil:
+			select {
+			case <-p.wake:
+			case <-ctx.Done():
+			}
+		case sig == pauseSignal:
+			paused = true
+		case sig == resumeSignal:
+			paused = false
+		default:
+			p.err = &ExitError{Code: signalExitCode(sig)}
+			return true
+		}
+	}
+}
+
+// next pops the oldest pending signal, if any, and reports whether "q"
+// was read from stdin
+func (p *fakeProcess) next() (os.Signal, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.signals) == 0 {
+		return nil, p.quit
+	}
+	sig := p.signals[0]
+	p.signals = p.signals[1:]
+	return sig, p.quit
+}
+
+// read watches stdin for "q" until it is closed
+func (p *fakeProcess) read(stdin io.Reader) {
+	b := make([]byte, 1)
+	for {
+		if _, err := stdin.Read(b); err != nil {
+			return
+		}
+		if b[0] != 'q' {
+			continue
+		}
+
+		p.mu.Lock()
+		p.quit = true
+		p.mu.Unlock()
+		p.notify()
+		return
+	}
+}
+
+// notify wakes run up to handle a signal or quit request
+func (p *fakeProcess) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wait ...
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Signal queues sig for the process. It fails once the process has exited
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	if p.finished {
+		p.mu.Unlock()
+		return errProcessDone
+	}
+	p.signals = append(p.signals, sig)
+	p.mu.Unlock()
+
+	p.runner.mu.Lock()
+	p.runner.signals = append(p.runner.signals, sig)
+	p.runner.mu.Unlock()
+
+	p.notify()
+	return nil
+}
+
+// splitTranscript splits s after every '\n' or '\r', keeping the terminators
+func splitTranscript(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexAny(s, "\r\n")
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// signalExitCode mimics the status ffmpeg reports when interrupted
+func signalExitCode(sig os.Signal) int {
+	if sig == os.Kill {
+		return -1
+	}
+	return 255
+}
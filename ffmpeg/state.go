@@ -0,0 +1,120 @@
+package ffmpeg
+
+import (
+	"errors"
+	"time"
+)
+
+// State is the lifecycle state of a Transcoder's ffmpeg process
+type State int
+
+// Transcoder states
+const (
+	StatePending State = iota
+	StateRunning
+	StatePaused
+	StateFinishing
+	StateDone
+	StateFailed
+)
+
+var stateNames = map[State]string{
+	StatePending:   "pending",
+	StateRunning:   "running",
+	StatePaused:    "paused",
+	StateFinishing: "finishing",
+	StateDone:      "done",
+	StateFailed:    "failed",
+}
+
+// String ...
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+var (
+	errNotRunning        = errors.New("transcoder is not running")
+	errNotPaused         = errors.New("transcoder is not paused")
+	errPauseNotSupported = errors.New("pausing processes is not supported on this platform")
+)
+
+// State returns the current state of the process
+func (t *Transcoder) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Pause suspends the running ffmpeg process (SIGSTOP), freeing its CPU
+// until Resume is called. Paused time is excluded from progress speed
+// and ETA figures
+func (t *Transcoder) Pause() error {
+	if pauseSignal == nil {
+		return errPauseNotSupported
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != StateRunning {
+		return errNotRunning
+	}
+	if err := t.process.Signal(pauseSignal); err != nil {
+		return err
+	}
+
+	t.state = StatePaused
+	t.pausedAt = time.Now()
+
+	return nil
+}
+
+// Resume continues a paused ffmpeg process (SIGCONT)
+func (t *Transcoder) Resume() error {
+	if resumeSignal == nil {
+		return errPauseNotSupported
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != StatePaused {
+		return errNotPaused
+	}
+	if err := t.process.Signal(resumeSignal); err != nil {
+		return err
+	}
+
+	t.state = StateRunning
+	t.paused += time.Since(t.pausedAt)
+
+	return nil
+}
+
+// setState ...
+func (t *Transcoder) setState(s State) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s == StateRunning && t.state == StatePending {
+		t.startedAt = time.Now()
+	}
+	t.state = s
+}
+
+// activity returns how long the process has been running, excluding
+// paused time, and whether it has ever been paused
+func (t *Transcoder) activity() (elapsed time.Duration, paused bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pausedFor := t.paused
+	if t.state == StatePaused {
+		pausedFor += time.Since(t.pausedAt)
+	}
+
+	return time.Since(t.startedAt) - pausedFor, pausedFor > 0
+}
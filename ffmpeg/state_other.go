@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package ffmpeg
+
+import "os"
+
+var (
+	pauseSignal  os.Signal
+	resumeSignal os.Signal
+)
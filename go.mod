@@ -1,3 +1,5 @@
 module github.com/floostack/transcoder
 
-go 1.13
+go 1.14
+
+require gopkg.in/yaml.v3 v3.0.1
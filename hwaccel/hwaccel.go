@@ -0,0 +1,295 @@
+// Package hwaccel builds hardware accelerated transcoding pipelines and
+// falls back to software encoding when the hardware is unavailable.
+//
+// A Profile knows how one acceleration API decodes, filters and encodes:
+// which -hwaccel to use, where decoded frames live, how to scale them on the
+// device and how to move them through software filters. Transcode tries
+// profiles in order and ends with libx264 or libx265 when none works.
+package hwaccel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Codec is an output video codec
+type Codec string
+
+// Codecs
+const (
+	H264 Codec = "h264"
+	HEVC Codec = "hevc"
+)
+
+// Profile describes an acceleration API
+type Profile struct {
+	Name string
+	// Hwaccel is the -hwaccel method, as listed by ffmpeg -hwaccels. Empty
+	// for software
+	Hwaccel string
+	// Device selects the device, such as "/dev/dri/renderD128" for VAAPI or
+	// "1" for the second CUDA GPU. Empty uses the default device
+	Device string
+	// OutputFormat keeps decoded frames on the device when set
+	OutputFormat string
+	// Scale is the scaling filter working on OutputFormat frames
+	Scale    string
+	Encoders map[Codec]string
+	// Quality is the encoder option for constant quality encoding
+	Quality string
+}
+
+// Profiles
+var (
+	Software = Profile{
+		Name:     "software",
+		Scale:    "scale",
+		Encoders: map[Codec]string{H264: "libx264", HEVC: "libx265"},
+		Quality:  "-crf",
+	}
+	VAAPI = Profile{
+		Name:         "vaapi",
+		Hwaccel:      "vaapi",
+		Device:       "/dev/dri/renderD128",
+		OutputFormat: "vaapi",
+		Scale:        "scale_vaapi",
+		Encoders:     map[Codec]string{H264: "h264_vaapi", HEVC: "hevc_vaapi"},
+		Quality:      "-qp",
+	}
+	QSV = Profile{
+		Name:         "qsv",
+		Hwaccel:      "qsv",
+		OutputFormat: "qsv",
+		Scale:        "scale_qsv",
+		Encoders:     map[Codec]string{H264: "h264_qsv", HEVC: "hevc_qsv"},
+		Quality:      "-global_quality",
+	}
+	NVENC = Profile{
+		Name:         "nvenc",
+		Hwaccel:      "cuda",
+		OutputFormat: "cuda",
+		Scale:        "scale_cuda",
+		Encoders:     map[Codec]string{H264: "h264_nvenc", HEVC: "hevc_nvenc"},
+		Quality:      "-cq",
+	}
+	VideoToolbox = Profile{
+		Name:     "videotoolbox",
+		Hwaccel:  "videotoolbox",
+		Scale:    "scale",
+		Encoders: map[Codec]string{H264: "h264_videotoolbox", HEVC: "hevc_videotoolbox"},
+		Quality:  "-q:v",
+	}
+)
+
+// DefaultProfiles are tried by Transcode when Config.Profiles is empty
+var DefaultProfiles = []Profile{NVENC, QSV, VAAPI, VideoToolbox}
+
+// Target describes the output video
+type Target struct {
+	Codec Codec
+	// Width and Height scale the video when set
+	Width  int
+	Height int
+	// Bitrate such as "4M". Takes precedence over Quality
+	Bitrate string
+	// Quality is passed to the encoder's constant quality option: the CRF
+	// of software encoders, the QP of VAAPI, and so on
+	Quality int
+	// Options are the other output options, such as the audio codec. A video
+	// filter runs in software before scaling
+	Options ffmpeg.Options
+	// SourcePixFmt is the input's pixel format, picking the format frames are
+	// downloaded in for software filters. Transcode probes it when unset
+	SourcePixFmt string
+}
+
+// Pipeline holds the input and output options of a transcode
+type Pipeline struct {
+	Profile string
+	Input   ffmpeg.Options
+	Output  ffmpeg.Options
+}
+
+// Args returns the ffmpeg arguments transcoding input into output
+func (p Pipeline) Args(input, output string) []string {
+	args := append([]string{"-y"}, p.Input.GetStrArguments()...)
+	args = append(args, "-i", input)
+	args = append(args, p.Output.GetStrArguments()...)
+	return append(args, output)
+}
+
+// Supports reports whether the ffmpeg build has the profile's hwaccel and
+// an encoder for codec
+func (p Profile) Supports(caps *ffmpeg.Capabilities, codec Codec) bool {
+	if p.Hwaccel != "" && !caps.HasHwaccel(p.Hwaccel) {
+		return false
+	}
+	return caps.HasEncoder(p.Encoders[codec])
+}
+
+// Pipeline returns the options encoding t with the profile
+func (p Profile) Pipeline(t Target) Pipeline {
+	pl := Pipeline{Profile: p.Name, Output: t.Options}
+
+	if p.Hwaccel != "" {
+		hwaccel := p.Hwaccel
+		pl.Input.Hwaccel = &hwaccel
+	}
+
+	device := ""
+	if p.OutputFormat != "" {
+		// Name the device so software filters can upload back to it
+		init := p.Hwaccel + "=hw"
+		switch {
+		case p.Device != "" && p.Hwaccel == "qsv":
+			init += ":hw_any,child_device=" + p.Device
+		case p.Device != "":
+			init += ":" + p.Device
+		}
+		device = "hw"
+		format := p.OutputFormat
+		pl.Input.InitHwDevice = &init
+		pl.Input.HwaccelDevice = &device
+		pl.Input.HwaccelOutputFormat = &format
+		pl.Output.FilterHwDevice = &device
+	}
+
+	var filters string
+	if f := t.Options.VideoFilter; f != nil && *f != "" {
+		filters = *f
+		if p.OutputFormat != "" {
+			format := swFormat(t.SourcePixFmt)
+			filters = "hwdownload,format=" + format + "," + filters + ",format=" + format + ",hwupload"
+		}
+	}
+	if t.Width > 0 || t.Height > 0 {
+		filters = join(filters, fmt.Sprintf("%s=w=%d:h=%d", p.Scale, dimension(t.Width), dimension(t.Height)))
+	}
+	if filters != "" {
+		pl.Output.VideoFilter = &filters
+	} else {
+		pl.Output.VideoFilter = nil
+	}
+
+	encoder := p.Encoders[t.Codec]
+	pl.Output.VideoCodec = &encoder
+
+	switch {
+	case t.Bitrate != "":
+		bitrate := t.Bitrate
+		pl.Output.VideoBitRate = &bitrate
+	case t.Quality > 0 && p.Quality == "-crf":
+		crf := uint32(t.Quality)
+		pl.Output.Crf = &crf
+	case t.Quality > 0:
+		pl.Output.ExtraArgs = ffmpeg.WithArg(pl.Output.ExtraArgs, p.Quality, strconv.Itoa(t.Quality))
+	}
+
+	return pl
+}
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Capabilities of the ffmpeg build. Detected when nil
+	Capabilities *ffmpeg.Capabilities
+	// Profiles to try in order. Defaults to DefaultProfiles
+	Profiles []Profile
+}
+
+// Attempt is a profile that failed to initialise its device
+type Attempt struct {
+	Profile string
+	Err     error
+}
+
+// Result reports the profile that encoded the output and the profiles that
+// failed before it
+type Result struct {
+	Profile  string
+	Fallback bool
+	Failed   []Attempt
+}
+
+// Transcode encodes input into output with the first profile the ffmpeg
+// build supports whose device initialises, falling back to software.
+// Failures other than device errors are returned without trying further
+// profiles
+func Transcode(ctx context.Context, cfg Config, input, output string, t Target) (*Result, error) {
+	caps := cfg.Capabilities
+	if caps == nil {
+		var err error
+		if caps, err = ffmpeg.DetectCapabilities(ctx, cfg.FFmpeg); err != nil {
+			return nil, err
+		}
+	}
+
+	if f := t.Options.VideoFilter; f != nil && *f != "" && t.SourcePixFmt == "" {
+		metadata, err := ffmpeg.New(cfg.FFmpeg).Input(input).WithContext(&ctx).GetMetadata()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range metadata.GetStreams() {
+			if s.GetCodecType() == "video" {
+				t.SourcePixFmt = s.GetPixFmt()
+				break
+			}
+		}
+	}
+
+	profiles := make([]Profile, 0, len(cfg.Profiles)+1)
+	profiles = append(profiles, cfg.Profiles...)
+	if len(profiles) == 0 {
+		profiles = append(profiles, DefaultProfiles...)
+	}
+
+	result := &Result{}
+	for _, p := range append(profiles, Software) {
+		if p.Name != Software.Name && !p.Supports(caps, t.Codec) {
+			continue
+		}
+
+		_, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, p.Pipeline(t).Args(input, output)...)
+		if err == nil || p.Name == Software.Name || !errors.Is(err, ffmpeg.ErrHardware) {
+			result.Profile = p.Name
+			result.Fallback = p.Name == Software.Name
+			return result, err
+		}
+
+		result.Failed = append(result.Failed, Attempt{Profile: p.Name, Err: err})
+	}
+
+	return result, nil
+}
+
+// join ...
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+// swFormat is the software pixel format holding frames of pixFmt: p010le
+// for sources deeper than 8 bits, nv12 otherwise
+func swFormat(pixFmt string) string {
+	for _, depth := range []string{"p10", "p12", "p16", "p010", "p016"} {
+		if strings.Contains(pixFmt, depth) {
+			return "p010le"
+		}
+	}
+	return "nv12"
+}
+
+// dimension keeps the aspect ratio when a dimension is unset
+func dimension(d int) int {
+	if d == 0 {
+		return -2
+	}
+	return d
+}
@@ -0,0 +1,132 @@
+package hwaccel_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/hwaccel"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+var caps = &ffmpeg.Capabilities{
+	Hwaccels: map[string]bool{"vaapi": true},
+	Encoders: map[string]bool{"h264_vaapi": true, "h264_nvenc": true, "libx264": true},
+}
+
+func TestPipelines(t *testing.T) {
+	acodec, overlay := "copy", "drawtext=text=Preview"
+	target := hwaccel.Target{Codec: hwaccel.H264, Height: 720, Quality: 23, Options: ffmpeg.Options{AudioCodec: &acodec}}
+
+	tests := map[string]struct {
+		profile hwaccel.Profile
+		target  hwaccel.Target
+		want    string
+	}{
+		"vaapi": {hwaccel.VAAPI, target,
+			"-y -hwaccel vaapi -hwaccel_device hw -hwaccel_output_format vaapi -init_hw_device vaapi=hw:/dev/dri/renderD128 -i in.mkv " +
+				"-c:v h264_vaapi -c:a copy -vf scale_vaapi=w=-2:h=720 -qp 23 -filter_hw_device hw out.mp4"},
+		"nvenc with software filter": {hwaccel.NVENC, hwaccel.Target{Codec: hwaccel.HEVC, Width: 1280, Height: 720, Bitrate: "3M", Options: ffmpeg.Options{VideoFilter: &overlay}},
+			"-y -hwaccel cuda -hwaccel_device hw -hwaccel_output_format cuda -init_hw_device cuda=hw -i in.mkv " +
+				"-b:v 3M -c:v hevc_nvenc -vf hwdownload,format=nv12,drawtext=text=Preview,format=nv12,hwupload,scale_cuda=w=1280:h=720 -filter_hw_device hw out.mp4"},
+		"qsv with 10-bit source": {hwaccel.QSV, hwaccel.Target{Codec: hwaccel.HEVC, SourcePixFmt: "yuv420p10le", Options: ffmpeg.Options{VideoFilter: &overlay}},
+			"-y -hwaccel qsv -hwaccel_device hw -hwaccel_output_format qsv -init_hw_device qsv=hw -i in.mkv " +
+				"-c:v hevc_qsv -vf hwdownload,format=p010le,drawtext=text=Preview,format=p010le,hwupload -filter_hw_device hw out.mp4"},
+		"videotoolbox": {hwaccel.VideoToolbox, hwaccel.Target{Codec: hwaccel.H264, Options: ffmpeg.Options{VideoFilter: &overlay}},
+			"-y -hwaccel videotoolbox -i in.mkv -c:v h264_videotoolbox -vf drawtext=text=Preview out.mp4"},
+		"software": {hwaccel.Software, target,
+			"-y -i in.mkv -c:v libx264 -c:a copy -crf 23 -vf scale=w=-2:h=720 out.mp4"},
+	}
+
+	for name, tt := range tests {
+		got := strings.Join(tt.profile.Pipeline(tt.target).Args("in.mkv", "out.mp4"), " ")
+		if got != tt.want {
+			t.Errorf("%s:\n%s\nwant\n%s", name, got, tt.want)
+		}
+	}
+}
+
+func TestTranscodeFallsBackWhenDeviceInitFails(t *testing.T) {
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if strings.Contains(strings.Join(cmd.Args, " "), "h264_vaapi") {
+			return ffmpeg.FakeResult{
+				Stderr:   "[AVHWDeviceContext @ 0x1] Failed to initialise VAAPI connection: -1 (unknown libva error).\nDevice creation failed: -5.\n",
+				ExitCode: 1,
+			}
+		}
+		return ffmpeg.FakeResult{}
+	}}
+
+	result, err := hwaccel.Transcode(context.Background(), hwaccel.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		Capabilities: caps,
+	}, "in.mkv", "out.mp4", hwaccel.Target{Codec: hwaccel.H264, Quality: 23})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NVENC lacks the cuda hwaccel and is skipped without running ffmpeg
+	if calls := runner.Calls(); len(calls) != 2 {
+		t.Fatalf("ran ffmpeg %d times, want vaapi then software", len(calls))
+	}
+	if result.Profile != "software" || !result.Fallback {
+		t.Errorf("result = %+v", result)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Profile != "vaapi" || !errors.Is(result.Failed[0].Err, ffmpeg.ErrHardware) {
+		t.Errorf("failed = %+v", result.Failed)
+	}
+}
+
+func TestTranscodeReturnsOtherErrors(t *testing.T) {
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		return ffmpeg.FakeResult{Stderr: "in.mkv: No such file or directory\n", ExitCode: 1}
+	}}
+
+	result, err := hwaccel.Transcode(context.Background(), hwaccel.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		Capabilities: caps,
+		Profiles:     []hwaccel.Profile{hwaccel.VAAPI},
+	}, "in.mkv", "out.mp4", hwaccel.Target{Codec: hwaccel.H264})
+
+	if !errors.Is(err, ffmpeg.ErrInvalidInput) {
+		t.Errorf("err = %v", err)
+	}
+	if result.Profile != "vaapi" || len(runner.Calls()) != 1 {
+		t.Errorf("result = %+v after %d runs", result, len(runner.Calls()))
+	}
+}
+
+func TestTranscodeProbesFilteredSources(t *testing.T) {
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if cmd.Path == "ffprobe" {
+			source := transcodertest.VideoStream("hevc", 3840, 2160)
+			source.PixFmt = "yuv420p10le"
+			return ffmpeg.FakeResult{Stdout: transcodertest.Probe(time.Minute, source)}
+		}
+		return ffmpeg.FakeResult{}
+	}}
+
+	profiles := make([]hwaccel.Profile, 1, 2)
+	profiles[0] = hwaccel.VAAPI
+	overlay := "drawtext=text=Preview"
+	_, err := hwaccel.Transcode(context.Background(), hwaccel.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Capabilities: caps,
+		Profiles:     profiles,
+	}, "in.mkv", "out.mp4", hwaccel.Target{Codec: hwaccel.H264, Options: ffmpeg.Options{VideoFilter: &overlay}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := runner.Calls()
+	if len(calls) != 2 || !strings.Contains(strings.Join(calls[1].Args, " "), "hwdownload,format=p010le,") {
+		t.Errorf("calls = %+v", calls)
+	}
+	// The software fallback is not appended into the caller's slice
+	if extra := profiles[:2]; extra[1].Name != "" {
+		t.Errorf("Transcode wrote %q into Config.Profiles", extra[1].Name)
+	}
+}
@@ -0,0 +1,93 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a spec file format
+type Format string
+
+// Formats
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// FormatOf returns the format of a spec file from its extension
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("unknown job spec format of %s", path)
+}
+
+// Parse decodes a spec. Unknown fields are rejected, so misspelt options
+// do not go unnoticed. The spec is not validated
+func Parse(data []byte, format Format) (Spec, error) {
+	var spec Spec
+
+	switch format {
+	case JSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		// Keeps extra_args numbers as written
+		dec.UseNumber()
+		if err := dec.Decode(&spec); err != nil {
+			return Spec{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	case YAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&spec); err != nil {
+			return Spec{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	default:
+		return Spec{}, fmt.Errorf("unknown job spec format %q", format)
+	}
+
+	return spec, nil
+}
+
+// Load reads, parses and validates a spec file
+func Load(path string, presets Presets) (Spec, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return Spec{}, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Spec{}, err
+	}
+
+	spec, err := Parse(data, format)
+	if err != nil {
+		return Spec{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := spec.Validate(presets); err != nil {
+		return Spec{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return spec, nil
+}
+
+// Marshal encodes a spec
+func Marshal(spec Spec, format Format) ([]byte, error) {
+	switch format {
+	case JSON:
+		return json.MarshalIndent(spec, "", "  ")
+	case YAML:
+		return yaml.Marshal(spec)
+	}
+	return nil, fmt.Errorf("unknown job spec format %q", format)
+}
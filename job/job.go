@@ -0,0 +1,343 @@
+// Package job describes transcoding jobs declaratively, so they can live in
+// JSON or YAML files.
+//
+// A Spec lists inputs, an optional filtergraph and outputs. Each output
+// names a preset, defined in the spec or looked up elsewhere, and overrides
+// its options:
+//
+//	presets:
+//	  web:
+//	    options: {video_codec: libx264, crf: 23, mov_flags: +faststart}
+//	inputs:
+//	  - path: in.mov
+//	outputs:
+//	  - path: out.mp4
+//	    preset: web
+//	    options: {crf: 20}
+package job
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/queue"
+)
+
+// ErrInvalid is wrapped by the errors of specs failing validation
+var ErrInvalid = errors.New("invalid job spec")
+
+// Spec is a transcoding job
+type Spec struct {
+	// Presets defined by the spec, taking precedence over external ones
+	Presets map[string]Preset `json:"presets,omitempty" yaml:"presets,omitempty"`
+	Inputs  []Input           `json:"inputs" yaml:"inputs"`
+	// FilterComplex is a filtergraph over all inputs whose labelled outputs
+	// can be mapped, e.g. "[0:v]scale=1280:-2[small]"
+	FilterComplex string   `json:"filter_complex,omitempty" yaml:"filter_complex,omitempty"`
+	Outputs       []Output `json:"outputs" yaml:"outputs"`
+}
+
+// Input ...
+type Input struct {
+	Path    string          `json:"path" yaml:"path"`
+	Options *ffmpeg.Options `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// Output ...
+type Output struct {
+	Path string `json:"path" yaml:"path"`
+	// Preset names the preset Options override
+	Preset  string          `json:"preset,omitempty" yaml:"preset,omitempty"`
+	Options *ffmpeg.Options `json:"options,omitempty" yaml:"options,omitempty"`
+	// VideoFilters and AudioFilters are chained after the preset's filters
+	VideoFilters []string `json:"video_filters,omitempty" yaml:"video_filters,omitempty"`
+	AudioFilters []string `json:"audio_filters,omitempty" yaml:"audio_filters,omitempty"`
+	// Maps select the streams of the output: input streams such as "0:v:0"
+	// or filtergraph outputs such as "[small]"
+	Maps []string `json:"maps,omitempty" yaml:"maps,omitempty"`
+}
+
+// Preset is a named set of options. Extends names a preset it overrides
+type Preset struct {
+	Extends string         `json:"extends,omitempty" yaml:"extends,omitempty"`
+	Options ffmpeg.Options `json:"options" yaml:"options"`
+}
+
+// Presets looks up presets a spec does not define itself
+type Presets interface {
+	Lookup(name string) (ffmpeg.Options, bool)
+}
+
+var (
+	trailingLabelRe = regexp.MustCompile(`\[([^\[\]]+)\]\s*$`)
+	streamMapRe     = regexp.MustCompile(`^-?(\d+)(:.*)?\??$`)
+)
+
+// Validate checks the spec's structure, preset references and stream maps.
+// presets may be nil
+func (s Spec) Validate(presets Presets) error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Inputs) == 0 {
+		problem("no inputs")
+	}
+	if len(s.Outputs) == 0 {
+		problem("no outputs")
+	}
+
+	for i, in := range s.Inputs {
+		if in.Path == "" {
+			problem("inputs[%d]: path is empty", i)
+		}
+	}
+
+	for name := range s.Presets {
+		if _, err := s.preset(name, presets, nil); err != nil {
+			problem("presets.%s: %v", name, err)
+		}
+	}
+
+	labels := outputLabels(s.FilterComplex)
+
+	for i, out := range s.Outputs {
+		if out.Path == "" {
+			problem("outputs[%d]: path is empty", i)
+		}
+		if out.Preset != "" {
+			if _, err := s.preset(out.Preset, presets, nil); err != nil {
+				problem("outputs[%d].preset: %v", i, err)
+			}
+		}
+		for _, f := range append(append([]string{}, out.VideoFilters...), out.AudioFilters...) {
+			if strings.TrimSpace(f) == "" {
+				problem("outputs[%d]: empty filter", i)
+			}
+		}
+		for _, m := range out.Maps {
+			if strings.HasPrefix(m, "[") {
+				if !strings.HasSuffix(m, "]") || !labels[m[1:len(m)-1]] {
+					problem("outputs[%d].maps: %s is not a filter_complex output", i, m)
+				}
+				continue
+			}
+			sm := streamMapRe.FindStringSubmatch(m)
+			if sm == nil {
+				problem("outputs[%d].maps: invalid stream specifier %q", i, m)
+				continue
+			}
+			if n, _ := strconv.Atoi(sm[1]); n >= len(s.Inputs) {
+				problem("outputs[%d].maps: %s refers to missing input %d", i, m, n)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// outputLabels returns the labels ending the chains of a filtergraph
+func outputLabels(graph string) map[string]bool {
+	labels := map[string]bool{}
+	for _, chain := range strings.Split(graph, ";") {
+		for {
+			m := trailingLabelRe.FindStringSubmatchIndex(chain)
+			if m == nil {
+				break
+			}
+			labels[chain[m[2]:m[3]]] = true
+			chain = chain[:m[0]]
+		}
+	}
+	return labels
+}
+
+// preset resolves a preset and the presets it extends, base first
+func (s Spec) preset(name string, presets Presets, path []string) (ffmpeg.Options, error) {
+	for i, extended := range path {
+		if extended == name {
+			return ffmpeg.Options{}, fmt.Errorf("preset cycle %s", strings.Join(append(path[i:], name), " -> "))
+		}
+	}
+
+	p, ok := s.Presets[name]
+	if !ok {
+		if presets != nil {
+			if opts, ok := presets.Lookup(name); ok {
+				return opts, nil
+			}
+		}
+		return ffmpeg.Options{}, fmt.Errorf("unknown preset %q", name)
+	}
+
+	if p.Extends == "" {
+		return p.Options, nil
+	}
+
+	base, err := s.preset(p.Extends, presets, append(path[:len(path):len(path)], name))
+	if err != nil {
+		return ffmpeg.Options{}, err
+	}
+	return base.Merge(p.Options), nil
+}
+
+// OutputOptions returns the options of output i: its preset overridden by
+// its own options, with its filters chained on
+func (s Spec) OutputOptions(i int, presets Presets) (ffmpeg.Options, error) {
+	out := s.Outputs[i]
+
+	var opts ffmpeg.Options
+	if out.Preset != "" {
+		var err error
+		if opts, err = s.preset(out.Preset, presets, nil); err != nil {
+			return ffmpeg.Options{}, err
+		}
+	}
+	if out.Options != nil {
+		opts = opts.Merge(*out.Options)
+	}
+
+	opts.VideoFilter = chain(opts.VideoFilter, out.VideoFilters)
+	opts.AudioFilter = chain(opts.AudioFilter, out.AudioFilters)
+
+	return opts, nil
+}
+
+// chain appends filters to a filter chain
+func chain(base *string, filters []string) *string {
+	if len(filters) == 0 {
+		return base
+	}
+	all := filters
+	if base != nil && *base != "" {
+		all = append([]string{*base}, filters...)
+	}
+	joined := strings.Join(all, ",")
+	return &joined
+}
+
+// inputArgs returns the arguments of every input but the last, and the
+// last input's options. The transcoder takes a single input, so earlier ones
+// ride along in its input options, keeping their indexes
+func (s Spec) inputArgs() (ffmpeg.RawArgs, ffmpeg.Options) {
+	var args ffmpeg.RawArgs
+	for _, in := range s.Inputs[:len(s.Inputs)-1] {
+		if in.Options != nil {
+			args = append(args, in.Options.GetStrArguments()...)
+		}
+		args = append(args, "-i", in.Path)
+	}
+	var last ffmpeg.Options
+	if opts := s.Inputs[len(s.Inputs)-1].Options; opts != nil {
+		last = *opts
+	}
+	return args, last
+}
+
+// mapArgs returns the filtergraph and stream maps preceding the options of
+// output i. The filtergraph goes with the first output
+func (s Spec) mapArgs(i int) []string {
+	var args []string
+	if i == 0 && s.FilterComplex != "" {
+		args = append(args, "-filter_complex", s.FilterComplex)
+	}
+	for _, m := range s.Outputs[i].Maps {
+		args = append(args, "-map", m)
+	}
+	return args
+}
+
+// outputArgs returns the arguments of every output, without its path
+func (s Spec) outputArgs(presets Presets) ([]ffmpeg.RawArgs, error) {
+	var outputs []ffmpeg.RawArgs
+	for i := range s.Outputs {
+		opts, err := s.OutputOptions(i, presets)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, append(ffmpeg.RawArgs(s.mapArgs(i)), opts.GetStrArguments()...))
+	}
+	return outputs, nil
+}
+
+// Args returns the ffmpeg arguments of the job
+func (s Spec) Args(presets Presets) ([]string, error) {
+	if err := s.Validate(presets); err != nil {
+		return nil, err
+	}
+
+	outputs, err := s.outputArgs(presets)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := s.inputArgs()
+	args := append([]string{}, first...)
+	args = append(args, last.GetStrArguments()...)
+	args = append(args, "-i", s.Inputs[len(s.Inputs)-1].Path)
+	for i, out := range outputs {
+		args = append(args, out...)
+		args = append(args, s.Outputs[i].Path)
+	}
+
+	return args, nil
+}
+
+// Transcoder returns a transcoder running the job. Progress is reported
+// against the duration of the last input
+func (s Spec) Transcoder(cfg *ffmpeg.Config, presets Presets) (transcoder.Transcoder, error) {
+	if err := s.Validate(presets); err != nil {
+		return nil, err
+	}
+
+	outputs, err := s.outputArgs(presets)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := s.inputArgs()
+	t := ffmpeg.New(cfg).
+		Input(s.Inputs[len(s.Inputs)-1].Path).
+		WithInputOptions(append(first, last.GetStrArguments()...))
+	for i, out := range s.Outputs {
+		t = t.Output(out.Path).WithAdditionalOutputOptions(outputs[i])
+	}
+
+	return t, nil
+}
+
+// QueueSpec returns the job as a queue.Spec, to run it on a worker pool.
+// Output options stay structured so the queue can weigh them
+func (s Spec) QueueSpec(presets Presets) (queue.Spec, error) {
+	if err := s.Validate(presets); err != nil {
+		return queue.Spec{}, err
+	}
+
+	first, last := s.inputArgs()
+	qs := queue.Spec{
+		Input:        s.Inputs[len(s.Inputs)-1].Path,
+		InputOptions: &last,
+		InputArgs:    first,
+	}
+
+	for i, out := range s.Outputs {
+		opts, err := s.OutputOptions(i, presets)
+		if err != nil {
+			return queue.Spec{}, err
+		}
+
+		qs.Outputs = append(qs.Outputs, queue.Output{Path: out.Path, Options: opts, Args: s.mapArgs(i)})
+	}
+
+	return qs, nil
+}
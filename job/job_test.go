@@ -0,0 +1,248 @@
+package job_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/job"
+)
+
+const specYAML = `
+presets:
+  web:
+    options:
+      video_codec: libx264
+      preset: medium
+      crf: 23
+      audio_codec: aac
+      mov_flags: +faststart
+  web-small:
+    extends: web
+    options:
+      crf: 26
+      video_filter: scale=-2:480
+inputs:
+  - path: main.mov
+    options:
+      seek_time: "10"
+  - path: logo.png
+filter_complex: "[0:v][1:v]overlay=10:10[branded]"
+outputs:
+  - path: out.mp4
+    preset: web
+    maps: ["[branded]", "0:a:0"]
+    options:
+      crf: 20
+      metadata: {title: Trailer}
+  - path: small.mp4
+    preset: web-small
+    maps: ["0:v:0", "0:a?"]
+    video_filters: [fps=25]
+  - path: audio.m4a
+    preset: podcast
+    maps: ["0:a:0"]
+`
+
+// presets stands in for an external preset registry
+type presets map[string]ffmpeg.Options
+
+func (p presets) Lookup(name string) (ffmpeg.Options, bool) {
+	opts, ok := p[name]
+	return opts, ok
+}
+
+func external() presets {
+	aac, bitrate, yes := "aac", "96k", true
+	return presets{"podcast": {AudioCodec: &aac, AudioBitrate: &bitrate, SkipVideo: &yes}}
+}
+
+func TestArgs(t *testing.T) {
+	spec, err := job.Parse([]byte(specYAML), job.YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := spec.Args(external())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "-ss 10 -i main.mov -i logo.png " +
+		"-filter_complex [0:v][1:v]overlay=10:10[branded] -map [branded] -map 0:a:0 -c:v libx264 -c:a aac -preset medium -crf 20 -movflags +faststart -metadata title=Trailer out.mp4 " +
+		"-map 0:v:0 -map 0:a? -c:v libx264 -c:a aac -preset medium -crf 26 -movflags +faststart -vf scale=-2:480,fps=25 small.mp4 " +
+		"-map 0:a:0 -c:a aac -ab 96k -vn audio.m4a"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+
+	tr, err := spec.Transcoder(&ffmpeg.Config{FfmpegBinPath: "ffmpeg"}, external())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := tr.(transcoder.Commander).Command()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(strings.Join(cmd, " "), want) {
+		t.Errorf("transcoder command =\n%s\nwant suffix\n%s", strings.Join(cmd, " "), want)
+	}
+}
+
+func TestQueueSpec(t *testing.T) {
+	spec, err := job.Parse([]byte(specYAML), job.YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qs, err := spec.QueueSpec(external())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if qs.Input != "logo.png" || strings.Join(qs.InputArgs, " ") != "-ss 10 -i main.mov" {
+		t.Errorf("input %s, input args %v", qs.Input, qs.InputArgs)
+	}
+	if len(qs.Outputs) != 3 {
+		t.Fatalf("%d outputs", len(qs.Outputs))
+	}
+	if got := strings.Join(qs.Outputs[0].Args, " "); got != "-filter_complex [0:v][1:v]overlay=10:10[branded] -map [branded] -map 0:a:0" {
+		t.Errorf("first output args %s", got)
+	}
+	if out := qs.Outputs[2]; out.Path != "audio.m4a" || out.Options.SkipVideo == nil || strings.Join(out.Args, " ") != "-map 0:a:0" {
+		t.Errorf("audio output %+v", out)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	spec, err := job.Parse([]byte(specYAML), job.YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []job.Format{job.JSON, job.YAML} {
+		data, err := job.Marshal(spec, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := job.Parse(data, format)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, data)
+		}
+		if !reflect.DeepEqual(back, spec) {
+			t.Errorf("%s round trip changed the spec:\n%s", format, data)
+		}
+	}
+
+	data, _ := job.Marshal(spec, job.JSON)
+	for _, field := range []string{`"video_codec": "libx264"`, `"crf": 23`, `"filter_complex"`, `"extends": "web"`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("JSON lacks %s", field)
+		}
+	}
+	if strings.Contains(string(data), `"options": {}`) {
+		t.Errorf("JSON has empty options:\n%s", data)
+	}
+}
+
+func TestParseBoolFlags(t *testing.T) {
+	spec, err := job.Parse([]byte(`{"inputs":[{"path":"in.mov"}],"outputs":[{"path":"out.mp4","options":{"skip_video":false,"skip_audio":true,"extra_args":{"-rc_init_occupancy":17825792}}}]}`), job.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []job.Format{job.JSON, job.YAML} {
+		data, err := job.Marshal(spec, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := job.Parse(data, format)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, data)
+		}
+		args, err := back.Args(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(args, " "); got != "-i in.mov -an -rc_init_occupancy 17825792 out.mp4" {
+			t.Errorf("%s: args %s", format, got)
+		}
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	if _, err := job.Parse([]byte(`{"inputs":[{"path":"a"}],"outputs":[{"path":"b","options":{"crff":20}}]}`), job.JSON); !errors.Is(err, job.ErrInvalid) {
+		t.Errorf("json: err = %v", err)
+	}
+	if _, err := job.Parse([]byte("inputs: [{path: a}]\noutputs: [{path: b, preset_name: web}]\n"), job.YAML); !errors.Is(err, job.ErrInvalid) {
+		t.Errorf("yaml: err = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	spec := job.Spec{
+		Presets: map[string]job.Preset{
+			"a": {Extends: "b"},
+			"b": {Extends: "a"},
+		},
+		Inputs:        []job.Input{{Path: "in.mov"}},
+		FilterComplex: "[0:v]split[x][y]",
+		Outputs: []job.Output{
+			{Path: "", Preset: "missing"},
+			{Path: "out.mp4", Maps: []string{"[x]", "[0:v]", "1:v", "v"}},
+		},
+	}
+
+	err := spec.Validate(nil)
+	if !errors.Is(err, job.ErrInvalid) {
+		t.Fatalf("err = %v", err)
+	}
+	for _, problem := range []string{
+		"presets.a: preset cycle a -> b -> a",
+		"outputs[0]: path is empty",
+		"outputs[0].preset: unknown preset \"missing\"",
+		"outputs[1].maps: [0:v] is not a filter_complex output",
+		"outputs[1].maps: 1:v refers to missing input 1",
+		"outputs[1].maps: invalid stream specifier \"v\"",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error lacks %q:\n%v", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "[x]") {
+		t.Errorf("valid map reported: %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	data, err := job.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Required    []string `json:"required"`
+		Definitions map[string]struct {
+			Required   []string                          `json:"required"`
+			Properties map[string]map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(schema.Required, []string{"inputs", "outputs"}) {
+		t.Errorf("required = %v", schema.Required)
+	}
+	crf := schema.Definitions["Options"].Properties["crf"]
+	if crf["type"] != "integer" || crf["description"] != "ffmpeg -crf" {
+		t.Errorf("crf = %v", crf)
+	}
+	if out := schema.Definitions["Output"]; !reflect.DeepEqual(out.Required, []string{"path"}) || out.Properties["options"]["$ref"] != "#/definitions/Options" {
+		t.Errorf("Output = %+v", out)
+	}
+}
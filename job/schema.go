@@ -0,0 +1,100 @@
+package job
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Schema returns a JSON Schema (draft-07) describing spec files, for editor
+// completion and validation outside Go. Option properties are described by
+// the ffmpeg flag they set
+func Schema() ([]byte, error) {
+	g := &schemaGenerator{definitions: map[string]interface{}{}}
+
+	root := g.object(reflect.TypeOf(Spec{}))
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "Transcoding job"
+	root["definitions"] = g.definitions
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// schemaGenerator collects the definitions of named struct types
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+// schema returns the schema of t
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint64, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float64, reflect.Float32:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.definitions[name]; !ok {
+			// Placeholder first, in case the type refers to itself
+			g.definitions[name] = nil
+			g.definitions[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	}
+	// interface{} accepts anything
+	return map[string]interface{}{}
+}
+
+// object returns the schema of struct t's JSON fields
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.PkgPath != "" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := g.schema(f.Type)
+		if flag := f.Tag.Get("flag"); flag != "" {
+			s["description"] = "ffmpeg " + flag
+		}
+		properties[name] = s
+
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	object := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
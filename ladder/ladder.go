@@ -0,0 +1,443 @@
+// Package ladder builds per-title encoding ladders.
+//
+// Instead of a fixed table of resolutions and bitrates, Optimize encodes
+// short samples of the title at several resolutions and quality points,
+// measures each encode against the source and keeps the renditions on the
+// convex hull of bitrate versus quality: the ones no other resolution beats
+// at that bitrate.
+package ladder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/quality"
+)
+
+// Resolution is a candidate rendition size. Renditions are scaled to its
+// height and keep the source's aspect ratio, so Width only matters to
+// sources of the same shape
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+// DefaultResolutions are tried when Config.Resolutions is empty. Only those
+// no larger than the source are encoded
+var DefaultResolutions = []Resolution{
+	{3840, 2160},
+	{2560, 1440},
+	{1920, 1080},
+	{1280, 720},
+	{960, 540},
+	{768, 432},
+	{640, 360},
+	{416, 234},
+}
+
+// DefaultCRFs are the quality points tried when neither CRFs nor Bitrates
+// are configured
+var DefaultCRFs = []uint32{18, 22, 26, 30, 34, 38}
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Options are the base encoding options of every rendition, such as the
+	// video codec and preset. Resolution and rate control are set per
+	// rendition
+	Options ffmpeg.Options
+	// Resolutions to try. Defaults to DefaultResolutions
+	Resolutions []Resolution
+	// CRFs to try at every resolution. Defaults to DefaultCRFs
+	CRFs []uint32
+	// Bitrates, in bits per second, to try at every resolution instead of
+	// CRFs. Renditions then use bitrate rate control
+	Bitrates []int
+	// Samples is how many evenly spread samples are encoded. Defaults to 3
+	Samples int
+	// SampleDuration in seconds. Defaults to 10
+	SampleDuration float64
+	// Metric scores the encodes. Defaults to VMAF, or PSNR when the ffmpeg
+	// build lacks libvmaf
+	Metric quality.Metric
+	// Capabilities of the ffmpeg build. Detected when nil
+	Capabilities *ffmpeg.Capabilities
+	// MaxRenditions caps the ladder. Defaults to 6
+	MaxRenditions int
+	// MinStep is the minimum bitrate ratio between neighbouring renditions.
+	// Defaults to 1.5
+	MinStep float64
+	// WorkDir holds the samples and their encodes. Defaults to the system
+	// temporary directory
+	WorkDir string
+}
+
+// Point is one encode of the samples at a CRF or target bitrate, with the
+// bitrate in bits per second and quality measured over the samples. Width
+// and Height are the size of the encodes
+type Point struct {
+	Width   int
+	Height  int
+	CRF     uint32
+	Target  int
+	Bitrate int
+	Quality float64
+}
+
+// Rendition is a rung of the ladder and the options to encode it
+type Rendition struct {
+	Point
+	Options ffmpeg.Options
+}
+
+// Result ...
+type Result struct {
+	Metric quality.Metric
+	// Points are all measured encodes
+	Points []Point
+	// Hull are the points on the convex hull, by increasing bitrate
+	Hull []Point
+	// Renditions are the selected rungs, by increasing bitrate
+	Renditions []Rendition
+}
+
+// sample is a losslessly encoded excerpt of the source
+type sample struct {
+	path     string
+	duration float64
+}
+
+// Optimize measures input and returns its ladder
+func Optimize(ctx context.Context, cfg Config, input string) (*Result, error) {
+	caps := cfg.Capabilities
+	if caps == nil {
+		var err error
+		if caps, err = ffmpeg.DetectCapabilities(ctx, cfg.FFmpeg); err != nil {
+			return nil, err
+		}
+	}
+
+	metric := cfg.Metric
+	if metric == "" {
+		metric = quality.VMAF
+		if !caps.HasFilter("libvmaf") {
+			metric = quality.PSNR
+		}
+	}
+	if metric == quality.VMAF && !caps.HasFilter("libvmaf") {
+		return nil, errors.New("ffmpeg was built without libvmaf")
+	}
+
+	metadata, err := ffmpeg.New(cfg.FFmpeg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	height := 0
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			height = s.GetHeight()
+			break
+		}
+	}
+	if height == 0 {
+		return nil, errors.New("input has no video stream")
+	}
+
+	duration, err := strconv.ParseFloat(metadata.GetFormat().GetDuration(), 64)
+	if err != nil || duration <= 0 {
+		return nil, errors.New("input duration unknown")
+	}
+
+	resolutions := candidates(cfg.Resolutions, height)
+	if len(resolutions) == 0 {
+		return nil, errors.New("no resolution fits the input")
+	}
+
+	dir, err := ioutil.TempDir(cfg.WorkDir, "ladder")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	samples, err := extract(ctx, cfg, dir, input, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Metric: metric}
+
+	for _, res := range resolutions {
+		for _, p := range points(cfg) {
+			p.Width, p.Height = res.Width, res.Height
+
+			if err := measure(ctx, cfg, caps, metric, dir, samples, &p); err != nil {
+				return nil, err
+			}
+			result.Points = append(result.Points, p)
+		}
+	}
+
+	result.Hull = Hull(result.Points)
+
+	for _, p := range selectRungs(result.Hull, cfg.maxRenditions(), cfg.minStep()) {
+		result.Renditions = append(result.Renditions, Rendition{Point: p, Options: cfg.options(p)})
+	}
+
+	return result, nil
+}
+
+// Hull returns the points on the upper convex hull of bitrate versus
+// quality, by increasing bitrate. Points that cost more bits than another
+// without improving quality are dropped first
+func Hull(points []Point) []Point {
+	sorted := append([]Point(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Bitrate != sorted[j].Bitrate {
+			return sorted[i].Bitrate < sorted[j].Bitrate
+		}
+		return sorted[i].Quality > sorted[j].Quality
+	})
+
+	var hull []Point
+	for _, p := range sorted {
+		if len(hull) > 0 && p.Quality <= hull[len(hull)-1].Quality {
+			continue
+		}
+		for len(hull) >= 2 && !above(hull[len(hull)-2], hull[len(hull)-1], p) {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	return hull
+}
+
+// above reports whether b lies strictly above the line from a to c
+func above(a, b, c Point) bool {
+	cross := float64(b.Bitrate-a.Bitrate)*(c.Quality-a.Quality) - (b.Quality-a.Quality)*float64(c.Bitrate-a.Bitrate)
+	return cross < 0
+}
+
+// selectRungs picks up to max hull points, from the highest quality down,
+// at least step apart in bitrate
+func selectRungs(hull []Point, max int, step float64) []Point {
+	var rungs []Point
+	for i := len(hull) - 1; i >= 0 && len(rungs) < max; i-- {
+		if len(rungs) > 0 && float64(hull[i].Bitrate)*step > float64(rungs[0].Bitrate) {
+			continue
+		}
+		rungs = append([]Point{hull[i]}, rungs...)
+	}
+	return rungs
+}
+
+// candidates returns the resolutions no taller than the source
+func candidates(resolutions []Resolution, height int) []Resolution {
+	if len(resolutions) == 0 {
+		resolutions = DefaultResolutions
+	}
+
+	var fit []Resolution
+	for _, r := range resolutions {
+		if r.Height <= height {
+			fit = append(fit, r)
+		}
+	}
+	return fit
+}
+
+// points returns the rate control points to try at every resolution
+func points(cfg Config) []Point {
+	var ps []Point
+	if len(cfg.Bitrates) > 0 {
+		for _, b := range cfg.Bitrates {
+			ps = append(ps, Point{Target: b})
+		}
+		return ps
+	}
+
+	crfs := cfg.CRFs
+	if len(crfs) == 0 {
+		crfs = DefaultCRFs
+	}
+	for _, c := range crfs {
+		ps = append(ps, Point{CRF: c})
+	}
+	return ps
+}
+
+// extract cuts evenly spread samples out of input, encoded losslessly so
+// they serve as the reference
+func extract(ctx context.Context, cfg Config, dir, input string, duration float64) ([]sample, error) {
+	n := cfg.Samples
+	if n <= 0 {
+		n = 3
+	}
+	length := cfg.SampleDuration
+	if length <= 0 {
+		length = 10
+	}
+	if length*float64(n) >= duration {
+		n, length = 1, duration
+	}
+
+	var samples []sample
+	for i := 0; i < n; i++ {
+		start := math.Max(0, math.Min(duration*(float64(i)+0.5)/float64(n)-length/2, duration-length))
+		path := filepath.Join(dir, fmt.Sprintf("sample%d.mkv", i))
+
+		_, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, "-y", "-ss", ffmpeg.FormatSeconds(start), "-i", input,
+			"-t", ffmpeg.FormatSeconds(length), "-map", "0:v:0", "-an", "-sn", "-c:v", "ffv1", path)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, sample{path: path, duration: length})
+	}
+
+	return samples, nil
+}
+
+// measure encodes every sample at p and fills in its average bitrate and
+// quality
+func measure(ctx context.Context, cfg Config, caps *ffmpeg.Capabilities, metric quality.Metric, dir string, samples []sample, p *Point) error {
+	opts := cfg.options(*p)
+	yes := true
+	opts.SkipAudio = &yes
+	opts.Overwrite = &yes
+
+	var bits, score float64
+	for i, s := range samples {
+		output := filepath.Join(dir, fmt.Sprintf("sample%d_%dp_%s.mkv", i, p.Height, p.rate()))
+
+		args := append([]string{"-i", s.path}, opts.GetStrArguments()...)
+		if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, append(args, output)...); err != nil {
+			return err
+		}
+
+		// The width follows the source's aspect ratio, after any base filter
+		if i == 0 {
+			if err := encodedSize(ctx, cfg, output, p); err != nil {
+				return err
+			}
+		}
+
+		info, err := os.Stat(output)
+		if err != nil {
+			return err
+		}
+		bits += float64(info.Size()*8) / s.duration
+
+		q, err := quality.Compare(ctx, quality.Config{FFmpeg: cfg.FFmpeg, Capabilities: caps, WorkDir: dir}, s.path, output, metric)
+		if err != nil {
+			return err
+		}
+		score += value(q, metric)
+
+		os.Remove(output)
+	}
+
+	n := float64(len(samples))
+	p.Bitrate = int(bits / n)
+	p.Quality = score / n
+
+	return nil
+}
+
+// encodedSize sets the size of p to the video size of output
+func encodedSize(ctx context.Context, cfg Config, output string, p *Point) error {
+	metadata, err := ffmpeg.New(cfg.FFmpeg).Input(output).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return err
+	}
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			p.Width, p.Height = s.GetWidth(), s.GetHeight()
+			return nil
+		}
+	}
+	return fmt.Errorf("encode %s has no video stream", output)
+}
+
+// value is the aggregate score of metric in r
+func value(r *quality.Result, metric quality.Metric) float64 {
+	switch {
+	case metric == quality.VMAF && r.VMAF != nil:
+		return r.VMAF.Mean
+	case metric == quality.SSIM && r.SSIM != nil:
+		return r.SSIM.All
+	case metric == quality.PSNR && r.PSNR != nil:
+		return r.PSNR.Average
+	}
+	return 0
+}
+
+// options returns the encoding options of p. The base options are scaled to
+// its height, with an even width keeping the aspect ratio. Points with a
+// target bitrate use bitrate rate control. Others use CRF, capped at 1.5 times
+// the measured bitrate
+func (cfg Config) options(p Point) ffmpeg.Options {
+	opts := cfg.Options
+
+	filter := fmt.Sprintf("scale=-2:%d", p.Height)
+	if opts.VideoFilter != nil && *opts.VideoFilter != "" {
+		filter = *opts.VideoFilter + "," + filter
+	}
+	opts.VideoFilter = &filter
+
+	if p.Target > 0 {
+		rate := strconv.Itoa(p.Target)
+		maxrate := p.Target * 3 / 2
+		bufsize := p.Target * 2
+		opts.VideoBitRate = &rate
+		opts.VideoMaxBitRate = &maxrate
+		opts.BufferSize = &bufsize
+		opts.Crf = nil
+		return opts
+	}
+
+	crf := p.CRF
+	opts.Crf = &crf
+	opts.VideoBitRate = nil
+	if p.Bitrate > 0 {
+		maxrate := p.Bitrate * 3 / 2
+		bufsize := maxrate * 2
+		opts.VideoMaxBitRate = &maxrate
+		opts.BufferSize = &bufsize
+	}
+
+	return opts
+}
+
+// rate names the rate control point of p
+func (p Point) rate() string {
+	if p.Target > 0 {
+		return fmt.Sprintf("%dbps", p.Target)
+	}
+	return fmt.Sprintf("crf%d", p.CRF)
+}
+
+// maxRenditions ...
+func (cfg Config) maxRenditions() int {
+	if cfg.MaxRenditions <= 0 {
+		return 6
+	}
+	return cfg.MaxRenditions
+}
+
+// minStep ...
+func (cfg Config) minStep() float64 {
+	if cfg.MinStep <= 1 {
+		return 1.5
+	}
+	return cfg.MinStep
+}
@@ -0,0 +1,174 @@
+package ladder_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/ladder"
+	"github.com/floostack/transcoder/quality"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+// encodes maps "height crf" to the bitrate, in kbit/s, and PSNR of the fake
+// encodes
+var encodes = map[string][2]float64{
+	"1080 22": {4000, 46},
+	"1080 30": {1600, 40},
+	"720 22":  {2000, 43},
+	"720 30":  {800, 38},
+	"360 22":  {600, 36},
+	"360 30":  {250, 32},
+}
+
+var statsRe = regexp.MustCompile(`stats_file=([^:;\[ ]+)`)
+
+// runner fakes a width x 1080 source. Encodes are probed with the width
+// scale=-2 gives them
+func runner(t *testing.T, width int) *ffmpeg.FakeRunner {
+	probe := transcodertest.Probe(time.Minute, transcodertest.VideoStream("h264", width, 1080))
+
+	return &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if filepath.Base(cmd.Path) == "ffprobe" {
+			var i, h int
+			if n, _ := fmt.Sscanf(filepath.Base(cmd.Args[1]), "sample%d_%dp", &i, &h); n == 2 {
+				w := (h*width + 1080) / 2160 * 2
+				return ffmpeg.FakeResult{Stdout: transcodertest.Probe(10*time.Second, transcodertest.VideoStream("h264", w, h))}
+			}
+			return ffmpeg.FakeResult{Stdout: probe}
+		}
+
+		args := strings.Join(cmd.Args, " ")
+		output := cmd.Args[len(cmd.Args)-1]
+
+		switch {
+		case strings.Contains(args, "-filter_complex"):
+			// Quality comparison of the encode, the first input
+			var i, h, crf int
+			fmt.Sscanf(filepath.Base(cmd.Args[3]), "sample%d_%dp_crf%d.mkv", &i, &h, &crf)
+			e := encodes[fmt.Sprintf("%d %d", h, crf)]
+			for _, m := range statsRe.FindAllStringSubmatch(args, -1) {
+				touch(t, m[1], 0)
+			}
+			return ffmpeg.FakeResult{Stderr: fmt.Sprintf("PSNR y:%[1]g u:%[1]g v:%[1]g average:%[1]g min:%[1]g max:%[1]g\n", e[1])}
+		case strings.Contains(args, "ffv1"):
+			touch(t, output, 0)
+		default:
+			var i, h, crf int
+			fmt.Sscanf(filepath.Base(output), "sample%d_%dp_crf%d.mkv", &i, &h, &crf)
+			e := encodes[fmt.Sprintf("%d %d", h, crf)]
+			touch(t, output, int64(e[0]*1000*10/8))
+		}
+		return ffmpeg.FakeResult{}
+	}}
+}
+
+// touch creates a sparse file of size bytes
+func touch(t *testing.T, path string, size int64) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	r := runner(t, 1920)
+	codec := "libx264"
+
+	result, err := ladder.Optimize(context.Background(), ladder.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: r},
+		Options:      ffmpeg.Options{VideoCodec: &codec},
+		Capabilities: &ffmpeg.Capabilities{},
+		Resolutions:  []ladder.Resolution{{3840, 2160}, {1920, 1080}, {1280, 720}, {640, 360}},
+		CRFs:         []uint32{22, 30},
+	}, "title.mov")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Metric != quality.PSNR {
+		t.Errorf("metric = %s, want psnr without libvmaf", result.Metric)
+	}
+	if len(result.Points) != 6 {
+		t.Fatalf("points = %+v, want 3 resolutions x 2 CRFs", result.Points)
+	}
+
+	var hull []int
+	for _, p := range result.Hull {
+		hull = append(hull, p.Bitrate/1000)
+	}
+	if want := []int{250, 600, 800, 2000, 4000}; !reflect.DeepEqual(hull, want) {
+		t.Errorf("hull = %v, want %v", hull, want)
+	}
+
+	var rungs []string
+	for _, r := range result.Renditions {
+		rungs = append(rungs, strings.Join(r.Options.GetStrArguments(), " "))
+	}
+	want := []string{
+		"-maxrate 375000 -c:v libx264 -bufsize 750000 -crf 30 -vf scale=-2:360",
+		"-maxrate 1200000 -c:v libx264 -bufsize 2400000 -crf 30 -vf scale=-2:720",
+		"-maxrate 3000000 -c:v libx264 -bufsize 6000000 -crf 22 -vf scale=-2:720",
+		"-maxrate 6000000 -c:v libx264 -bufsize 12000000 -crf 22 -vf scale=-2:1080",
+	}
+	if !reflect.DeepEqual(rungs, want) {
+		t.Errorf("renditions =\n%s\nwant\n%s", strings.Join(rungs, "\n"), strings.Join(want, "\n"))
+	}
+
+	samples := 0
+	for _, c := range r.Calls() {
+		if strings.Contains(strings.Join(c.Args, " "), "ffv1") {
+			samples++
+		}
+	}
+	if samples != 3 {
+		t.Errorf("extracted %d samples, want 3", samples)
+	}
+}
+
+func TestOptimizeKeepsAspectRatio(t *testing.T) {
+	result, err := ladder.Optimize(context.Background(), ladder.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner(t, 1440)},
+		Capabilities: &ffmpeg.Capabilities{},
+		Resolutions:  []ladder.Resolution{{1920, 1080}, {1280, 720}, {640, 360}},
+		CRFs:         []uint32{22},
+		Samples:      1,
+	}, "title.mov")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []string
+	for _, p := range result.Points {
+		sizes = append(sizes, fmt.Sprintf("%dx%d", p.Width, p.Height))
+	}
+	if want := []string{"1440x1080", "960x720", "480x360"}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("sizes = %v, want %v", sizes, want)
+	}
+}
+
+func TestHullDropsDominatedPoints(t *testing.T) {
+	hull := ladder.Hull([]ladder.Point{
+		{Bitrate: 1000, Quality: 80},
+		{Bitrate: 1500, Quality: 78},
+		{Bitrate: 2000, Quality: 90},
+		{Bitrate: 2000, Quality: 85},
+		{Bitrate: 3000, Quality: 91},
+	})
+
+	want := []ladder.Point{{Bitrate: 1000, Quality: 80}, {Bitrate: 2000, Quality: 90}, {Bitrate: 3000, Quality: 91}}
+	if !reflect.DeepEqual(hull, want) {
+		t.Errorf("hull = %+v, want %+v", hull, want)
+	}
+}
@@ -0,0 +1,288 @@
+// Package loudness measures and normalizes audio loudness following
+// EBU R128 / ITU-R BS.1770.
+//
+// Normalize runs two passes: the loudnorm filter first measures the input,
+// then a second pass applies linear normalization using the measured values,
+// which avoids the pumping of loudnorm's single-pass dynamic mode.
+package loudness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// ErrNotMeasurable is returned when loudnorm reports infinite loudness, as
+// it does for silent inputs
+var ErrNotMeasurable = errors.New("loudness not measurable, the input may be silent")
+
+// Target is a loudness normalization target
+type Target struct {
+	// Integrated loudness in LUFS
+	Integrated float64
+	// TruePeak is the maximum true peak in dBTP
+	TruePeak float64
+	// LRA is the loudness range in LU
+	LRA float64
+}
+
+// Common targets
+var (
+	// EBU is the EBU R128 broadcast target. R128 does not limit the loudness
+	// range, so a generous LRA keeps most programmes linearly normalized
+	EBU = Target{Integrated: -23, TruePeak: -1, LRA: 18}
+	// Podcast suits spoken word on streaming platforms
+	Podcast = Target{Integrated: -16, TruePeak: -1.5, LRA: 11}
+	// Music suits music streaming services
+	Music = Target{Integrated: -14, TruePeak: -1, LRA: 11}
+)
+
+// Measurement holds the loudness of an input
+type Measurement struct {
+	// Integrated loudness in LUFS
+	Integrated float64
+	// TruePeak in dBTP
+	TruePeak float64
+	// LRA is the loudness range in LU
+	LRA float64
+	// Threshold is the relative gating threshold in LUFS
+	Threshold float64
+	// TargetOffset is the gain loudnorm applies after normalization, in LU
+	TargetOffset float64
+}
+
+// Result describes a normalized output
+type Result struct {
+	Input  Measurement
+	Output Measurement
+	// Linear is false when loudnorm had to fall back to dynamic
+	// normalization, because the input's range or peak did not allow
+	// reaching the target linearly
+	Linear bool
+}
+
+// loudnormStats is the JSON block printed by loudnorm
+type loudnormStats struct {
+	InputI            string `json:"input_i"`
+	InputTP           string `json:"input_tp"`
+	InputLRA          string `json:"input_lra"`
+	InputThresh       string `json:"input_thresh"`
+	OutputI           string `json:"output_i"`
+	OutputTP          string `json:"output_tp"`
+	OutputLRA         string `json:"output_lra"`
+	OutputThresh      string `json:"output_thresh"`
+	NormalizationType string `json:"normalization_type"`
+	TargetOffset      string `json:"target_offset"`
+}
+
+// Measure runs loudnorm in analysis mode over input's audio
+func Measure(ctx context.Context, cfg *ffmpeg.Config, input string, target Target) (*Measurement, error) {
+	return measure(ctx, cfg, input, target, "")
+}
+
+// measure runs loudnorm in analysis mode after the filter chain pre, so the
+// audio measured is the audio normalized
+func measure(ctx context.Context, cfg *ffmpeg.Config, input string, target Target, pre string) (*Measurement, error) {
+	filter := target.filter() + ":print_format=json"
+	if pre != "" {
+		filter = pre + "," + filter
+	}
+
+	_, stderr, err := ffmpeg.Exec(ctx, cfg,
+		"-hide_banner", "-nostats", "-i", input, "-vn",
+		"-af", filter,
+		"-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := parseLoudnorm(stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := stats.input()
+	for _, v := range []float64{m.Integrated, m.TruePeak, m.LRA, m.Threshold, m.TargetOffset} {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("%w: integrated %s, true peak %s", ErrNotMeasurable, stats.InputI, stats.InputTP)
+		}
+	}
+	return &m, nil
+}
+
+// Normalize measures input and writes output normalized to target. opts
+// are applied to the output; an AudioFilter in opts runs before loudnorm in
+// both passes.
+// Unless opts set an audio rate, 48 kHz is used instead of the 192 kHz
+// loudnorm resamples to
+func Normalize(ctx context.Context, cfg *ffmpeg.Config, input, output string, target Target, opts ffmpeg.Options) (*Result, error) {
+	pre := ""
+	if opts.AudioFilter != nil {
+		pre = *opts.AudioFilter
+	}
+	measured, err := measure(ctx, cfg, input, target, pre)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=json",
+		target.filter(), num(measured.Integrated), num(measured.TruePeak), num(measured.LRA),
+		num(measured.Threshold), num(measured.TargetOffset))
+	if pre != "" {
+		filter = pre + "," + filter
+	}
+	opts.AudioFilter = &filter
+
+	if opts.AudioRate == nil {
+		rate := 48000
+		opts.AudioRate = &rate
+	}
+
+	args := append([]string{"-hide_banner", "-nostats", "-y", "-i", input}, opts.GetStrArguments()...)
+	args = append(args, output)
+
+	_, stderr, err := ffmpeg.Exec(ctx, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := parseLoudnorm(stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Input:  *measured,
+		Output: stats.output(),
+		Linear: strings.EqualFold(stats.NormalizationType, "linear"),
+	}, nil
+}
+
+// filter returns the loudnorm filter for the target
+func (t Target) filter() string {
+	return fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", num(t.Integrated), num(t.TruePeak), num(t.LRA))
+}
+
+// input ...
+func (s loudnormStats) input() Measurement {
+	return Measurement{
+		Integrated:   parseNum(s.InputI),
+		TruePeak:     parseNum(s.InputTP),
+		LRA:          parseNum(s.InputLRA),
+		Threshold:    parseNum(s.InputThresh),
+		TargetOffset: parseNum(s.TargetOffset),
+	}
+}
+
+// output ...
+func (s loudnormStats) output() Measurement {
+	return Measurement{
+		Integrated:   parseNum(s.OutputI),
+		TruePeak:     parseNum(s.OutputTP),
+		LRA:          parseNum(s.OutputLRA),
+		Threshold:    parseNum(s.OutputThresh),
+		TargetOffset: parseNum(s.TargetOffset),
+	}
+}
+
+// parseLoudnorm extracts the JSON statistics loudnorm prints when it ends
+func parseLoudnorm(stderr string) (*loudnormStats, error) {
+	i := strings.LastIndex(stderr, "Parsed_loudnorm")
+	if i < 0 {
+		return nil, errors.New("no loudnorm statistics in ffmpeg output")
+	}
+	block := stderr[i:]
+
+	start, end := strings.Index(block, "{"), strings.LastIndex(block, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("no loudnorm statistics in ffmpeg output")
+	}
+
+	var stats loudnormStats
+	if err := json.Unmarshal([]byte(block[start:end+1]), &stats); err != nil {
+		return nil, fmt.Errorf("invalid loudnorm statistics: %v", err)
+	}
+
+	return &stats, nil
+}
+
+// EBUR128 holds the summary of the ebur128 filter
+type EBUR128 struct {
+	Integrated float64
+	Threshold  float64
+	LRA        float64
+	LRALow     float64
+	LRAHigh    float64
+	TruePeak   float64
+}
+
+// ebur128Summary matches the value lines of the ebur128 summary
+var ebur128Summary = regexp.MustCompile(`(?m)^\s*(I|Threshold|LRA|LRA low|LRA high|Peak):\s+(-?[\d.]+|-inf)`)
+
+// Analyze runs the ebur128 filter over input's audio and returns its summary.
+// Unlike Measure it does not depend on a target
+func Analyze(ctx context.Context, cfg *ffmpeg.Config, input string) (*EBUR128, error) {
+	_, stderr, err := ffmpeg.Exec(ctx, cfg,
+		"-hide_banner", "-nostats", "-i", input, "-vn",
+		"-af", "ebur128=peak=true",
+		"-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseEBUR128(stderr)
+}
+
+// parseEBUR128 reads the Summary section printed when ebur128 ends
+func parseEBUR128(stderr string) (*EBUR128, error) {
+	i := strings.LastIndex(stderr, "Summary:")
+	if i < 0 {
+		return nil, errors.New("no ebur128 summary in ffmpeg output")
+	}
+
+	var r EBUR128
+	thresholds := 0
+
+	for _, m := range ebur128Summary.FindAllStringSubmatch(stderr[i:], -1) {
+		v := parseNum(m[2])
+		switch m[1] {
+		case "I":
+			r.Integrated = v
+		case "Threshold":
+			// The integrated loudness threshold comes first, then the
+			// loudness range one
+			if thresholds == 0 {
+				r.Threshold = v
+			}
+			thresholds++
+		case "LRA":
+			r.LRA = v
+		case "LRA low":
+			r.LRALow = v
+		case "LRA high":
+			r.LRAHigh = v
+		case "Peak":
+			r.TruePeak = v
+		}
+	}
+
+	return &r, nil
+}
+
+// num formats a filter option value
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// parseNum parses loudnorm and ebur128 values, including "-inf"
+func parseNum(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v
+}
@@ -0,0 +1,131 @@
+package loudness_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/loudness"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+const firstPass = `Input #0, wav, from 'episode.wav':
+  Duration: 00:42:10.00, bitrate: 1536 kb/s
+[Parsed_loudnorm_0 @ 0x55d1c2a3e4c0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "8.30",
+	"input_thresh" : "-38.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "6.10",
+	"output_thresh" : "-27.04",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`
+
+const secondPass = `[Parsed_loudnorm_0 @ 0x55d1c2a3e4c0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "8.30",
+	"input_thresh" : "-38.20",
+	"output_i" : "-16.02",
+	"output_tp" : "-1.52",
+	"output_lra" : "8.10",
+	"output_thresh" : "-26.50",
+	"normalization_type" : "linear",
+	"target_offset" : "0.02"
+}
+`
+
+func TestNormalize(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{Stderr: firstPass}, transcodertest.Script{})
+	runner.Results["ffmpeg"] = append(runner.Results["ffmpeg"], ffmpeg.FakeResult{Stderr: secondPass})
+
+	codec := "aac"
+	result, err := loudness.Normalize(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		"episode.wav", "episode.m4a", loudness.Podcast, ffmpeg.Options{AudioCodec: &codec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Input.Integrated != -27.61 || result.Input.TruePeak != -4.47 || result.Input.LRA != 8.3 || result.Input.Threshold != -38.2 {
+		t.Errorf("unexpected input measurement %+v", result.Input)
+	}
+	if result.Output.Integrated != -16.02 || !result.Linear {
+		t.Errorf("unexpected output %+v (linear %v)", result.Output, result.Linear)
+	}
+
+	calls := runner.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("ran %d passes, want 2", len(calls))
+	}
+	if args := strings.Join(calls[0].Args, " "); !strings.Contains(args, "-af loudnorm=I=-16:TP=-1.5:LRA=11:print_format=json -f null -") {
+		t.Errorf("unexpected analysis pass %s", args)
+	}
+	want := "-ar 48000 -c:a aac -af loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=8.3:measured_thresh=-38.2:offset=0.58:linear=true:print_format=json episode.m4a"
+	if args := strings.Join(calls[1].Args, " "); !strings.HasSuffix(args, want) {
+		t.Errorf("normalization pass = %s\nwant suffix %s", args, want)
+	}
+}
+
+func TestNormalizeFilteredAudio(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{Stderr: firstPass}, transcodertest.Script{})
+	runner.Results["ffmpeg"] = append(runner.Results["ffmpeg"], ffmpeg.FakeResult{Stderr: secondPass})
+
+	filter := "highpass=f=80,pan=mono|c0=FL"
+	if _, err := loudness.Normalize(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		"episode.wav", "episode.m4a", loudness.Podcast, ffmpeg.Options{AudioFilter: &filter}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, call := range runner.Calls() {
+		if args := strings.Join(call.Args, " "); !strings.Contains(args, "-af "+filter+",loudnorm=") {
+			t.Errorf("pass %d does not filter before loudnorm: %s", i+1, args)
+		}
+	}
+}
+
+func TestMeasureSilence(t *testing.T) {
+	silent := strings.NewReplacer(`"-27.61"`, `"-inf"`, `"-4.47"`, `"-inf"`, `"-38.20"`, `"-inf"`).Replace(firstPass)
+	runner := transcodertest.Runner(transcodertest.Script{Stderr: silent}, transcodertest.Script{})
+
+	_, err := loudness.Measure(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		"silence.wav", loudness.EBU)
+	if !errors.Is(err, loudness.ErrNotMeasurable) {
+		t.Errorf("got %v, want ErrNotMeasurable", err)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{Stderr: `[Parsed_ebur128_0 @ 0x5566] Summary:
+
+  Integrated loudness:
+    I:         -19.6 LUFS
+    Threshold: -30.0 LUFS
+
+  Loudness range:
+    LRA:         6.6 LU
+    Threshold: -40.0 LUFS
+    LRA low:   -24.3 LUFS
+    LRA high:  -17.7 LUFS
+
+  True peak:
+    Peak:       -0.8 dBFS
+`}, transcodertest.Script{})
+
+	r, err := loudness.Analyze(context.Background(), &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner}, "mix.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := loudness.EBUR128{Integrated: -19.6, Threshold: -30, LRA: 6.6, LRALow: -24.3, LRAHigh: -17.7, TruePeak: -0.8}
+	if *r != want {
+		t.Errorf("got %+v, want %+v", *r, want)
+	}
+}
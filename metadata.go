@@ -53,11 +53,29 @@ type Streams interface {
 	GetBitRate() string
 }
 
+// AudioStreams is implemented by streams describing their audio parameters
+type AudioStreams interface {
+	GetSampleRate() string
+	GetChannels() int
+	GetChannelLayout() string
+}
+
+// TaggedStreams is implemented by streams exposing their tags
+type TaggedStreams interface {
+	GetTags() Tags
+}
+
 // Tags ...
 type Tags interface {
 	GetEncoder() string
 }
 
+// Labels is implemented by tags carrying a language and title
+type Labels interface {
+	GetLanguage() string
+	GetTitle() string
+}
+
 // Disposition ...
 type Disposition interface {
 	GetDefault() int
@@ -0,0 +1,243 @@
+// Package metadata edits container metadata without re-encoding: global,
+// stream and chapter tags, chapter lists and cover art.
+package metadata
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Chapter ...
+type Chapter struct {
+	Start time.Duration
+	// End defaults to the next chapter's start, or the end of the file for
+	// the last chapter
+	End   time.Duration
+	Title string
+	Tags  map[string]string
+}
+
+// Edit describes the metadata changes of a remux
+type Edit struct {
+	// Global tags, such as "title" or "artist". An empty value removes a tag
+	Global map[string]string
+	// Streams holds tags keyed by stream specifier, such as "a:0"
+	Streams map[string]map[string]string
+	// ChapterTags holds tags of the input's chapters, keyed by chapter index
+	ChapterTags map[int]map[string]string
+	// Chapters replace the input's chapters when set
+	Chapters []Chapter
+	// Strip drops the input's global and stream tags before applying the
+	// edit
+	Strip bool
+	// StripChapters drops the input's chapters. Ignored when Chapters is set
+	StripChapters bool
+	// CoverArt is a JPEG or PNG image attached as cover art
+	CoverArt string
+}
+
+// Remux stream copies input into output with edit applied
+func Remux(ctx context.Context, cfg *ffmpeg.Config, input, output string, edit Edit) error {
+	metadata, err := ffmpeg.New(cfg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return err
+	}
+
+	videos := 0
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			videos++
+		}
+	}
+
+	var chapterFile string
+	if len(edit.Chapters) > 0 {
+		duration, _ := strconv.ParseFloat(metadata.GetFormat().GetDuration(), 64)
+
+		f, err := ioutil.TempFile("", "chapters*.txt")
+		if err != nil {
+			return err
+		}
+		chapterFile = f.Name()
+		defer os.Remove(chapterFile)
+
+		err = WriteFFMetadata(f, nil, fill(edit.Chapters, time.Duration(duration*float64(time.Second))))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, _, err = ffmpeg.Exec(ctx, cfg, Args(input, output, edit, chapterFile, videos)...)
+	return err
+}
+
+// Args returns the ffmpeg arguments of a remux applying edit. chapterFile
+// is the FFMETADATA file holding edit.Chapters and videos the number of
+// video streams in input, after which the cover art is placed
+func Args(input, output string, edit Edit, chapterFile string, videos int) []string {
+	args := []string{"-i", input}
+
+	next := 1
+	chapters := -1
+	if chapterFile != "" {
+		args = append(args, "-f", "ffmetadata", "-i", chapterFile)
+		chapters = next
+		next++
+	}
+	if edit.CoverArt != "" {
+		args = append(args, "-i", edit.CoverArt)
+	}
+
+	args = append(args, "-map", "0")
+	if edit.CoverArt != "" {
+		args = append(args, "-map", strconv.Itoa(next)+":v:0")
+	}
+	args = append(args, "-c", "copy")
+
+	yes := true
+	opts := ffmpeg.Options{Metadata: edit.Global, Overwrite: &yes}
+
+	if edit.Strip {
+		strip := "-1"
+		opts.MapMetadata = &strip
+	}
+	if chapterFile != "" || edit.StripChapters {
+		opts.MapChapters = &chapters
+	}
+
+	if len(edit.Streams) > 0 || len(edit.ChapterTags) > 0 {
+		opts.StreamMetadata = map[string]map[string]string{}
+		for spec, tags := range edit.Streams {
+			opts.StreamMetadata["s:"+spec] = tags
+		}
+		for i, tags := range edit.ChapterTags {
+			opts.StreamMetadata["c:"+strconv.Itoa(i)] = tags
+		}
+	}
+
+	if edit.CoverArt != "" {
+		opts.Disposition = map[string]string{"v:" + strconv.Itoa(videos): "attached_pic"}
+	}
+
+	args = append(args, opts.GetStrArguments()...)
+	return append(args, output)
+}
+
+// fill sets missing chapter ends from the following chapter or duration
+func fill(chapters []Chapter, duration time.Duration) []Chapter {
+	filled := append([]Chapter(nil), chapters...)
+	sort.SliceStable(filled, func(i, j int) bool { return filled[i].Start < filled[j].Start })
+
+	for i := range filled {
+		if filled[i].End > filled[i].Start {
+			continue
+		}
+		if i+1 < len(filled) {
+			filled[i].End = filled[i+1].Start
+		} else {
+			filled[i].End = duration
+		}
+	}
+
+	return filled
+}
+
+// WriteFFMetadata writes global tags and chapters in ffmpeg's FFMETADATA
+// format, with chapter times in milliseconds
+func WriteFFMetadata(w io.Writer, global map[string]string, chapters []Chapter) error {
+	b := bufio.NewWriter(w)
+
+	b.WriteString(";FFMETADATA1\n")
+	writeTags(b, global)
+
+	for i, c := range chapters {
+		if c.End <= c.Start {
+			return fmt.Errorf("chapter %d ends before it starts", i)
+		}
+
+		b.WriteString("\n[CHAPTER]\nTIMEBASE=1/1000\n")
+		fmt.Fprintf(b, "START=%d\nEND=%d\n", c.Start/time.Millisecond, c.End/time.Millisecond)
+
+		tags := map[string]string{}
+		for k, v := range c.Tags {
+			tags[k] = v
+		}
+		if c.Title != "" {
+			tags["title"] = c.Title
+		}
+		writeTags(b, tags)
+	}
+
+	return b.Flush()
+}
+
+// ffmetadataEscaper escapes the characters FFMETADATA keys and values
+// reserve
+var ffmetadataEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, `;`, `\;`, `#`, `\#`, "\n", "\\\n")
+
+// writeTags writes tags sorted by key
+func writeTags(b *bufio.Writer, tags map[string]string) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s=%s\n", ffmetadataEscaper.Replace(k), ffmetadataEscaper.Replace(tags[k]))
+	}
+}
+
+// probeChapters is the -show_chapters output of ffprobe
+type probeChapters struct {
+	Chapters []struct {
+		StartTime string            `json:"start_time"`
+		EndTime   string            `json:"end_time"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"chapters"`
+}
+
+// Chapters reads the chapters of input
+func Chapters(ctx context.Context, cfg *ffmpeg.Config, input string) ([]Chapter, error) {
+	out, _, err := ffmpeg.ExecProbe(ctx, cfg, "-v", "error", "-print_format", "json", "-show_chapters", input)
+	if err != nil {
+		return nil, err
+	}
+
+	var probed probeChapters
+	if err := json.Unmarshal([]byte(out), &probed); err != nil {
+		return nil, err
+	}
+
+	var chapters []Chapter
+	for _, c := range probed.Chapters {
+		chapter := Chapter{Start: seconds(c.StartTime), End: seconds(c.EndTime), Tags: c.Tags}
+		if title, ok := c.Tags["title"]; ok {
+			chapter.Title = title
+			delete(chapter.Tags, "title")
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	return chapters, nil
+}
+
+// seconds parses a time in seconds
+func seconds(s string) time.Duration {
+	v, _ := strconv.ParseFloat(s, 64)
+	return time.Duration(v * float64(time.Second))
+}
@@ -0,0 +1,103 @@
+package metadata_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/metadata"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func TestWriteFFMetadata(t *testing.T) {
+	var b bytes.Buffer
+	err := metadata.WriteFFMetadata(&b, map[string]string{"title": "Q&A; part=1"}, []metadata.Chapter{
+		{Start: 0, End: 90 * time.Second, Title: "Intro"},
+		{Start: 90 * time.Second, End: 95500 * time.Millisecond, Title: "#2", Tags: map[string]string{"artist": "Host"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `;FFMETADATA1
+title=Q&A\; part\=1
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=0
+END=90000
+title=Intro
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=90000
+END=95500
+artist=Host
+title=\#2
+`
+	if b.String() != want {
+		t.Errorf("ffmetadata =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	if err := metadata.WriteFFMetadata(&b, nil, []metadata.Chapter{{Start: time.Second}}); err == nil {
+		t.Error("chapter without end accepted")
+	}
+}
+
+func TestRemux(t *testing.T) {
+	probe := transcodertest.Probe(2*time.Minute, transcodertest.VideoStream("h264", 1280, 720), transcodertest.AudioStream("aac"))
+
+	var chapters string
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if strings.HasSuffix(cmd.Path, "ffprobe") {
+			return ffmpeg.FakeResult{Stdout: probe}
+		}
+		data, err := ioutil.ReadFile(cmd.Args[5])
+		if err != nil {
+			t.Error(err)
+		}
+		chapters = string(data)
+		return ffmpeg.FakeResult{}
+	}}
+	cfg := &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}
+
+	err := metadata.Remux(context.Background(), cfg, "in.mp4", "out.mp4", metadata.Edit{
+		Global:   map[string]string{"title": "Episode 1", "comment": ""},
+		Streams:  map[string]map[string]string{"a:0": {"language": "eng"}},
+		Chapters: []metadata.Chapter{{Title: "Start"}, {Start: time.Minute, Title: "Middle"}},
+		Strip:    true,
+		CoverArt: "cover.jpg",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := runner.Calls()[1].Args
+	got := strings.Join(append(args[:5:5], args[6:]...), " ")
+	want := "-i in.mp4 -f ffmetadata -i -i cover.jpg -map 0 -map 2:v:0 -c copy -map_metadata -1 -map_chapters 1 " +
+		"-metadata comment= -metadata title=Episode 1 -y -metadata:s:a:0 language=eng -disposition:v:1 attached_pic out.mp4"
+	if got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+
+	if !strings.Contains(chapters, "START=0\nEND=60000\ntitle=Start") || !strings.Contains(chapters, "START=60000\nEND=120000\ntitle=Middle") {
+		t.Errorf("chapters =\n%s", chapters)
+	}
+}
+
+func TestChapters(t *testing.T) {
+	out := `{"chapters":[{"id":0,"time_base":"1/1000","start":0,"start_time":"0.000000","end":61500,"end_time":"61.500000","tags":{"title":"One"}}]}`
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{Stdout: out})
+
+	chapters, err := metadata.Chapters(context.Background(), &ffmpeg.Config{FfprobeBinPath: "ffprobe", Runner: runner}, "in.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 1 || chapters[0].Title != "One" || chapters[0].End != 61500*time.Millisecond || len(chapters[0].Tags) != 0 {
+		t.Errorf("chapters = %+v", chapters)
+	}
+}
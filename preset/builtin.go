@@ -0,0 +1,233 @@
+package preset
+
+import (
+	"fmt"
+
+	"github.com/floostack/transcoder/codec"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// hlsRendition is a rung of the H.264 ladder of Apple's HLS authoring
+// specification
+type hlsRendition struct {
+	// width is the specification's width for 16:9 sources. Renditions scale
+	// to height and keep the source's aspect ratio
+	width, height int
+	// bitrate in kbit/s
+	bitrate int
+}
+
+var hlsRenditions = []hlsRendition{
+	{416, 234, 145},
+	{640, 360, 365},
+	{768, 432, 1100},
+	{960, 540, 2000},
+	{1280, 720, 4500},
+	{1920, 1080, 7800},
+}
+
+// builtin returns the built-in presets, bases before the presets extending
+// them
+func builtin() []Preset {
+	yes := true
+	faststart := "+faststart"
+
+	presets := []Preset{
+		{
+			Name:        "web-h264",
+			Description: "H.264/AAC MP4 for progressive download, moov atom first",
+			Options: with(ffmpeg.Options{
+				PixFmt:       str("yuv420p"),
+				MovFlags:     &faststart,
+				OutputFormat: str("mp4"),
+			},
+				codec.X264{Preset: codec.Medium, Profile: "high", Level: "4.1", CRF: codec.Int(23)},
+				codec.AAC{Bitrate: "128k", AudioFormat: codec.AudioFormat{Channels: 2}},
+			),
+		},
+		{
+			Name:        "youtube",
+			Description: "YouTube recommended upload settings for 1080p SDR: H.264 high profile, 2 B-frames, AAC-LC 384k",
+			Options: with(ffmpeg.Options{
+				PixFmt:       str("yuv420p"),
+				MovFlags:     &faststart,
+				OutputFormat: str("mp4"),
+			},
+				codec.X264{
+					Preset:      codec.Slow,
+					Profile:     "high",
+					RateControl: codec.RateControl{Bitrate: "8M"},
+					Bframes:     codec.Int(2),
+				},
+				codec.AAC{Bitrate: "384k", AudioFormat: codec.AudioFormat{Channels: 2, SampleRate: 48000}},
+			),
+		},
+		{
+			Name:        "youtube-4k",
+			Description: "YouTube recommended upload settings for 2160p SDR",
+			Extends:     "youtube",
+			Options: ffmpeg.Options{
+				VideoBitRate: str("45M"),
+			},
+		},
+		{
+			Name:        "hls",
+			Description: "HLS authoring specification base: H.264 high profile, 2 second keyframes, 6 second VOD segments",
+			Options: with(ffmpeg.Options{
+				PixFmt:             str("yuv420p"),
+				HlsSegmentDuration: integer(6),
+				HlsPlaylistType:    str("vod"),
+				OutputFormat:       str("hls"),
+				ExtraArgs: map[string]interface{}{
+					"-force_key_frames": "expr:gte(t,n_forced*2)",
+					"-sc_threshold":     0,
+				},
+			},
+				codec.X264{Preset: codec.Slow, Profile: "high", Level: "4.2"},
+				codec.AAC{Bitrate: "128k", AudioFormat: codec.AudioFormat{Channels: 2, SampleRate: 48000}},
+			),
+		},
+		{
+			Name:        "xdcam-hd422",
+			Description: "Sony XDCAM HD422 MXF: 1080i MPEG-2 4:2:2 at 50 Mbit/s CBR, 24-bit PCM",
+			Options: ffmpeg.Options{
+				VideoCodec:       str("mpeg2video"),
+				VideoProfile:     str("0"),
+				PixFmt:           str("yuv422p"),
+				VideoFilter:      str("scale=1920:1080:interl=1"),
+				VideoBitRate:     str("50M"),
+				VideoMinBitrate:  integer(50000000),
+				VideoMaxBitRate:  integer(50000000),
+				BufferSize:       integer(17825792),
+				KeyframeInterval: integer(12),
+				Bframe:           integer(2),
+				AudioCodec:       str("pcm_s24le"),
+				AudioRate:        integer(48000),
+				OutputFormat:     str("mxf"),
+				ExtraArgs: map[string]interface{}{
+					"-flags":             "+ildct+ilme",
+					"-top":               1,
+					"-rc_init_occupancy": 17825792,
+					"-intra_vlc":         1,
+					"-non_linear_quant":  1,
+					"-dc":                10,
+					"-sc_threshold":      1000000000,
+					"-level:v":           2,
+				},
+			},
+		},
+		{
+			Name:        "imx50",
+			Description: "IMX/D-10 MXF: 625-line intra-only MPEG-2 4:2:2 at 50 Mbit/s, 16-bit PCM",
+			Options: ffmpeg.Options{
+				VideoCodec:       str("mpeg2video"),
+				PixFmt:           str("yuv422p"),
+				VideoFilter:      str("scale=720:576:interl=1,pad=720:608:0:32"),
+				VideoBitRate:     str("50M"),
+				VideoMinBitrate:  integer(50000000),
+				VideoMaxBitRate:  integer(50000000),
+				BufferSize:       integer(2000000),
+				KeyframeInterval: integer(1),
+				AudioCodec:       str("pcm_s16le"),
+				AudioRate:        integer(48000),
+				OutputFormat:     str("mxf_d10"),
+				ExtraArgs: map[string]interface{}{
+					"-flags":             "+ildct+low_delay",
+					"-top":               1,
+					"-rc_init_occupancy": 2000000,
+					"-intra_vlc":         1,
+					"-non_linear_quant":  1,
+					"-dc":                10,
+					"-ps":                1,
+					"-qmin":              1,
+					"-qmax":              3,
+				},
+			},
+		},
+		{
+			Name:        "prores-proxy",
+			Description: "ProRes 422 Proxy MOV with PCM audio, for offline editing",
+			Options: with(ffmpeg.Options{
+				AudioCodec:   str("pcm_s16le"),
+				OutputFormat: str("mov"),
+			},
+				codec.ProRes{Profile: codec.ProResProxy, Vendor: "apl0"},
+			),
+		},
+		{
+			Name:        "podcast-mp3",
+			Description: "Audio-only podcast MP3: 128 kbit/s stereo at 44.1 kHz",
+			Options: ffmpeg.Options{
+				SkipVideo:     &yes,
+				AudioCodec:    str("libmp3lame"),
+				AudioBitrate:  str("128k"),
+				AudioChannels: integer(2),
+				AudioRate:     integer(44100),
+				OutputFormat:  str("mp3"),
+			},
+		},
+		{
+			Name:        "podcast-aac",
+			Description: "Audio-only podcast M4A: AAC-LC 96 kbit/s stereo at 44.1 kHz",
+			Options: with(ffmpeg.Options{
+				SkipVideo:    &yes,
+				MovFlags:     &faststart,
+				OutputFormat: str("ipod"),
+			},
+				codec.AAC{Profile: "aac_low", Bitrate: "96k", AudioFormat: codec.AudioFormat{Channels: 2, SampleRate: 44100}},
+			),
+		},
+		{
+			Name:        "opus-voice",
+			Description: "Mono Opus speech at 24 kbit/s in Ogg",
+			Options: with(ffmpeg.Options{
+				SkipVideo:    &yes,
+				OutputFormat: str("ogg"),
+			},
+				codec.Opus{
+					Bitrate:     "24k",
+					Application: "voip",
+					VBR:         "on",
+					AudioFormat: codec.AudioFormat{Channels: 1, SampleRate: 48000},
+				},
+			),
+		},
+	}
+
+	for _, r := range hlsRenditions {
+		presets = append(presets, Preset{
+			Name:        fmt.Sprintf("hls-%dp", r.height),
+			Description: fmt.Sprintf("HLS rendition %dp at %d kbit/s (%dx%d for 16:9 sources)", r.height, r.bitrate, r.width, r.height),
+			Extends:     "hls",
+			// Peaks may reach 110% of the average, with a two second buffer
+			Options: ffmpeg.Options{
+				VideoFilter:     str(fmt.Sprintf("scale=-2:%d", r.height)),
+				VideoBitRate:    str(fmt.Sprintf("%dk", r.bitrate)),
+				VideoMaxBitRate: integer(r.bitrate * 1100),
+				BufferSize:      integer(r.bitrate * 2000),
+			},
+		})
+	}
+
+	return presets
+}
+
+// with applies encoders to opts. The built-in configs are fixed, so an
+// error is a bug
+func with(opts ffmpeg.Options, encoders ...codec.Encoder) ffmpeg.Options {
+	opts, err := codec.Apply(opts, encoders...)
+	if err != nil {
+		panic(err)
+	}
+	return opts
+}
+
+// str returns a pointer to a copy of s
+func str(s string) *string {
+	return &s
+}
+
+// integer returns a pointer to a copy of v
+func integer(v int) *int {
+	return &v
+}
@@ -0,0 +1,151 @@
+// Package preset is a registry of named ffmpeg.Options for common delivery
+// targets.
+//
+// Presets can extend one another, users can register their own and layer
+// overrides on any of them. A Registry also satisfies job.Presets, so job
+// specs can reference its presets by name.
+package preset
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Errors
+var (
+	ErrNotFound = errors.New("preset not found")
+	ErrExists   = errors.New("preset already registered")
+)
+
+// Preset ...
+type Preset struct {
+	Name        string
+	Description string
+	// Extends names a preset whose options these override
+	Extends string
+	Options ffmpeg.Options
+}
+
+// Registry holds presets by name. It is safe for concurrent use
+type Registry struct {
+	mu      sync.RWMutex
+	presets map[string]Preset
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{presets: map[string]Preset{}}
+}
+
+// New returns a registry holding the built-in presets
+func New() *Registry {
+	r := NewRegistry()
+	for _, p := range builtin() {
+		if err := r.Register(p); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Default is the registry used by the package level functions
+var Default = New()
+
+// Register adds p to the default registry
+func Register(p Preset) error {
+	return Default.Register(p)
+}
+
+// Lookup resolves a preset of the default registry
+func Lookup(name string) (ffmpeg.Options, bool) {
+	return Default.Lookup(name)
+}
+
+// With resolves a preset of the default registry with overrides
+func With(name string, overrides ...ffmpeg.Options) (ffmpeg.Options, error) {
+	return Default.With(name, overrides...)
+}
+
+// Register adds a preset. Names are unique; the preset p extends must be
+// registered first
+func (r *Registry) Register(p Preset) error {
+	if p.Name == "" {
+		return errors.New("preset name is empty")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.presets[p.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, p.Name)
+	}
+	if _, ok := r.presets[p.Extends]; p.Extends != "" && !ok {
+		return fmt.Errorf("%w: %s extends %s", ErrNotFound, p.Name, p.Extends)
+	}
+
+	r.presets[p.Name] = p
+	return nil
+}
+
+// Get returns a preset as registered, without resolving what it extends
+func (r *Registry) Get(name string) (Preset, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.presets[name]
+	return p, ok
+}
+
+// Names returns the registered preset names, sorted
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.presets))
+	for name := range r.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the options of a preset, merged over those of the presets
+// it extends
+func (r *Registry) Lookup(name string) (ffmpeg.Options, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.presets[name]
+	if !ok {
+		return ffmpeg.Options{}, false
+	}
+
+	// Register only accepts existing bases, so chains cannot loop
+	chain := []ffmpeg.Options{p.Options}
+	for p.Extends != "" {
+		p = r.presets[p.Extends]
+		chain = append(chain, p.Options)
+	}
+
+	var opts ffmpeg.Options
+	for i := len(chain) - 1; i >= 0; i-- {
+		opts = opts.Merge(chain[i])
+	}
+	return opts, true
+}
+
+// With returns the options of a preset with overrides layered on in order
+func (r *Registry) With(name string, overrides ...ffmpeg.Options) (ffmpeg.Options, error) {
+	opts, ok := r.Lookup(name)
+	if !ok {
+		return ffmpeg.Options{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	for _, o := range overrides {
+		opts = opts.Merge(o)
+	}
+	return opts, nil
+}
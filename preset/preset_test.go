@@ -0,0 +1,104 @@
+package preset_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/job"
+	"github.com/floostack/transcoder/preset"
+)
+
+func TestBuiltin(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"web-h264", []string{"-c:v libx264", "-crf 23", "-movflags +faststart", "-f mp4"}},
+		{"youtube", []string{"-b:v 8M", "-bf 2", "-ab 384k", "-ar 48000"}},
+		{"youtube-4k", []string{"-b:v 45M", "-bf 2", "-f mp4"}},
+		{"hls-720p", []string{"-vf scale=-2:720", "-b:v 4500k", "-maxrate 4950000", "-hls_time 6", "-f hls", "-force_key_frames expr:gte(t,n_forced*2)"}},
+		{"xdcam-hd422", []string{"-c:v mpeg2video", "-pix_fmt yuv422p", "-minrate 50000000", "-c:a pcm_s24le", "-f mxf"}},
+		{"imx50", []string{"-g 1", "-f mxf_d10"}},
+		{"prores-proxy", []string{"-c:v prores_ks", "-profile:v 0", "-f mov"}},
+		{"podcast-mp3", []string{"-vn", "-c:a libmp3lame", "-f mp3"}},
+		{"podcast-aac", []string{"-vn", "-c:a aac", "-ab 96k", "-f ipod"}},
+		{"opus-voice", []string{"-c:a libopus", "-ac 1", "-application voip", "-f ogg"}},
+	}
+
+	for _, tt := range tests {
+		opts, ok := preset.Lookup(tt.name)
+		if !ok {
+			t.Errorf("%s: not found", tt.name)
+			continue
+		}
+		args := " " + strings.Join(opts.GetStrArguments(), " ") + " "
+		for _, w := range tt.want {
+			if !strings.Contains(args, " "+w+" ") {
+				t.Errorf("%s: %s lacks %s", tt.name, args, w)
+			}
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := preset.New()
+
+	crf := uint32(18)
+	err := r.Register(preset.Preset{Name: "web-hq", Extends: "web-h264", Options: ffmpeg.Options{Crf: &crf}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, ok := r.Lookup("web-hq")
+	if !ok {
+		t.Fatal("web-hq not found")
+	}
+	if *opts.Crf != 18 || *opts.VideoCodec != "libx264" {
+		t.Errorf("crf %d, codec %s", *opts.Crf, *opts.VideoCodec)
+	}
+
+	if err := r.Register(preset.Preset{Name: "web-hq"}); !errors.Is(err, preset.ErrExists) {
+		t.Errorf("duplicate: err = %v", err)
+	}
+	if err := r.Register(preset.Preset{Name: "x", Extends: "missing"}); !errors.Is(err, preset.ErrNotFound) {
+		t.Errorf("missing base: err = %v", err)
+	}
+	if _, ok := preset.Lookup("web-hq"); ok {
+		t.Error("registered into the default registry")
+	}
+}
+
+func TestWith(t *testing.T) {
+	rate := 22050
+	opts, err := preset.With("podcast-mp3", ffmpeg.Options{AudioRate: &rate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts.AudioRate != 22050 || *opts.AudioCodec != "libmp3lame" {
+		t.Errorf("rate %d, codec %s", *opts.AudioRate, *opts.AudioCodec)
+	}
+
+	if base, _ := preset.Lookup("podcast-mp3"); *base.AudioRate != 44100 {
+		t.Errorf("override changed the preset: %d", *base.AudioRate)
+	}
+	if _, err := preset.With("missing"); !errors.Is(err, preset.ErrNotFound) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestJobPresets(t *testing.T) {
+	spec := job.Spec{
+		Inputs:  []job.Input{{Path: "in.wav"}},
+		Outputs: []job.Output{{Path: "out.ogg", Preset: "opus-voice"}},
+	}
+
+	args, err := spec.Args(preset.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(args, " "); !strings.Contains(got, "-c:a libopus") {
+		t.Errorf("args %s", got)
+	}
+}
@@ -1,5 +1,7 @@
 package transcoder
 
+import "time"
+
 // Progress ...
 type Progress interface {
 	GetFramesProcessed() string
@@ -8,3 +10,10 @@ type Progress interface {
 	GetProgress() float64
 	GetSpeed() string
 }
+
+// Timing is implemented by progress that tracks the encoding time spent and
+// left
+type Timing interface {
+	GetElapsed() time.Duration
+	GetETA() time.Duration
+}
@@ -0,0 +1,376 @@
+// Package quality measures objective video quality: PSNR, SSIM and VMAF of
+// a distorted encode against its reference.
+package quality
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Metric is an objective quality metric
+type Metric string
+
+// Metrics
+const (
+	PSNR Metric = "psnr"
+	SSIM Metric = "ssim"
+	VMAF Metric = "vmaf"
+)
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Capabilities of the ffmpeg build. When nil they are detected to decide
+	// whether VMAF is available
+	Capabilities *ffmpeg.Capabilities
+	// VMAFModel is passed to libvmaf as its model option, e.g.
+	// "version=vmaf_4k_v0.6.1". Empty uses libvmaf's default model
+	VMAFModel string
+	// Threads for libvmaf. Zero lets libvmaf decide
+	Threads int
+	// WorkDir holds the per-frame statistics files. Defaults to the system
+	// temporary directory
+	WorkDir string
+}
+
+// Result holds the metrics that were computed. Metrics the ffmpeg build
+// cannot compute are listed in Skipped
+type Result struct {
+	PSNR    *PSNRResult
+	SSIM    *SSIMResult
+	VMAF    *VMAFResult
+	Skipped []Metric
+}
+
+// PSNRResult ...
+type PSNRResult struct {
+	Average float64
+	Min     float64
+	Max     float64
+	Y       float64
+	U       float64
+	V       float64
+	Frames  []PSNRFrame
+}
+
+// PSNRFrame ...
+type PSNRFrame struct {
+	N       int
+	MSE     float64
+	Average float64
+	Y       float64
+	U       float64
+	V       float64
+}
+
+// SSIMResult ...
+type SSIMResult struct {
+	All    float64
+	Y      float64
+	U      float64
+	V      float64
+	Frames []SSIMFrame
+}
+
+// SSIMFrame ...
+type SSIMFrame struct {
+	N   int
+	All float64
+	Y   float64
+	U   float64
+	V   float64
+}
+
+// VMAFResult ...
+type VMAFResult struct {
+	Mean         float64
+	Min          float64
+	Max          float64
+	HarmonicMean float64
+	Frames       []VMAFFrame
+}
+
+// VMAFFrame ...
+type VMAFFrame struct {
+	N     int
+	Score float64
+}
+
+// Compare computes metrics of distorted against reference in one pass. The
+// distorted video is scaled to the reference's resolution and both are
+// brought to the reference's frame rate, so renditions of a ladder can be
+// compared with their source
+func Compare(ctx context.Context, cfg Config, reference, distorted string, metrics ...Metric) (*Result, error) {
+	if len(metrics) == 0 {
+		metrics = []Metric{PSNR, SSIM, VMAF}
+	}
+
+	result := &Result{}
+
+	var run []Metric
+	for _, m := range metrics {
+		if m == VMAF {
+			caps := cfg.Capabilities
+			if caps == nil {
+				var err error
+				if caps, err = ffmpeg.DetectCapabilities(ctx, cfg.FFmpeg); err != nil {
+					return nil, err
+				}
+			}
+			if !caps.HasFilter("libvmaf") {
+				result.Skipped = append(result.Skipped, VMAF)
+				continue
+			}
+		}
+		run = append(run, m)
+	}
+	if len(run) == 0 {
+		return result, nil
+	}
+
+	metadata, err := ffmpeg.New(cfg.FFmpeg).Input(reference).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	width, height, fps := 0, 0, ""
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() == "video" {
+			width, height, fps = s.GetWidth(), s.GetHeight(), s.GetAvgFrameRate()
+			if fps == "" || fps == "0/0" {
+				fps = s.GetRFrameRrate()
+			}
+			break
+		}
+	}
+	if width == 0 || height == 0 {
+		return nil, errors.New("reference has no video stream")
+	}
+
+	dir, err := ioutil.TempDir(cfg.WorkDir, "quality")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	logs := map[Metric]string{}
+	for _, m := range run {
+		logs[m] = filepath.Join(dir, string(m)+".log")
+	}
+
+	graph := filterGraph(cfg, run, logs, width, height, fps)
+	_, stderr, err := ffmpeg.Exec(ctx, cfg.FFmpeg,
+		"-hide_banner", "-nostats", "-i", distorted, "-i", reference,
+		"-filter_complex", graph, "-f", "null", "-")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range run {
+		switch m {
+		case PSNR:
+			result.PSNR, err = parsePSNR(logs[m], stderr)
+		case SSIM:
+			result.SSIM, err = parseSSIM(logs[m], stderr)
+		case VMAF:
+			result.VMAF, err = parseVMAF(logs[m])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m, err)
+		}
+	}
+
+	return result, nil
+}
+
+// filterGraph aligns the distorted input ([0:v]) to the reference ([1:v])
+// and feeds both to one comparison filter per metric
+func filterGraph(cfg Config, metrics []Metric, logs map[Metric]string, width, height int, fps string) string {
+	n := len(metrics)
+	var dist, ref, cmp []string
+	for i := 0; i < n; i++ {
+		dist = append(dist, fmt.Sprintf("[d%d]", i))
+		ref = append(ref, fmt.Sprintf("[r%d]", i))
+	}
+
+	align := "format=yuv420p,setpts=PTS-STARTPTS"
+	if fps != "" && fps != "0/0" {
+		align = "fps=" + fps + "," + align
+	}
+
+	graph := []string{
+		fmt.Sprintf("[0:v]scale=%d:%d:flags=bicubic,%s,split=%d%s",
+			width, height, align, n, strings.Join(dist, "")),
+		fmt.Sprintf("[1:v]%s,split=%d%s", align, n, strings.Join(ref, "")),
+	}
+
+	for i, m := range metrics {
+		path := ffmpeg.EscapeFilterValue(logs[m])
+		switch m {
+		case PSNR:
+			cmp = append(cmp, fmt.Sprintf("%s%spsnr=stats_file=%s", dist[i], ref[i], path))
+		case SSIM:
+			cmp = append(cmp, fmt.Sprintf("%s%sssim=stats_file=%s", dist[i], ref[i], path))
+		case VMAF:
+			opts := "log_fmt=json:log_path=" + path
+			if cfg.VMAFModel != "" {
+				opts += ":model=" + ffmpeg.EscapeFilterValue(cfg.VMAFModel)
+			}
+			if cfg.Threads > 0 {
+				opts += ":n_threads=" + strconv.Itoa(cfg.Threads)
+			}
+			cmp = append(cmp, fmt.Sprintf("%s%slibvmaf=%s", dist[i], ref[i], opts))
+		}
+	}
+
+	return strings.Join(append(graph, cmp...), ";")
+}
+
+var (
+	statRe        = regexp.MustCompile(`(\w+):(-?[\d.]+|inf)`)
+	psnrSummaryRe = regexp.MustCompile(`PSNR y:(\S+) u:(\S+) v:(\S+) average:(\S+) min:(\S+) max:(\S+)`)
+	ssimSummaryRe = regexp.MustCompile(`SSIM Y:(\S+) .*U:(\S+) .*V:(\S+) .*All:(\S+)`)
+)
+
+// parsePSNR reads the psnr stats file and the summary printed to stderr
+func parsePSNR(path, stderr string) (*PSNRResult, error) {
+	r := &PSNRResult{}
+
+	err := readStats(path, func(stats map[string]float64) {
+		r.Frames = append(r.Frames, PSNRFrame{
+			N:       int(stats["n"]),
+			MSE:     stats["mse_avg"],
+			Average: stats["psnr_avg"],
+			Y:       stats["psnr_y"],
+			U:       stats["psnr_u"],
+			V:       stats["psnr_v"],
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m := psnrSummaryRe.FindStringSubmatch(stderr); m != nil {
+		r.Y, r.U, r.V = atof(m[1]), atof(m[2]), atof(m[3])
+		r.Average, r.Min, r.Max = atof(m[4]), atof(m[5]), atof(m[6])
+	}
+
+	return r, nil
+}
+
+// parseSSIM reads the ssim stats file and the summary printed to stderr
+func parseSSIM(path, stderr string) (*SSIMResult, error) {
+	r := &SSIMResult{}
+
+	err := readStats(path, func(stats map[string]float64) {
+		r.Frames = append(r.Frames, SSIMFrame{
+			N:   int(stats["n"]),
+			All: stats["All"],
+			Y:   stats["Y"],
+			U:   stats["U"],
+			V:   stats["V"],
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m := ssimSummaryRe.FindStringSubmatch(stderr); m != nil {
+		r.Y, r.U, r.V, r.All = atof(m[1]), atof(m[2]), atof(m[3]), atof(m[4])
+	}
+
+	return r, nil
+}
+
+// vmafLog is the JSON log written by libvmaf 2.x
+type vmafLog struct {
+	Frames []struct {
+		FrameNum int                `json:"frameNum"`
+		Metrics  map[string]float64 `json:"metrics"`
+	} `json:"frames"`
+	PooledMetrics map[string]struct {
+		Min          float64 `json:"min"`
+		Max          float64 `json:"max"`
+		Mean         float64 `json:"mean"`
+		HarmonicMean float64 `json:"harmonic_mean"`
+	} `json:"pooled_metrics"`
+}
+
+// parseVMAF reads libvmaf's JSON log
+func parseVMAF(path string) (*VMAFResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var log vmafLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, err
+	}
+
+	r := &VMAFResult{}
+	for _, f := range log.Frames {
+		r.Frames = append(r.Frames, VMAFFrame{N: f.FrameNum, Score: f.Metrics["vmaf"]})
+	}
+
+	if pooled, ok := log.PooledMetrics["vmaf"]; ok {
+		r.Mean, r.Min, r.Max, r.HarmonicMean = pooled.Mean, pooled.Min, pooled.Max, pooled.HarmonicMean
+		return r, nil
+	}
+
+	// Older libvmaf versions do not pool, so aggregate the frames
+	if len(r.Frames) > 0 {
+		r.Min, r.Max = math.Inf(1), math.Inf(-1)
+		var sum, inv float64
+		for _, f := range r.Frames {
+			sum += f.Score
+			inv += 1 / (f.Score + 1)
+			r.Min = math.Min(r.Min, f.Score)
+			r.Max = math.Max(r.Max, f.Score)
+		}
+		n := float64(len(r.Frames))
+		r.Mean = sum / n
+		r.HarmonicMean = n/inv - 1
+	}
+
+	return r, nil
+}
+
+// readStats calls fn with the key:value pairs of every line of a stats file
+func readStats(path string, fn func(map[string]float64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		stats := map[string]float64{}
+		for _, m := range statRe.FindAllStringSubmatch(scanner.Text(), -1) {
+			stats[m[1]] = atof(m[2])
+		}
+		if len(stats) > 0 {
+			fn(stats)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// atof parses numbers, including "inf" for identical frames
+func atof(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
@@ -0,0 +1,123 @@
+package quality_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/quality"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+const (
+	psnrStats = `n:1 mse_avg:2.50 mse_y:3.00 mse_u:1.50 mse_v:1.50 psnr_avg:44.15 psnr_y:43.36 psnr_u:46.37 psnr_v:46.37
+n:2 mse_avg:0.00 mse_y:0.00 mse_u:0.00 mse_v:0.00 psnr_avg:inf psnr_y:inf psnr_u:inf psnr_v:inf
+`
+	ssimStats = `n:1 Y:0.981000 U:0.990000 V:0.992000 All:0.984500 (18.08)
+n:2 Y:1.000000 U:1.000000 V:1.000000 All:1.000000 (inf)
+`
+	vmafLog = `{"version":"2.3.1","frames":[{"frameNum":0,"metrics":{"vmaf":92.5}},{"frameNum":1,"metrics":{"vmaf":97.5}}],
+"pooled_metrics":{"vmaf":{"min":92.5,"max":97.5,"mean":95.0,"harmonic_mean":94.93}}}`
+	summary = `[Parsed_psnr_4 @ 0x55] PSNR y:43.36 u:46.37 v:46.37 average:44.15 min:44.15 max:inf
+[Parsed_ssim_5 @ 0x56] SSIM Y:0.990500 (20.22) U:0.995000 (23.01) V:0.996000 (23.98) All:0.992250 (21.10)
+`
+)
+
+var logPathRe = regexp.MustCompile(`(stats_file|log_path)=([^:;\[]+)`)
+
+// runner fakes ffprobe and an ffmpeg run writing the stats files named in
+// the filtergraph
+func runner(t *testing.T) *ffmpeg.FakeRunner {
+	probe := transcodertest.Probe(2*time.Second, transcodertest.VideoStream("h264", 1920, 1080))
+
+	return &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		if filepath.Base(cmd.Path) == "ffprobe" {
+			return ffmpeg.FakeResult{Stdout: probe}
+		}
+		graph := cmd.Args[len(cmd.Args)-4]
+		for _, m := range logPathRe.FindAllStringSubmatch(graph, -1) {
+			content := vmafLog
+			switch {
+			case strings.HasSuffix(m[2], "psnr.log"):
+				content = psnrStats
+			case strings.HasSuffix(m[2], "ssim.log"):
+				content = ssimStats
+			}
+			if err := ioutil.WriteFile(m[2], []byte(content), 0644); err != nil {
+				t.Error(err)
+			}
+		}
+		return ffmpeg.FakeResult{Stderr: summary}
+	}}
+}
+
+func TestCompare(t *testing.T) {
+	r := runner(t)
+	cfg := quality.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: r},
+		Capabilities: &ffmpeg.Capabilities{Filters: map[string]bool{"libvmaf": true}},
+	}
+
+	result, err := quality.Compare(context.Background(), cfg, "source.mov", "720p.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := r.Calls()[1].Args
+	if args[len(args)-8] != "720p.mp4" || args[len(args)-6] != "source.mov" {
+		t.Errorf("inputs = %v", args)
+	}
+	graph := args[len(args)-4]
+	for _, want := range []string{
+		"[0:v]scale=1920:1080:flags=bicubic,fps=25/1,format=yuv420p,setpts=PTS-STARTPTS,split=3[d0][d1][d2]",
+		"[1:v]fps=25/1,format=yuv420p,setpts=PTS-STARTPTS,split=3[r0][r1][r2]",
+		"[d0][r0]psnr=stats_file=",
+		"[d1][r1]ssim=stats_file=",
+		"[d2][r2]libvmaf=log_fmt=json:log_path=",
+	} {
+		if !strings.Contains(graph, want) {
+			t.Errorf("graph %s\nmissing %s", graph, want)
+		}
+	}
+
+	if p := result.PSNR; p == nil || p.Average != 44.15 || p.Y != 43.36 || len(p.Frames) != 2 || p.Frames[0].MSE != 2.5 {
+		t.Errorf("psnr = %+v", p)
+	}
+	if s := result.SSIM; s == nil || s.All != 0.99225 || len(s.Frames) != 2 || s.Frames[0].Y != 0.981 {
+		t.Errorf("ssim = %+v", s)
+	}
+	if v := result.VMAF; v == nil || v.Mean != 95 || v.HarmonicMean != 94.93 || len(v.Frames) != 2 || v.Frames[1].Score != 97.5 {
+		t.Errorf("vmaf = %+v", v)
+	}
+	if len(result.Skipped) != 0 {
+		t.Errorf("skipped = %v", result.Skipped)
+	}
+}
+
+func TestCompareSkipsVMAFWithoutLibvmaf(t *testing.T) {
+	r := runner(t)
+	cfg := quality.Config{
+		FFmpeg:       &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: r},
+		Capabilities: &ffmpeg.Capabilities{Filters: map[string]bool{"psnr": true}},
+	}
+
+	result, err := quality.Compare(context.Background(), cfg, "source.mov", "720p.mp4", quality.PSNR, quality.VMAF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Skipped) != 1 || result.Skipped[0] != quality.VMAF {
+		t.Errorf("skipped = %v", result.Skipped)
+	}
+	if result.VMAF != nil || result.PSNR == nil {
+		t.Errorf("result = %+v", result)
+	}
+	if graph := r.Calls()[1].Args[len(r.Calls()[1].Args)-4]; strings.Contains(graph, "libvmaf") || !strings.Contains(graph, "split=1[d0]") {
+		t.Errorf("graph = %s", graph)
+	}
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"github.com/floostack/transcoder/event"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// finishEvents maps terminal states to their events
+var finishEvents = map[State]event.Type{
+	StateDone:      event.Completed,
+	StateFailed:    event.Failed,
+	StateCancelled: event.Cancelled,
+}
+
+// publish reports the current status of a job to the configured Publisher.
+// Callers hold q.mu
+func (q *Queue) publish(j *job, typ event.Type) {
+	if q.config.Events == nil {
+		return
+	}
+
+	status := j.status
+	e := event.Event{
+		Type:    typ,
+		Job:     status.ID,
+		Attempt: status.Attempts,
+		Command: j.command,
+		Error:   status.Error,
+		Timing:  event.Timing{Created: status.Created},
+	}
+
+	// Queued jobs have not run yet, or are waiting for a retry
+	if typ != event.Queued {
+		e.Progress = event.NewProgress(status.Progress)
+		if !status.Started.IsZero() {
+			started := status.Started
+			e.Timing.Started = &started
+		}
+		if !status.Finished.IsZero() {
+			finished := status.Finished
+			e.Timing.Finished = &finished
+			if !status.Started.IsZero() {
+				e.Timing.Duration = finished.Sub(status.Started).Seconds()
+			}
+		}
+	}
+	if typ == event.Completed {
+		e.Outputs = j.outputs
+	}
+
+	q.config.Events.Publish(e)
+}
+
+// probeOutputs probes the outputs of a finished job. Outputs that cannot be
+// probed, such as pipes, are listed without metadata
+func (q *Queue) probeOutputs(j *job) []event.Output {
+	cfg := *q.config.FFmpeg
+
+	var outputs []event.Output
+	for _, out := range j.status.Spec.Outputs {
+		o := event.Output{Path: out.Path}
+		if m, err := ffmpeg.New(&cfg).Input(out.Path).WithContext(&j.ctx).GetMetadata(); err == nil {
+			if metadata, ok := m.(ffmpeg.Metadata); ok {
+				o.Metadata = &metadata
+			}
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs
+}
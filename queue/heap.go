@@ -0,0 +1,33 @@
+package queue
+
+// jobHeap orders queued jobs by priority, then submission order
+type jobHeap []*job
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].status.Spec.Priority != h[j].status.Spec.Priority {
+		return h[i].status.Spec.Priority > h[j].status.Spec.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*h)
+	*h = append(*h, j)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	j := old[len(old)-1]
+	j.index = -1
+	*h = old[:len(old)-1]
+	return j
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Spec describes a transcode job: one input and one or more outputs, each
+// with its own options
+type Spec struct {
+	Input        string
+	InputOptions *ffmpeg.Options
+	// InputArgs are raw arguments placed before InputOptions, e.g. the
+	// "-i" pairs of further inputs
+	InputArgs []string `json:",omitempty"`
+	Outputs   []Output
+	// Priority orders queued jobs, highest first. Jobs of equal priority run
+	// in submission order
+	Priority int
+	// Weight is the number of worker slots the job occupies while running.
+	// When zero it is estimated by the queue's Weigher
+	Weight int
+}
+
+// Output is one output file of a Spec
+type Output struct {
+	Path    string
+	Options ffmpeg.Options
+	// Args are raw arguments placed before Options, for flags Options
+	// cannot repeat such as -map
+	Args []string `json:",omitempty"`
+}
+
+// State is the lifecycle state of a queued job
+type State string
+
+// Job states
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateDone      State = "done"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Finished reports whether s is a terminal state
+func (s State) Finished() bool {
+	return s == StateDone || s == StateFailed || s == StateCancelled
+}
+
+// Status is a snapshot of a job
+type Status struct {
+	ID       string
+	Spec     Spec
+	State    State
+	Weight   int
+	Attempts int
+	Progress ffmpeg.Progress
+	Error    string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+}
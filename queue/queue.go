@@ -0,0 +1,508 @@
+// Package queue runs transcode jobs on a bounded worker pool.
+//
+// Each job occupies a number of worker slots while it runs, so a queue with
+// eight slots can run eight audio extractions at once but only one 4K HEVC
+// encode. Jobs are started in priority order and can be cancelled by ID
+// whether they are still queued or already running.
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/event"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// ErrNotFound is returned for unknown job IDs
+var ErrNotFound = errors.New("job not found")
+
+// ErrClosed is returned when submitting to a closed queue
+var ErrClosed = errors.New("queue closed")
+
+// errExists is returned when queueing a job under an ID already in use
+var errExists = errors.New("job already exists")
+
+// Config ...
+type Config struct {
+	// FFmpeg configures the transcoder of every job. Progress reporting is
+	// always enabled. Defaults to the ffmpeg and ffprobe binaries in the PATH
+	FFmpeg *ffmpeg.Config
+	// Slots is the worker pool capacity. Defaults to the number of CPUs
+	Slots int
+	// Weigher estimates the slots needed by jobs submitted without a weight.
+	// Defaults to DefaultWeight
+	Weigher Weigher
+	// Store persists jobs so they can be recovered after a restart with
+	// Recover. Optional
+	Store Store
+	// CheckpointInterval is how often the progress of running jobs is
+	// saved to the Store. Defaults to 5 seconds
+	CheckpointInterval time.Duration
+	// Retry decides which failed or interrupted jobs are attempted again
+	Retry RetryPolicy
+	// Events receives the lifecycle events of every job. Optional
+	Events event.Publisher
+	// ProgressEventInterval is the minimum time between the progress events
+	// of a job. Defaults to 1 second
+	ProgressEventInterval time.Duration
+}
+
+// Queue ...
+type Queue struct {
+	config Config
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	pending jobHeap
+	used    int
+	seq     int
+	closed  bool
+	running sync.WaitGroup
+}
+
+// job ...
+type job struct {
+	status Status
+	seq    int
+	index  int
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	// command and outputs are reported in events
+	command []string
+	outputs []event.Output
+}
+
+// New ...
+func New(cfg Config) *Queue {
+	if cfg.FFmpeg == nil {
+		cfg.FFmpeg = &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe"}
+	}
+	if cfg.Slots <= 0 {
+		cfg.Slots = runtime.NumCPU()
+	}
+	if cfg.Weigher == nil {
+		cfg.Weigher = DefaultWeight
+	}
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = 5 * time.Second
+	}
+	if cfg.ProgressEventInterval <= 0 {
+		cfg.ProgressEventInterval = time.Second
+	}
+	return &Queue{config: cfg, jobs: map[string]*job{}}
+}
+
+// Submit queues spec and returns the job ID. The job is cancelled when ctx
+// is done, gracefully stopping ffmpeg if it is already running
+func (q *Queue) Submit(ctx context.Context, spec Spec) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	return id, q.requeue(ctx, Status{ID: id, Spec: spec, Created: time.Now()})
+}
+
+// requeue queues a new or recovered job
+func (q *Queue) requeue(ctx context.Context, status Status) error {
+	spec := status.Spec
+	if len(spec.Outputs) == 0 {
+		return errors.New("job has no outputs")
+	}
+
+	weight := spec.Weight
+	if weight <= 0 {
+		weight = q.config.Weigher(spec)
+	}
+	// A job heavier than the whole pool would never start
+	if weight > q.config.Slots {
+		weight = q.config.Slots
+	}
+
+	status.State = StateQueued
+	status.Weight = weight
+	status.Started = time.Time{}
+	status.Progress = ffmpeg.Progress{}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	id := status.ID
+	if _, ok := q.jobs[id]; ok {
+		return errExists
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	j := &job{
+		status: status,
+		seq:    q.seq,
+		ctx:    jobCtx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if err := q.persist(j); err != nil {
+		cancel()
+		return err
+	}
+
+	q.seq++
+	q.jobs[id] = j
+	heap.Push(&q.pending, j)
+	q.publish(j, event.Queued)
+
+	go func() {
+		select {
+		case <-jobCtx.Done():
+			q.Cancel(id)
+		case <-j.done:
+		}
+	}()
+
+	q.dispatch()
+
+	return nil
+}
+
+// Cancel cancels a queued or running job. Running jobs are stopped
+// gracefully, so their outputs remain valid but truncated
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	switch j.status.State {
+	case StateQueued:
+		// Jobs waiting out a retry backoff are not in the heap
+		if j.index >= 0 {
+			heap.Remove(&q.pending, j.index)
+		}
+		q.finish(j, StateCancelled, nil)
+		q.dispatch()
+	case StateRunning:
+		j.cancel()
+	}
+
+	return nil
+}
+
+// Status returns a snapshot of the job with the given ID
+func (q *Queue) Status(id string) (Status, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Status{}, ErrNotFound
+	}
+	return j.status, nil
+}
+
+// Jobs returns a snapshot of every job, in submission order
+func (q *Queue) Jobs() []Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	all := make([]*job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		all = append(all, j)
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].seq < all[b].seq })
+
+	jobs := make([]Status, len(all))
+	for i, j := range all {
+		jobs[i] = j.status
+	}
+	return jobs
+}
+
+// Forget removes the jobs that finished before t, from the queue and the
+// Store, and returns their IDs. Forgotten jobs are no longer known to
+// Status, Jobs or Cancel, nor restored by Recover
+func (q *Queue) Forget(t time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []string
+	for id, j := range q.jobs {
+		if j.status.State.Finished() && j.status.Finished.Before(t) {
+			if q.config.Store != nil {
+				q.config.Store.Delete(id)
+			}
+			delete(q.jobs, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Stats aggregates the state of every job in a queue
+type Stats struct {
+	Queued    int
+	Running   int
+	Done      int
+	Failed    int
+	Cancelled int
+	SlotsUsed int
+	Slots     int
+	// Progress is the mean progress percentage of all jobs that have not
+	// been cancelled, counting finished jobs as complete
+	Progress float64
+}
+
+// Stats returns aggregate progress and state counts
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := Stats{SlotsUsed: q.used, Slots: q.config.Slots}
+	counted := 0
+
+	for _, j := range q.jobs {
+		switch j.status.State {
+		case StateQueued:
+			stats.Queued++
+		case StateRunning:
+			stats.Running++
+		case StateDone:
+			stats.Done++
+		case StateFailed:
+			stats.Failed++
+		case StateCancelled:
+			stats.Cancelled++
+			continue
+		}
+
+		counted++
+		if j.status.State.Finished() {
+			stats.Progress += 100
+		} else {
+			stats.Progress += j.status.Progress.Progress
+		}
+	}
+
+	if counted > 0 {
+		stats.Progress /= float64(counted)
+	}
+
+	return stats
+}
+
+// Wait blocks until the job finishes or ctx is done
+func (q *Queue) Wait(ctx context.Context, id string) (Status, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+
+	if !ok {
+		return Status{}, ErrNotFound
+	}
+
+	select {
+	case <-j.done:
+		return q.Status(id)
+	case <-ctx.Done():
+		return Status{}, ctx.Err()
+	}
+}
+
+// Close cancels every queued and running job and waits for running ones
+// to stop. Jobs interrupted by Close are not recorded as cancelled in the
+// Store, so a later Recover picks them up again
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	for q.pending.Len() > 0 {
+		q.finish(heap.Pop(&q.pending).(*job), StateCancelled, nil)
+	}
+	for _, j := range q.jobs {
+		j.cancel()
+	}
+	q.mu.Unlock()
+
+	q.running.Wait()
+}
+
+// dispatch starts queued jobs while the one with the highest priority fits
+// into the free slots. Callers hold q.mu
+func (q *Queue) dispatch() {
+	for q.pending.Len() > 0 {
+		j := q.pending[0]
+		if q.used+j.status.Weight > q.config.Slots {
+			return
+		}
+
+		heap.Pop(&q.pending)
+		q.used += j.status.Weight
+		j.status.State = StateRunning
+		j.status.Started = time.Now()
+		j.status.Attempts++
+		q.persist(j)
+
+		q.running.Add(1)
+		go q.run(j)
+	}
+}
+
+// run transcodes a job and releases its slots
+func (q *Queue) run(j *job) {
+	defer q.running.Done()
+
+	err := q.transcode(j)
+
+	// Probing outputs needs no slots, so waiting jobs start meanwhile
+	q.mu.Lock()
+	q.used -= j.status.Weight
+	q.dispatch()
+	q.mu.Unlock()
+
+	var outputs []event.Output
+	if err == nil && j.ctx.Err() == nil && q.config.Events != nil {
+		outputs = q.probeOutputs(j)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j.outputs = outputs
+
+	switch {
+	case j.ctx.Err() != nil:
+		q.finish(j, StateCancelled, nil)
+	case err != nil:
+		if delay, ok := q.config.Retry.retry(err, j.status.Attempts); ok && !q.closed {
+			q.backoff(j, delay, err)
+		} else {
+			q.finish(j, StateFailed, err)
+		}
+	default:
+		q.finish(j, StateDone, nil)
+	}
+
+	q.dispatch()
+}
+
+// backoff queues a failed job again once delay has passed. Callers hold q.mu
+func (q *Queue) backoff(j *job, delay time.Duration, err error) {
+	j.status.State = StateQueued
+	j.status.Error = err.Error()
+	j.index = -1
+	q.persist(j)
+	q.publish(j, event.Queued)
+
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-j.ctx.Done():
+			return
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if j.status.State == StateQueued && j.index < 0 {
+			heap.Push(&q.pending, j)
+			q.dispatch()
+		}
+	}()
+}
+
+// transcode runs ffmpeg for a job, recording its progress
+func (q *Queue) transcode(j *job) error {
+	cfg := *q.config.FFmpeg
+	cfg.ProgressEnabled = true
+	cfg.Verbose = false
+
+	spec := j.status.Spec
+	trans := ffmpeg.New(&cfg).Input(spec.Input).WithContext(&j.ctx)
+	if len(spec.InputArgs) > 0 {
+		trans = trans.WithInputOptions(ffmpeg.RawArgs(spec.InputArgs))
+	}
+	if spec.InputOptions != nil {
+		trans = trans.WithAdditionalInputOptions(*spec.InputOptions)
+	}
+	for _, out := range spec.Outputs {
+		args := append(ffmpeg.RawArgs(append([]string{}, out.Args...)), out.Options.GetStrArguments()...)
+		trans = trans.Output(out.Path).WithAdditionalOutputOptions(args)
+	}
+
+	command, _ := trans.(transcoder.Commander).Command()
+	q.mu.Lock()
+	j.command = command
+	q.publish(j, event.Started)
+	q.mu.Unlock()
+
+	progress, err := trans.Start()
+	if err != nil {
+		return err
+	}
+
+	checkpoint, published := time.Now(), time.Now()
+	for p := range progress {
+		q.mu.Lock()
+		j.status.Progress = p.(ffmpeg.Progress)
+		if time.Since(checkpoint) >= q.config.CheckpointInterval {
+			checkpoint = time.Now()
+			q.persist(j)
+		}
+		if time.Since(published) >= q.config.ProgressEventInterval {
+			published = time.Now()
+			q.publish(j, event.Progress)
+		}
+		q.mu.Unlock()
+	}
+
+	return trans.(transcoder.Stopper).Wait()
+}
+
+// finish moves a job into a terminal state. Callers hold q.mu
+func (q *Queue) finish(j *job, state State, err error) {
+	j.status.State = state
+	j.status.Finished = time.Now()
+	j.status.Error = ""
+	if err != nil {
+		j.status.Error = err.Error()
+	}
+	if state == StateDone {
+		j.status.Progress.Progress = 100
+	}
+	if !(q.closed && state == StateCancelled) {
+		q.persist(j)
+	}
+	q.publish(j, finishEvents[state])
+	j.cancel()
+	close(j.done)
+}
+
+// persist saves a job to the store, if any. Failures after submission are
+// not fatal to the job and are only reported to the caller. Callers hold q.mu
+func (q *Queue) persist(j *job) error {
+	if q.config.Store == nil {
+		return nil
+	}
+	return q.config.Store.Save(j.status)
+}
+
+// newID returns a random job ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,308 @@
+package queue_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/event"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/queue"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func newQueue(slots int, script transcodertest.Script) (*queue.Queue, *ffmpeg.FakeRunner) {
+	runner := transcodertest.Runner(script, transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)})
+	return queue.New(queue.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Slots:  slots,
+	}), runner
+}
+
+func spec(output string, priority, weight int) queue.Spec {
+	return queue.Spec{
+		Input:    "in.mov",
+		Outputs:  []queue.Output{{Path: output}},
+		Priority: priority,
+		Weight:   weight,
+	}
+}
+
+func TestQueueRunsJobsToCompletion(t *testing.T) {
+	q, _ := newQueue(2, transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 3)})
+	defer q.Close()
+
+	var ids []string
+	for _, out := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+		id, err := q.Submit(context.Background(), spec(out, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		status, err := q.Wait(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != queue.StateDone || status.Progress.Progress != 100 {
+			t.Errorf("job %s finished as %s at %v%%: %s", id, status.State, status.Progress.Progress, status.Error)
+		}
+	}
+
+	if stats := q.Stats(); stats.Done != 3 || stats.Progress != 100 || stats.SlotsUsed != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQueueRespectsSlotsAndPriority(t *testing.T) {
+	q, runner := newQueue(2, transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 10), LineDelay: 5 * time.Millisecond})
+	defer q.Close()
+
+	ctx := context.Background()
+	heavy, _ := q.Submit(ctx, spec("heavy.mp4", 0, 2))
+	low, _ := q.Submit(ctx, spec("low.mp4", 0, 2))
+	high, _ := q.Submit(ctx, spec("high.mp4", 10, 2))
+
+	if s, _ := q.Status(low); s.State != queue.StateQueued {
+		t.Errorf("low priority job is %s while the pool is full", s.State)
+	}
+
+	for _, id := range []string{heavy, low, high} {
+		if _, err := q.Wait(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var order []string
+	for _, call := range runner.Calls() {
+		if call.Path == "ffmpeg" {
+			order = append(order, call.Args[len(call.Args)-1])
+		}
+	}
+	if len(order) != 3 || order[0] != "heavy.mp4" || order[1] != "high.mp4" {
+		t.Errorf("jobs ran in order %v", order)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	q, _ := newQueue(1, transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 1000), LineDelay: 5 * time.Millisecond})
+	defer q.Close()
+
+	ctx := context.Background()
+	running, _ := q.Submit(ctx, spec("running.mp4", 0, 1))
+	queued, _ := q.Submit(ctx, spec("queued.mp4", 0, 1))
+
+	if err := q.Cancel(queued); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel(running); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{running, queued} {
+		status, err := q.Wait(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != queue.StateCancelled {
+			t.Errorf("job %s is %s, want cancelled", id, status.State)
+		}
+	}
+
+	if err := q.Cancel("missing"); err != queue.ErrNotFound {
+		t.Errorf("Cancel(missing) = %v", err)
+	}
+}
+
+func TestQueueForget(t *testing.T) {
+	q, _ := newQueue(1, transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 1000), LineDelay: 10 * time.Millisecond})
+	defer q.Close()
+
+	ctx := context.Background()
+	running, _ := q.Submit(ctx, spec("running.mp4", 0, 1))
+	// Queued behind the running job, so cancelling finishes it at once
+	done, _ := q.Submit(ctx, spec("done.mp4", 0, 1))
+	q.Cancel(done)
+
+	if ids := q.Forget(time.Now()); len(ids) != 1 || ids[0] != done {
+		t.Errorf("forgot %v, want %s", ids, done)
+	}
+	if _, err := q.Status(done); err != queue.ErrNotFound {
+		t.Errorf("forgotten job: %v", err)
+	}
+	if jobs := q.Jobs(); len(jobs) != 1 || jobs[0].ID != running {
+		t.Errorf("jobs %+v", jobs)
+	}
+}
+
+func TestQueueDefaultsFFmpeg(t *testing.T) {
+	q := queue.New(queue.Config{})
+	defer q.Close()
+
+	id, err := q.Submit(context.Background(), queue.Spec{Input: "/nonexistent/in.mov", Outputs: []queue.Output{{Path: "/nonexistent/out.mp4"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The job fails on the missing input rather than the worker panicking
+	if status, err := q.Wait(context.Background(), id); err != nil || status.State != queue.StateFailed {
+		t.Errorf("job %s, %v", status.State, err)
+	}
+}
+
+func TestDefaultWeight(t *testing.T) {
+	hevc, uhd, aac := "libx265", "3840x2160", "aac"
+	skip := true
+
+	uhdHevc := queue.Spec{Outputs: []queue.Output{{Options: ffmpeg.Options{VideoCodec: &hevc, Resolution: &uhd}}}}
+	audio := queue.Spec{Outputs: []queue.Output{{Options: ffmpeg.Options{AudioCodec: &aac, SkipVideo: &skip}}}}
+
+	if w := queue.DefaultWeight(uhdHevc); w != 16 {
+		t.Errorf("4K HEVC weight = %d", w)
+	}
+	if w := queue.DefaultWeight(audio); w != 1 {
+		t.Errorf("audio weight = %d", w)
+	}
+}
+
+func TestQueueRetriesTransientFailures(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)})
+	runner.Results["ffmpeg"] = []ffmpeg.FakeResult{
+		{Stderr: "Connection reset by peer\n", ExitCode: 1},
+		{Stderr: transcodertest.Progress(time.Minute, 1)},
+	}
+
+	q := queue.New(queue.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Retry:  queue.RetryPolicy{Backoff: time.Millisecond},
+	})
+	defer q.Close()
+
+	id, _ := q.Submit(context.Background(), spec("out.mp4", 0, 1))
+	status, err := q.Wait(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != queue.StateDone || status.Attempts != 2 {
+		t.Errorf("job finished as %s after %d attempts: %s", status.State, status.Attempts, status.Error)
+	}
+}
+
+func TestQueueDoesNotRetryPermanentFailures(t *testing.T) {
+	q, _ := newQueue(1, transcodertest.Script{Stderr: "Invalid data found when processing input\n", ExitCode: 1})
+	defer q.Close()
+
+	id, _ := q.Submit(context.Background(), spec("out.mp4", 0, 1))
+	status, err := q.Wait(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != queue.StateFailed || status.Attempts != 1 {
+		t.Errorf("job finished as %s after %d attempts", status.State, status.Attempts)
+	}
+}
+
+func TestQueueEvents(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{
+		Stdout: transcodertest.Probe(time.Minute, transcodertest.AudioStream("aac")),
+	})
+	runner.Results["ffmpeg"] = []ffmpeg.FakeResult{
+		{Stderr: "Connection reset by peer\n", ExitCode: 1},
+		{Stderr: transcodertest.Progress(time.Minute, 5), LineDelay: 2 * time.Millisecond},
+	}
+
+	var mu sync.Mutex
+	var events []event.Event
+	d := event.NewDispatcher(event.Func(func(e event.Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+
+	q := queue.New(queue.Config{
+		FFmpeg:                &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Retry:                 queue.RetryPolicy{Backoff: time.Millisecond},
+		Events:                d,
+		ProgressEventInterval: time.Nanosecond,
+	})
+
+	id, _ := q.Submit(context.Background(), spec("out.m4a", 0, 1))
+	if _, err := q.Wait(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	d.Close(context.Background())
+
+	var types []event.Type
+	for _, e := range events {
+		if e.Job != id {
+			t.Errorf("event of job %s", e.Job)
+		}
+		if len(types) == 0 || types[len(types)-1] != e.Type {
+			types = append(types, e.Type)
+		}
+	}
+	// The transient failure is queued again rather than failed
+	want := []event.Type{event.Queued, event.Started, event.Queued, event.Started, event.Progress, event.Completed}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("events %v, want %v", types, want)
+	}
+
+	if retry := events[2]; retry.Error == "" || retry.Attempt != 1 {
+		t.Errorf("retry event %+v", retry)
+	}
+	done := events[len(events)-1]
+	if len(done.Command) == 0 || done.Command[len(done.Command)-1] != "out.m4a" {
+		t.Errorf("command %v", done.Command)
+	}
+	if done.Attempt != 2 || done.Timing.Started == nil || done.Timing.Finished == nil || done.Progress.Percent != 100 {
+		t.Errorf("completed event %+v", done)
+	}
+	if len(done.Outputs) != 1 || done.Outputs[0].Metadata == nil || done.Outputs[0].Metadata.Streams[0].CodecName != "aac" {
+		t.Errorf("outputs %+v", done.Outputs)
+	}
+}
+
+func TestQueueReleasesSlotsBeforeProbing(t *testing.T) {
+	started := make(chan struct{})
+	overlapped := make(chan bool, 1)
+	runner := &ffmpeg.FakeRunner{Script: func(cmd ffmpeg.Cmd) ffmpeg.FakeResult {
+		switch {
+		case cmd.Path == "ffprobe" && cmd.Args[1] == "a.mp4":
+			// The next job starts while the finished one is probed
+			select {
+			case <-started:
+				overlapped <- true
+			case <-time.After(time.Second):
+				overlapped <- false
+			}
+		case cmd.Path == "ffmpeg" && cmd.Args[len(cmd.Args)-1] == "b.mp4":
+			close(started)
+		}
+		return ffmpeg.FakeResult{Stdout: transcodertest.Probe(time.Minute)}
+	}}
+	d := event.NewDispatcher(event.Func(func(event.Event) {}))
+
+	q := queue.New(queue.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Slots:  1,
+		Events: d,
+	})
+	defer d.Close(context.Background())
+	defer q.Close()
+
+	ctx := context.Background()
+	a, _ := q.Submit(ctx, spec("a.mp4", 0, 1))
+	b, _ := q.Submit(ctx, spec("b.mp4", 0, 1))
+	for _, id := range []string{a, b} {
+		if _, err := q.Wait(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !<-overlapped {
+		t.Error("the next job waited for the finished job's outputs to be probed")
+	}
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errInterrupted is recorded on jobs that were running when the queue
+// process stopped and have exhausted their attempts
+var errInterrupted = errors.New("interrupted by a restart and out of attempts")
+
+// Recover loads the jobs persisted in the configured Store. Finished jobs
+// are restored for status queries. Jobs that were queued are queued again;
+// jobs that were running when the previous process died have their partial
+// outputs removed and are re-queued if the retry policy allows another
+// attempt, or marked failed otherwise. Jobs the queue already has, from a
+// previous Recover or a Submit, are skipped. It returns the IDs of re-queued
+// jobs
+func (q *Queue) Recover(ctx context.Context) ([]string, error) {
+	if q.config.Store == nil {
+		return nil, errors.New("queue has no store")
+	}
+
+	jobs, err := q.config.Store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var requeued []string
+
+	for _, status := range jobs {
+		if q.has(status.ID) {
+			continue
+		}
+
+		switch {
+		case status.State == StateRunning:
+			removeOutputs(status.Spec)
+			if status.Attempts >= q.config.Retry.maxAttempts() {
+				status.State = StateFailed
+				status.Error = errInterrupted.Error()
+				status.Finished = time.Now()
+				q.restore(status)
+				continue
+			}
+			fallthrough
+
+		case status.State == StateQueued:
+			err := q.requeue(ctx, status)
+			if errors.Is(err, errExists) {
+				continue
+			}
+			if err != nil {
+				return requeued, err
+			}
+			requeued = append(requeued, status.ID)
+
+		default:
+			q.restore(status)
+		}
+	}
+
+	return requeued, nil
+}
+
+// has reports whether the queue has a job with the given ID
+func (q *Queue) has(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.jobs[id]
+	return ok
+}
+
+// restore registers a finished job without running it
+func (q *Queue) restore(status Status) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.jobs[status.ID]; ok {
+		return
+	}
+
+	j := &job{status: status, seq: q.seq, index: -1, cancel: func() {}, done: make(chan struct{})}
+	close(j.done)
+	q.seq++
+	q.jobs[status.ID] = j
+	q.persist(j)
+}
+
+// removeOutputs deletes the local output files of an interrupted job.
+// URLs, pipes and segment patterns are left alone
+func removeOutputs(spec Spec) {
+	for _, out := range spec.Outputs {
+		path := out.Path
+		if path == "" || path == "-" || protocolRe.MatchString(path) || strings.Contains(path, "%") {
+			continue
+		}
+		os.Remove(path)
+	}
+}
+
+// protocolRe matches outputs naming an ffmpeg protocol, such as
+// "rtmp://host/live" or "pipe:1". A single letter is a Windows drive
+var protocolRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]+:`)
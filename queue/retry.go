@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// RetryPolicy decides whether and when failed jobs are attempted again
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a job may run, including the
+	// first. Defaults to 3
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, doubled for every
+	// further one. Defaults to 5 seconds
+	Backoff time.Duration
+	// MaxBackoff caps the delay between attempts. Defaults to 5 minutes
+	MaxBackoff time.Duration
+	// Retryable decides which failures are retried. Defaults to
+	// ffmpeg.IsTransient: network errors, a full disk and killed processes
+	Retryable func(err error) bool
+}
+
+// maxAttempts ...
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return 3
+	}
+	return p.MaxAttempts
+}
+
+// retry reports whether a job that failed with err after attempts runs
+// should run again, and after which delay
+func (p RetryPolicy) retry(err error, attempts int) (time.Duration, bool) {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = ffmpeg.IsTransient
+	}
+	if attempts >= p.maxAttempts() || !retryable(err) {
+		return 0, false
+	}
+
+	backoff, max := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = 5 * time.Second
+	}
+	if max <= 0 {
+		max = 5 * time.Minute
+	}
+
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+
+	return backoff, true
+}
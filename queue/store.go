@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Store persists job statuses so jobs survive a restart of the process
+// running the queue. Save is called on every state transition and
+// periodically with progress checkpoints
+type Store interface {
+	Save(status Status) error
+	Load() ([]Status, error)
+	Delete(id string) error
+}
+
+// FileStore is a Store backed by a single append-only file of JSON records.
+// The latest record of each job wins; the file is compacted when opened and
+// whenever superseded records outnumber live ones
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	jobs    map[string]Status
+	records int
+}
+
+// record is one line of a FileStore
+type record struct {
+	Status  *Status `json:"status,omitempty"`
+	Deleted string  `json:"deleted,omitempty"`
+}
+
+// OpenFileStore opens or creates the store at path
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, jobs: map[string]Status{}}
+
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Save ...
+func (s *FileStore) Save(status Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[status.ID] = status
+	return s.append(record{Status: &status})
+}
+
+// Load returns every stored job in creation order
+func (s *FileStore) Load() ([]Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Status, 0, len(s.jobs))
+	for _, status := range s.jobs {
+		jobs = append(jobs, status)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+
+	return jobs, nil
+}
+
+// Delete ...
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	return s.append(record{Deleted: id})
+}
+
+// Close ...
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// read replays the records of an existing store file. A truncated last
+// line, left by a crash in the middle of a write, is ignored
+func (s *FileStore) read() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Status != nil {
+			s.jobs[rec.Status.ID] = *rec.Status
+		}
+		if rec.Deleted != "" {
+			delete(s.jobs, rec.Deleted)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// append writes rec to the store file, compacting it when it has grown
+// mostly stale. Callers hold s.mu
+func (s *FileStore) append(rec record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// Records must survive a crash right after the state change they log
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.records++
+
+	if s.records > 2*len(s.jobs)+64 {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the store with one record per job and atomically
+// replaces the old file. Callers hold s.mu or have exclusive access
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, status := range s.jobs {
+		status := status
+		line, err := json.Marshal(record{Status: &status})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	s.records = len(s.jobs)
+
+	return err
+}
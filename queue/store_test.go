@@ -0,0 +1,158 @@
+package queue_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/queue"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jobs.log")
+	store, err := queue.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 200; i++ {
+		store.Save(queue.Status{ID: "a", State: queue.StateRunning, Created: now, Progress: ffmpeg.Progress{Progress: float64(i)}})
+	}
+	store.Save(queue.Status{ID: "b", State: queue.StateQueued, Created: now.Add(time.Second)})
+	store.Save(queue.Status{ID: "c", State: queue.StateDone, Created: now.Add(2 * time.Second)})
+	store.Delete("c")
+	store.Close()
+
+	store, err = queue.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	jobs, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "a" || jobs[0].Progress.Progress != 199 || jobs[1].ID != "b" {
+		t.Errorf("unexpected jobs after reopening: %+v", jobs)
+	}
+}
+
+func TestForgetDeletesStoredJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := queue.OpenFileStore(filepath.Join(dir, "jobs.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	finished := time.Now().Add(-time.Hour)
+	store.Save(queue.Status{ID: "old", State: queue.StateDone, Finished: finished,
+		Spec: queue.Spec{Input: "in.mov", Outputs: []queue.Output{{Path: "old.mp4"}}}})
+
+	q := queue.New(queue.Config{Store: store})
+	defer q.Close()
+	if _, err := q.Recover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ids := q.Forget(time.Now()); len(ids) != 1 || ids[0] != "old" {
+		t.Errorf("forgot %v", ids)
+	}
+
+	if jobs, _ := store.Load(); len(jobs) != 0 {
+		t.Errorf("forgotten jobs still stored: %+v", jobs)
+	}
+}
+
+func TestRecoverInterruptedJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := queue.OpenFileStore(filepath.Join(dir, "jobs.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	partial := filepath.Join(dir, "partial.mp4")
+	ioutil.WriteFile(partial, []byte("no moov atom"), 0644)
+
+	// State left behind by a process that died mid-transcode
+	store.Save(queue.Status{ID: "interrupted", State: queue.StateRunning, Attempts: 1,
+		Spec: queue.Spec{Input: "in.mov", Outputs: []queue.Output{{Path: partial}}}})
+	store.Save(queue.Status{ID: "exhausted", State: queue.StateRunning, Attempts: 3,
+		Spec: queue.Spec{Input: "in.mov", Outputs: []queue.Output{{Path: "exhausted.mp4"}}}})
+	store.Save(queue.Status{ID: "finished", State: queue.StateDone, Attempts: 1,
+		Spec: queue.Spec{Input: "in.mov", Outputs: []queue.Output{{Path: "finished.mp4"}}}})
+
+	runner := transcodertest.Runner(
+		transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 2), LineDelay: 20 * time.Millisecond},
+		transcodertest.Script{Stdout: transcodertest.Probe(time.Minute)},
+	)
+	q := queue.New(queue.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		Store:  store,
+	})
+	defer q.Close()
+
+	requeued, err := q.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requeued) != 1 || requeued[0] != "interrupted" {
+		t.Fatalf("requeued %v", requeued)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Error("partial output was not removed")
+	}
+
+	// Jobs already in the queue are not recovered twice
+	if requeued, err := q.Recover(context.Background()); err != nil || len(requeued) != 0 {
+		t.Errorf("second Recover() = %v, %v", requeued, err)
+	}
+	if jobs := q.Jobs(); len(jobs) != 3 {
+		t.Errorf("%d jobs after recovering twice, want 3", len(jobs))
+	}
+
+	status, err := q.Wait(context.Background(), "interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != queue.StateDone || status.Attempts != 2 {
+		t.Errorf("recovered job finished as %s after %d attempts", status.State, status.Attempts)
+	}
+
+	if status, _ := q.Status("exhausted"); status.State != queue.StateFailed {
+		t.Errorf("exhausted job is %s, want failed", status.State)
+	}
+	if status, _ := q.Status("finished"); status.State != queue.StateDone {
+		t.Errorf("finished job is %s, want done", status.State)
+	}
+
+	jobs, _ := store.Load()
+	for _, job := range jobs {
+		if !job.State.Finished() {
+			t.Errorf("store still has job %s as %s", job.ID, job.State)
+		}
+	}
+}
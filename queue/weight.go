@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Weigher estimates how many worker slots a job needs
+type Weigher func(spec Spec) int
+
+// DefaultWeight estimates a job's CPU cost from its output options: audio
+// only outputs count for one slot, video outputs for more depending on the
+// codec and resolution. A 4K HEVC encode weighs 16, a 1080p H.264 encode 2
+func DefaultWeight(spec Spec) int {
+	weight := 0
+	for _, out := range spec.Outputs {
+		weight += outputWeight(out)
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
+// outputWeight ...
+func outputWeight(out Output) int {
+	opts := out.Options
+	if opts.SkipVideo != nil {
+		return 1
+	}
+
+	codec := ""
+	if opts.VideoCodec != nil {
+		codec = *opts.VideoCodec
+	}
+	if codec == "copy" {
+		return 1
+	}
+
+	weight := 2
+	switch {
+	case strings.Contains(codec, "265"), strings.Contains(codec, "hevc"), strings.Contains(codec, "vp9"):
+		weight = 4
+	case strings.Contains(codec, "av1"):
+		weight = 6
+	}
+
+	if opts.Resolution != nil && pixels(*opts.Resolution) > 1920*1080*2 {
+		weight *= 4
+	} else if opts.Resolution == nil && weight > 2 {
+		// Unknown resolution, assume the expensive codecs are used for UHD
+		weight *= 2
+	}
+
+	return weight
+}
+
+// pixels parses a WxH resolution
+func pixels(resolution string) int {
+	parts := strings.SplitN(resolution, "x", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	w, _ := strconv.Atoi(parts[0])
+	h, _ := strconv.Atoi(parts[1])
+	return w * h
+}
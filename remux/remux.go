@@ -0,0 +1,275 @@
+// Package remux converts media files between containers, stream copying
+// everything the target container can hold and transcoding only the rest.
+package remux
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Action is what happens to a stream
+type Action string
+
+// Actions
+const (
+	Copy    Action = "copy"
+	Convert Action = "convert"
+	Drop    Action = "drop"
+)
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Format overrides the container picked from the output's extension,
+	// e.g. "mp4", "mov", "matroska", "webm" or "mpegts"
+	Format string
+	// Encoders overrides the encoders of converted streams, keyed by stream
+	// type ("video", "audio" or "subtitle")
+	Encoders map[string]string
+}
+
+// Stream reports what was done with an input stream
+type Stream struct {
+	Index  int
+	Type   string
+	Codec  string
+	Action Action
+	// Encoder of converted streams
+	Encoder string
+}
+
+// Result ...
+type Result struct {
+	Format  string
+	Streams []Stream
+}
+
+// Copied returns the streams copied as they were
+func (r *Result) Copied() []Stream {
+	return r.filter(Copy)
+}
+
+// Converted returns the streams transcoded for the container
+func (r *Result) Converted() []Stream {
+	return r.filter(Convert)
+}
+
+// Dropped returns the streams the container cannot hold
+func (r *Result) Dropped() []Stream {
+	return r.filter(Drop)
+}
+
+// filter ...
+func (r *Result) filter(a Action) []Stream {
+	var streams []Stream
+	for _, s := range r.Streams {
+		if s.Action == a {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// container lists the codecs a container holds and the encoders used for
+// streams it does not. An empty encoder drops such streams
+type container struct {
+	codecs map[string][]string
+	// anyCodec lists the stream types held whatever their codec
+	anyCodec []string
+	encoders map[string]string
+	// faststart moves the index to the front for progressive playback
+	faststart bool
+}
+
+var (
+	isoCodecs = map[string][]string{
+		"video":    {"h264", "hevc", "av1", "vp9", "mpeg4", "mpeg2video", "mjpeg", "png"},
+		"audio":    {"aac", "mp3", "ac3", "eac3", "alac", "flac", "opus"},
+		"subtitle": {"mov_text"},
+	}
+
+	containers = map[string]container{
+		"mp4": {
+			codecs:    isoCodecs,
+			encoders:  map[string]string{"video": "libx264", "audio": "aac", "subtitle": "mov_text"},
+			faststart: true,
+		},
+		"mov": {
+			codecs: map[string][]string{
+				"video":    append([]string{"prores", "dnxhd", "qtrle"}, isoCodecs["video"]...),
+				"audio":    append([]string{"pcm_s16le", "pcm_s16be", "pcm_s24le", "pcm_s24be", "pcm_f32le"}, isoCodecs["audio"]...),
+				"subtitle": {"mov_text"},
+			},
+			encoders:  map[string]string{"video": "libx264", "audio": "aac", "subtitle": "mov_text"},
+			faststart: true,
+		},
+		"matroska": {
+			codecs: map[string][]string{
+				"subtitle": {"subrip", "ass", "ssa", "webvtt", "hdmv_pgs_subtitle", "dvd_subtitle"},
+			},
+			anyCodec: []string{"video", "audio", "attachment"},
+			encoders: map[string]string{"subtitle": "srt"},
+		},
+		"webm": {
+			codecs: map[string][]string{
+				"video":    {"vp8", "vp9", "av1"},
+				"audio":    {"vorbis", "opus"},
+				"subtitle": {"webvtt"},
+			},
+			encoders: map[string]string{"video": "libvpx-vp9", "audio": "libopus", "subtitle": "webvtt"},
+		},
+		"mpegts": {
+			codecs: map[string][]string{
+				"video":    {"h264", "hevc", "mpeg2video", "mpeg1video"},
+				"audio":    {"aac", "mp3", "mp2", "ac3", "eac3", "opus"},
+				"subtitle": {"dvb_subtitle", "dvb_teletext"},
+			},
+			encoders: map[string]string{"video": "libx264", "audio": "aac"},
+		},
+	}
+
+	extensions = map[string]string{
+		".mp4":  "mp4",
+		".m4v":  "mp4",
+		".m4a":  "mp4",
+		".mov":  "mov",
+		".mkv":  "matroska",
+		".mka":  "matroska",
+		".webm": "webm",
+		".ts":   "mpegts",
+		".m2ts": "mpegts",
+	}
+
+	// hvc1 tags HEVC in MP4 and QuickTime with parameter sets out of band,
+	// as Apple players require, instead of ffmpeg's default hev1
+	hvc1 = map[string]bool{"mp4": true, "mov": true}
+
+	// textSubtitles can be converted to another text format
+	textSubtitles = map[string]bool{
+		"subrip": true, "srt": true, "ass": true, "ssa": true, "webvtt": true, "mov_text": true, "text": true,
+	}
+)
+
+// Compatible reports whether format, a container name such as "mp4", can
+// hold a stream of codecType ("video", "audio" or "subtitle") and codec
+func Compatible(format, codecType, codec string) bool {
+	c, ok := containers[format]
+	if !ok {
+		return false
+	}
+	for _, t := range c.anyCodec {
+		if t == codecType {
+			return true
+		}
+	}
+	for _, name := range c.codecs[codecType] {
+		if name == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// Run remuxes input into output. Streams the container cannot hold are
+// transcoded, or dropped when they cannot be converted, such as bitmap
+// subtitles going to MP4 or data streams
+func Run(ctx context.Context, cfg Config, input, output string) (*Result, error) {
+	format := cfg.Format
+	if format == "" {
+		format = extensions[strings.ToLower(filepath.Ext(output))]
+	}
+	if _, ok := containers[format]; !ok {
+		return nil, fmt.Errorf("unsupported container for %s", output)
+	}
+
+	metadata, err := ffmpeg.New(cfg.FFmpeg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	result := Plan(format, metadata, cfg.Encoders)
+
+	if _, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, Args(input, output, result)...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Plan decides what to do with every stream of metadata going to format.
+// encoders overrides the container's encoders by stream type
+func Plan(format string, metadata transcoder.Metadata, encoders map[string]string) *Result {
+	c := containers[format]
+	result := &Result{Format: format}
+
+	for _, s := range metadata.GetStreams() {
+		stream := Stream{Index: s.GetIndex(), Type: s.GetCodecType(), Codec: s.GetCodecName(), Action: Copy}
+
+		if !Compatible(format, stream.Type, stream.Codec) {
+			stream.Encoder = encoders[stream.Type]
+			if stream.Encoder == "" {
+				stream.Encoder = c.encoders[stream.Type]
+			}
+
+			switch {
+			case stream.Type == "subtitle" && !textSubtitles[stream.Codec]:
+				stream.Encoder = ""
+				stream.Action = Drop
+			case stream.Encoder == "":
+				stream.Action = Drop
+			default:
+				stream.Action = Convert
+			}
+		}
+
+		result.Streams = append(result.Streams, stream)
+	}
+
+	return result
+}
+
+// Args returns the ffmpeg arguments carrying out a plan
+func Args(input, output string, plan *Result) []string {
+	args := []string{"-y", "-i", input}
+
+	var codecs []string
+	out := 0
+	for _, s := range plan.Streams {
+		if s.Action == Drop {
+			continue
+		}
+
+		args = append(args, "-map", "0:"+strconv.Itoa(s.Index))
+
+		codec := "copy"
+		if s.Action == Convert {
+			codec = s.Encoder
+		}
+		codecs = append(codecs, "-c:"+strconv.Itoa(out), codec)
+		if hvc1[plan.Format] && isHEVC(s) {
+			codecs = append(codecs, "-tag:"+strconv.Itoa(out), "hvc1")
+		}
+		out++
+	}
+
+	args = append(args, codecs...)
+	if containers[plan.Format].faststart {
+		args = append(args, "-movflags", "+faststart")
+	}
+
+	return append(args, "-f", plan.Format, output)
+}
+
+// isHEVC reports whether s is written as HEVC, copied or encoded
+func isHEVC(s Stream) bool {
+	if s.Action == Copy {
+		return s.Codec == "hevc"
+	}
+	return strings.HasPrefix(s.Encoder, "hevc") || strings.HasSuffix(s.Encoder, "265")
+}
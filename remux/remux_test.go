@@ -0,0 +1,108 @@
+package remux_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/remux"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func TestRunToMP4(t *testing.T) {
+	pcm := transcodertest.AudioStream("pcm_s24le")
+	srt := ffmpeg.Streams{CodecName: "subrip", CodecType: "subtitle"}
+	pgs := ffmpeg.Streams{CodecName: "hdmv_pgs_subtitle", CodecType: "subtitle"}
+	font := ffmpeg.Streams{CodecName: "ttf", CodecType: "attachment"}
+
+	probe := transcodertest.Probe(time.Minute,
+		transcodertest.VideoStream("h264", 1920, 1080), transcodertest.AudioStream("aac"), pcm, srt, pgs, font)
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{Stdout: probe})
+
+	result, err := remux.Run(context.Background(), remux.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+	}, "movie.mkv", "movie.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(runner.Calls()[1].Args, " ")
+	want := "-y -i movie.mkv -map 0:0 -map 0:1 -map 0:2 -map 0:3 -c:0 copy -c:1 copy -c:2 aac -c:3 mov_text -movflags +faststart -f mp4 movie.mp4"
+	if args != want {
+		t.Errorf("args =\n%s\nwant\n%s", args, want)
+	}
+
+	if copied := result.Copied(); len(copied) != 2 || copied[1].Codec != "aac" {
+		t.Errorf("copied = %+v", copied)
+	}
+	if converted := result.Converted(); len(converted) != 2 || converted[0].Codec != "pcm_s24le" || converted[0].Encoder != "aac" {
+		t.Errorf("converted = %+v", converted)
+	}
+	if dropped := result.Dropped(); len(dropped) != 2 || dropped[0].Index != 4 || dropped[1].Index != 5 {
+		t.Errorf("dropped = %+v", dropped)
+	}
+}
+
+func TestRunToMatroskaCopiesEverything(t *testing.T) {
+	probe := transcodertest.Probe(time.Minute, transcodertest.VideoStream("prores", 1920, 1080), transcodertest.AudioStream("pcm_s16le"))
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{Stdout: probe})
+
+	result, err := remux.Run(context.Background(), remux.Config{
+		FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+	}, "master.mov", "master.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Copied()) != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	if args := strings.Join(runner.Calls()[1].Args, " "); strings.Contains(args, "faststart") || !strings.HasSuffix(args, "-f matroska master.mkv") {
+		t.Errorf("args = %s", args)
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		format, typ, codec string
+		want               bool
+	}{
+		{"mp4", "video", "hevc", true},
+		{"mp4", "audio", "pcm_s16le", false},
+		{"mov", "audio", "pcm_s16le", true},
+		{"webm", "video", "h264", false},
+		{"mpegts", "audio", "ac3", true},
+		{"matroska", "subtitle", "hdmv_pgs_subtitle", true},
+		{"matroska", "subtitle", "mov_text", false},
+		{"matroska", "video", "prores", true},
+		{"matroska", "data", "bin_data", false},
+		{"avi", "video", "h264", false},
+	}
+	for _, tt := range tests {
+		if got := remux.Compatible(tt.format, tt.typ, tt.codec); got != tt.want {
+			t.Errorf("Compatible(%s, %s, %s) = %v, want %v", tt.format, tt.typ, tt.codec, got, tt.want)
+		}
+	}
+}
+
+func TestRunConvertsForMatroskaAndTagsHEVC(t *testing.T) {
+	movText := ffmpeg.Streams{CodecName: "mov_text", CodecType: "subtitle"}
+	probe := transcodertest.Probe(time.Minute, transcodertest.VideoStream("hevc", 3840, 2160), transcodertest.AudioStream("aac"), movText)
+
+	for _, c := range []struct{ output, want string }{
+		{"movie.mkv", "-c:0 copy -c:1 copy -c:2 srt -f matroska movie.mkv"},
+		{"movie.mov", "-c:0 copy -tag:0 hvc1 -c:1 copy -c:2 copy -movflags +faststart -f mov movie.mov"},
+	} {
+		runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{Stdout: probe})
+		if _, err := remux.Run(context.Background(), remux.Config{
+			FFmpeg: &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner},
+		}, "movie.mp4", c.output); err != nil {
+			t.Fatal(err)
+		}
+		if args := strings.Join(runner.Calls()[1].Args, " "); !strings.HasSuffix(args, c.want) {
+			t.Errorf("args = %s\nwant suffix %s", args, c.want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Package server exposes a transcode worker pool over HTTP.
+//
+// Jobs are submitted as job specs and run on a local queue:
+//
+//	POST   /jobs              submit a JSON or YAML job spec, ?priority=N
+//	GET    /jobs              list jobs
+//	GET    /jobs/{id}         job status and progress
+//	DELETE /jobs/{id}         cancel a job
+//	GET    /jobs/{id}/events  Server-Sent Events stream of progress updates
+//	GET    /probe?input=...   ffprobe metadata of an input
+//
+// Inputs and outputs are paths on the server's machine, and probes read
+// any input ffprobe can open, so the server is meant for trusted networks.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floostack/transcoder/event"
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/job"
+	"github.com/floostack/transcoder/queue"
+)
+
+// Config ...
+type Config struct {
+	// FFmpeg configures transcodes and probes. Defaults to the ffmpeg and
+	// ffprobe binaries in the PATH
+	FFmpeg *ffmpeg.Config
+	// Slots is the worker pool capacity. Defaults to the number of CPUs
+	Slots int
+	// Presets resolves the presets job specs do not define. Optional
+	Presets job.Presets
+	// ProgressInterval is how often event streams check for progress.
+	// Defaults to 500 milliseconds
+	ProgressInterval time.Duration
+	// MaxSpecSize limits the size of submitted specs. Defaults to 1 MiB
+	MaxSpecSize int64
+	// Events receives the lifecycle events of every job. Optional
+	Events event.Publisher
+	// Retention is how long finished jobs are kept for status queries.
+	// Defaults to one hour
+	Retention time.Duration
+}
+
+// Server is an http.Handler running submitted jobs
+type Server struct {
+	config Config
+	queue  *queue.Queue
+	mux    *http.ServeMux
+
+	mu    sync.Mutex
+	specs map[string]job.Spec
+}
+
+// Job is the JSON representation of a job
+type Job struct {
+	ID       string              `json:"id"`
+	State    queue.State         `json:"state"`
+	Progress event.ProgressState `json:"progress"`
+	Error    string              `json:"error,omitempty"`
+	Attempts int                 `json:"attempts"`
+	Created  time.Time           `json:"created"`
+	Started  *time.Time          `json:"started,omitempty"`
+	Finished *time.Time          `json:"finished,omitempty"`
+	Spec     job.Spec            `json:"spec"`
+}
+
+// New returns a server with a worker pool of cfg.Slots slots
+func New(cfg Config) *Server {
+	if cfg.FFmpeg == nil {
+		cfg.FFmpeg = &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe"}
+	}
+	if cfg.ProgressInterval <= 0 {
+		cfg.ProgressInterval = 500 * time.Millisecond
+	}
+	if cfg.MaxSpecSize <= 0 {
+		cfg.MaxSpecSize = 1 << 20
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = time.Hour
+	}
+
+	s := &Server{
+		config: cfg,
+		queue:  queue.New(queue.Config{FFmpeg: cfg.FFmpeg, Slots: cfg.Slots, Events: cfg.Events}),
+		mux:    http.NewServeMux(),
+		specs:  map[string]job.Spec{},
+	}
+	s.mux.HandleFunc("/jobs", s.handleJobs)
+	s.mux.HandleFunc("/jobs/", s.handleJob)
+	s.mux.HandleFunc("/probe", s.handleProbe)
+	return s
+}
+
+// ServeHTTP ...
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels every job and waits for running ones to stop
+func (s *Server) Close() {
+	s.queue.Close()
+}
+
+// handleJobs serves /jobs
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	s.forget()
+
+	switch r.Method {
+	case http.MethodGet:
+		jobs := []Job{}
+		for _, status := range s.queue.Jobs() {
+			jobs = append(jobs, s.job(status))
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		s.submit(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// submit queues the spec in the request body
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.config.MaxSpecSize))
+	if err != nil {
+		code := http.StatusBadRequest
+		// http.MaxBytesError needs Go 1.19
+		if strings.Contains(err.Error(), "http: request body too large") {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	format := job.JSON
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		format = job.YAML
+	}
+	spec, err := job.Parse(data, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	qs, err := spec.QueueSpec(s.config.Presets)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if p := r.URL.Query().Get("priority"); p != "" {
+		if qs.Priority, err = strconv.Atoi(p); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid priority %q", p))
+			return
+		}
+	}
+
+	// Jobs outlive the request submitting them
+	s.mu.Lock()
+	id, err := s.queue.Submit(context.Background(), qs)
+	if err == nil {
+		s.specs[id] = spec
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	status, err := s.queue.Status(id)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusCreated, s.job(status))
+}
+
+// forget drops the jobs finished longer than the retention ago
+func (s *Server) forget() {
+	ids := s.queue.Forget(time.Now().Add(-s.config.Retention))
+	if len(ids) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	for _, id := range ids {
+		delete(s.specs, id)
+	}
+	s.mu.Unlock()
+}
+
+// handleJob serves /jobs/{id} and /jobs/{id}/events
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		status, err := s.queue.Status(id)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.job(status))
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if err := s.queue.Cancel(id); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		// Running jobs stop in the background
+		status, err := s.queue.Status(id)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, s.job(status))
+	case len(parts) == 1:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	case len(parts) == 2 && parts[1] == "events" && r.Method == http.MethodGet:
+		s.events(w, r, id)
+	case len(parts) == 2 && parts[1] == "events":
+		methodNotAllowed(w, http.MethodGet)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// events streams the status of a job as Server-Sent Events: a "progress"
+// event whenever it changes and a final "done" event once it finishes
+func (s *Server) events(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	status, err := s.queue.Status(id)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(s.config.ProgressInterval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		data, err := json.Marshal(s.job(status))
+		if err != nil {
+			return
+		}
+
+		name := "progress"
+		if status.State.Finished() {
+			name = "done"
+		}
+		if name == "done" || string(data) != string(last) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+			flusher.Flush()
+			last = data
+		}
+		if name == "done" {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+
+		if status, err = s.queue.Status(id); err != nil {
+			return
+		}
+	}
+}
+
+// handleProbe serves /probe
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	input := r.URL.Query().Get("input")
+	if input == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing input parameter"))
+		return
+	}
+
+	ctx := r.Context()
+	metadata, err := ffmpeg.New(s.config.FFmpeg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// job converts a queue status with the spec it was submitted as
+func (s *Server) job(status queue.Status) Job {
+	s.mu.Lock()
+	spec := s.specs[status.ID]
+	s.mu.Unlock()
+
+	j := Job{
+		ID:       status.ID,
+		State:    status.State,
+		Progress: *event.NewProgress(status.Progress),
+		Error:    status.Error,
+		Attempts: status.Attempts,
+		Created:  status.Created,
+		Spec:     spec,
+	}
+	if !status.Started.IsZero() {
+		j.Started = &status.Started
+	}
+	if !status.Finished.IsZero() {
+		j.Finished = &status.Finished
+	}
+	return j
+}
+
+// statusOf maps queue errors to HTTP status codes
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, queue.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, queue.ErrClosed):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON ...
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error body
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// methodNotAllowed ...
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
@@ -0,0 +1,284 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/preset"
+	"github.com/floostack/transcoder/queue"
+	"github.com/floostack/transcoder/server"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+const spec = `{
+	"inputs": [{"path": "logo.png"}, {"path": "in.mov"}],
+	"filter_complex": "[1:v][0:v]overlay[v]",
+	"outputs": [{"path": "out.mp4", "preset": "web-h264", "maps": ["[v]", "1:a"]}]
+}`
+
+// newServer starts a server whose ffmpeg runs script, stopped when t
+// finishes
+func newServer(t *testing.T, script transcodertest.Script) (*httptest.Server, *ffmpeg.FakeRunner) {
+	return newServerConfig(t, script, server.Config{})
+}
+
+// newServerConfig is newServer with the settings of cfg other than FFmpeg,
+// Slots, Presets and ProgressInterval
+func newServerConfig(t *testing.T, script transcodertest.Script, cfg server.Config) (*httptest.Server, *ffmpeg.FakeRunner) {
+	runner := transcodertest.Runner(script, transcodertest.Script{
+		Stdout: transcodertest.Probe(time.Minute, transcodertest.VideoStream("h264", 1920, 1080)),
+	})
+	cfg.FFmpeg = &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}
+	cfg.Slots = 2
+	cfg.Presets = preset.Default
+	cfg.ProgressInterval = 5 * time.Millisecond
+	s := server.New(cfg)
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts, runner
+}
+
+// do sends a request and decodes its JSON response into v
+func do(t *testing.T, method, url, contentType, body string, v interface{}) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+// waitFor polls a job until it is finished
+func waitFor(t *testing.T, ts *httptest.Server, id string) server.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var j server.Job
+		do(t, http.MethodGet, ts.URL+"/jobs/"+id, "", "", &j)
+		if j.State.Finished() {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s", id, j.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	ts, runner := newServer(t, transcodertest.Script{Stderr: transcodertest.Progress(time.Minute, 3)})
+
+	var created server.Job
+	resp := do(t, http.MethodPost, ts.URL+"/jobs?priority=5", "application/json", spec, &created)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/jobs/"+created.ID {
+		t.Fatalf("status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if created.Spec.Outputs[0].Preset != "web-h264" {
+		t.Errorf("spec not echoed: %+v", created.Spec)
+	}
+
+	j := waitFor(t, ts, created.ID)
+	if j.State != queue.StateDone || j.Progress.Percent != 100 || j.Finished == nil {
+		t.Errorf("job %+v", j)
+	}
+
+	var args []string
+	for _, call := range runner.Calls() {
+		if call.Path == "ffmpeg" {
+			args = call.Args
+		}
+	}
+	got := strings.Join(args, " ")
+	want := "-i logo.png -i in.mov -filter_complex [1:v][0:v]overlay[v] -map [v] -map 1:a "
+	if !strings.HasPrefix(got, want) || !strings.Contains(got, "-c:v libx264") || !strings.HasSuffix(got, " out.mp4") {
+		t.Errorf("ffmpeg %s", got)
+	}
+
+	var jobs []server.Job
+	do(t, http.MethodGet, ts.URL+"/jobs", "", "", &jobs)
+	if len(jobs) != 1 || jobs[0].ID != created.ID {
+		t.Errorf("jobs %+v", jobs)
+	}
+}
+
+func TestSubmitYAML(t *testing.T) {
+	ts, _ := newServer(t, transcodertest.Script{})
+
+	var created server.Job
+	body := "inputs: [{path: in.wav}]\noutputs: [{path: out.ogg, preset: opus-voice}]\n"
+	if resp := do(t, http.MethodPost, ts.URL+"/jobs", "application/yaml", body, &created); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if j := waitFor(t, ts, created.ID); j.State != queue.StateDone {
+		t.Errorf("job %+v", j)
+	}
+}
+
+func TestSubmitInvalid(t *testing.T) {
+	ts, _ := newServer(t, transcodertest.Script{})
+
+	for _, body := range []string{
+		`{"inputs": [{"path": "in.mov"}], "outputs": [{"path": "out.mp4", "preset": "missing"}]}`,
+		`{"inputs": [{"path": "in.mov"}], "outputs": [{"path": "out.mp4", "codec": "x"}]}`,
+		`not json`,
+	} {
+		var e map[string]string
+		if resp := do(t, http.MethodPost, ts.URL+"/jobs", "", body, &e); resp.StatusCode != http.StatusBadRequest || e["error"] == "" {
+			t.Errorf("%s: status %d, %v", body, resp.StatusCode, e)
+		}
+	}
+}
+
+func TestSubmitUnreadable(t *testing.T) {
+	ts, _ := newServerConfig(t, transcodertest.Script{}, server.Config{MaxSpecSize: 64})
+
+	var e map[string]string
+	if resp := do(t, http.MethodPost, ts.URL+"/jobs", "", spec, &e); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized spec: status %d, %v", resp.StatusCode, e)
+	}
+
+	// A body failing for other reasons is a bad request
+	rec := httptest.NewRecorder()
+	server.New(server.Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", iotest.TimeoutReader(strings.NewReader(spec))))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unreadable spec: status %d", rec.Code)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ts, _ := newServerConfig(t, transcodertest.Script{}, server.Config{Retention: time.Millisecond})
+
+	var created server.Job
+	do(t, http.MethodPost, ts.URL+"/jobs", "", spec, &created)
+	waitFor(t, ts, created.ID)
+	time.Sleep(5 * time.Millisecond)
+
+	var jobs []server.Job
+	do(t, http.MethodGet, ts.URL+"/jobs", "", "", &jobs)
+	if len(jobs) != 0 {
+		t.Errorf("jobs %+v", jobs)
+	}
+	if resp := do(t, http.MethodGet, ts.URL+"/jobs/"+created.ID, "", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("forgotten job: status %d", resp.StatusCode)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ts, _ := newServer(t, transcodertest.Script{
+		Stderr:    transcodertest.Progress(time.Minute, 1000),
+		LineDelay: 10 * time.Millisecond,
+	})
+
+	var created server.Job
+	do(t, http.MethodPost, ts.URL+"/jobs", "", spec, &created)
+
+	var cancelled server.Job
+	if resp := do(t, http.MethodDelete, ts.URL+"/jobs/"+created.ID, "", "", &cancelled); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if j := waitFor(t, ts, created.ID); j.State != queue.StateCancelled {
+		t.Errorf("job %+v", j)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ts, _ := newServer(t, transcodertest.Script{
+		Stderr:    transcodertest.Progress(time.Minute, 5),
+		LineDelay: 10 * time.Millisecond,
+	})
+
+	var created server.Job
+	do(t, http.MethodPost, ts.URL+"/jobs", "", spec, &created)
+
+	resp, err := http.Get(ts.URL + "/jobs/" + created.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+
+	var events []string
+	var last server.Job
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(events) < 2 || events[0] != "progress" || events[len(events)-1] != "done" {
+		t.Errorf("events %v", events)
+	}
+	if last.State != queue.StateDone || last.Progress.Percent != 100 {
+		t.Errorf("last event %+v", last)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ts, runner := newServer(t, transcodertest.Script{})
+
+	var m ffmpeg.Metadata
+	if resp := do(t, http.MethodGet, ts.URL+"/probe?input=in.mp4", "", "", &m); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if len(m.Streams) != 1 || m.Streams[0].Width != 1920 {
+		t.Errorf("metadata %+v", m)
+	}
+	if calls := runner.Calls(); len(calls) != 1 || calls[0].Args[1] != "in.mp4" {
+		t.Errorf("calls %+v", calls)
+	}
+
+	if resp := do(t, http.MethodGet, ts.URL+"/probe", "", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing input: status %d", resp.StatusCode)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ts, _ := newServer(t, transcodertest.Script{})
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if resp := do(t, method, ts.URL+"/jobs/missing", "", "", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d", method, resp.StatusCode)
+		}
+	}
+	if resp := do(t, http.MethodGet, ts.URL+"/jobs/missing/events", "", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("events: status %d", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodPut, ts.URL+"/jobs", "", "", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("put: status %d", resp.StatusCode)
+	}
+}
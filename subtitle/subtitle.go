@@ -0,0 +1,322 @@
+// Package subtitle extracts, converts, muxes and burns in subtitles.
+//
+// Text formats are picked from file extensions: .srt for SubRip, .vtt for
+// WebVTT and .ass or .ssa for Advanced SubStation Alpha. Bitmap tracks, such
+// as Blu-ray PGS or DVD subtitles, cannot be converted to text. They are burnt
+// in with the overlay filter rather than libass.
+package subtitle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/floostack/transcoder"
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Format is a text subtitle format, named after its ffmpeg encoder
+type Format string
+
+// Formats
+const (
+	SRT     Format = "srt"
+	WebVTT  Format = "webvtt"
+	ASS     Format = "ass"
+	MovText Format = "mov_text"
+)
+
+// ErrBitmap is returned when converting a bitmap track to text
+var ErrBitmap = errors.New("bitmap subtitles cannot be converted to text")
+
+// bitmapCodecs ...
+var bitmapCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// Config ...
+type Config struct {
+	FFmpeg *ffmpeg.Config
+	// Options are the output options of Burn, which re-encodes the video
+	Options ffmpeg.Options
+}
+
+// Track is a probed subtitle stream
+type Track struct {
+	// Index is the stream's index in the file
+	Index int
+	// Position is the track's index among the subtitle streams, as used by
+	// 0:s:N stream specifiers and the subtitles filter's si option
+	Position int
+	Codec    string
+	Language string
+	Title    string
+	Default  bool
+	Forced   bool
+}
+
+// Bitmap reports whether the track holds images rather than text
+func (t Track) Bitmap() bool {
+	return bitmapCodecs[t.Codec]
+}
+
+// Tracks probes input for subtitle streams
+func Tracks(ctx context.Context, cfg *ffmpeg.Config, input string) ([]Track, error) {
+	metadata, err := ffmpeg.New(cfg).Input(input).WithContext(&ctx).GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	for _, s := range metadata.GetStreams() {
+		if s.GetCodecType() != "subtitle" {
+			continue
+		}
+		track := Track{
+			Index:    s.GetIndex(),
+			Position: len(tracks),
+			Codec:    s.GetCodecName(),
+			Default:  s.GetDisposition().GetDefault() == 1,
+			Forced:   s.GetDisposition().GetForced() == 1,
+		}
+		if tagged, ok := s.(transcoder.TaggedStreams); ok {
+			if labels, ok := tagged.GetTags().(transcoder.Labels); ok {
+				track.Language, track.Title = labels.GetLanguage(), labels.GetTitle()
+			}
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, nil
+}
+
+// Select returns the tracks in one of languages, ordered by the languages'
+// preference and, within a language, default tracks first. Forced tracks,
+// which only cover foreign dialogue, are skipped unless forced is set, in
+// which case only forced tracks are returned
+func Select(tracks []Track, forced bool, languages ...string) []Track {
+	var selected []Track
+	for _, lang := range languages {
+		var rest []Track
+		for _, t := range tracks {
+			if t.Forced != forced || !strings.EqualFold(t.Language, lang) {
+				continue
+			}
+			if t.Default {
+				selected = append(selected, t)
+			} else {
+				rest = append(rest, t)
+			}
+		}
+		selected = append(selected, rest...)
+	}
+	return selected
+}
+
+// FormatOf returns the text format of a subtitle file from its extension
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".srt":
+		return SRT, nil
+	case ".vtt":
+		return WebVTT, nil
+	case ".ass", ".ssa":
+		return ASS, nil
+	}
+	return "", fmt.Errorf("unknown subtitle format of %s", path)
+}
+
+// Extract writes an embedded track of input to output, converting it to the
+// format of output's extension
+func Extract(ctx context.Context, cfg *ffmpeg.Config, input string, track Track, output string) error {
+	if track.Bitmap() {
+		return ErrBitmap
+	}
+
+	format, err := FormatOf(output)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = ffmpeg.Exec(ctx, cfg, "-y", "-i", input, "-map", "0:"+strconv.Itoa(track.Index),
+		"-c:s", string(format), output)
+	return err
+}
+
+// Convert converts the subtitle file input to the format of output's
+// extension
+func Convert(ctx context.Context, cfg *ffmpeg.Config, input, output string) error {
+	if _, err := FormatOf(input); err != nil {
+		return err
+	}
+	format, err := FormatOf(output)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = ffmpeg.Exec(ctx, cfg, "-y", "-i", input, "-c:s", string(format), output)
+	return err
+}
+
+// Soft is an external subtitle file muxed as a soft track
+type Soft struct {
+	Path string
+	// Language is an ISO 639-2 code such as "eng"
+	Language string
+	Title    string
+	Default  bool
+	Forced   bool
+}
+
+// Mux stream copies the video and audio of input into output with tracks
+// added as soft subtitles, replacing any subtitles input had. The subtitle
+// codec suits output's container: mov_text for MP4 and MOV, WebVTT for WebM
+// and HLS, where ffmpeg segments it into its own playlist, and the tracks'
+// own format otherwise
+func Mux(ctx context.Context, cfg *ffmpeg.Config, input, output string, tracks ...Soft) error {
+	_, _, err := ffmpeg.Exec(ctx, cfg, MuxArgs(input, output, tracks...)...)
+	return err
+}
+
+// MuxArgs returns the ffmpeg arguments of Mux
+func MuxArgs(input, output string, tracks ...Soft) []string {
+	args := []string{"-y", "-i", input}
+	for _, t := range tracks {
+		args = append(args, "-i", t.Path)
+	}
+
+	args = append(args, "-map", "0:v?", "-map", "0:a?")
+	for i := range tracks {
+		args = append(args, "-map", strconv.Itoa(i+1)+":s:0")
+	}
+
+	args = append(args, "-c:v", "copy", "-c:a", "copy")
+	if codec := containerCodec(output); codec != "" {
+		args = append(args, "-c:s", string(codec))
+	} else {
+		args = append(args, "-c:s", "copy")
+	}
+
+	for i, t := range tracks {
+		spec := "s:" + strconv.Itoa(i)
+		if t.Language != "" {
+			args = append(args, "-metadata:s:"+spec, "language="+t.Language)
+		}
+		if t.Title != "" {
+			args = append(args, "-metadata:s:"+spec, "title="+t.Title)
+		}
+		args = append(args, "-disposition:"+spec, disposition(t))
+	}
+
+	if strings.EqualFold(filepath.Ext(output), ".m3u8") {
+		args = append(args, "-f", "hls")
+	}
+
+	return append(args, output)
+}
+
+// containerCodec returns the subtitle codec output's container requires, or
+// an empty string when the tracks' own codec will do
+func containerCodec(output string) Format {
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".mp4", ".m4v", ".mov":
+		return MovText
+	case ".webm", ".m3u8":
+		return WebVTT
+	}
+	return ""
+}
+
+// disposition ...
+func disposition(t Soft) string {
+	var flags []string
+	if t.Default {
+		flags = append(flags, "default")
+	}
+	if t.Forced {
+		flags = append(flags, "forced")
+	}
+	if len(flags) == 0 {
+		return "0"
+	}
+	return strings.Join(flags, "+")
+}
+
+// BurnOptions ...
+type BurnOptions struct {
+	// Track selects the subtitle stream of a media file by its Position. Not
+	// needed for subtitle files
+	Track int
+	// Style overrides ASS styles, e.g. "FontName=Arial,FontSize=24"
+	Style string
+	// Charset of text subtitles that are not UTF-8, e.g. "CP1252"
+	Charset string
+	// Bitmap overlays the track's images instead of rendering text, as
+	// reported by Track.Bitmap. Style and Charset do not apply
+	Bitmap bool
+}
+
+// Filter returns the subtitles filter drawing the text subtitles of path, a
+// subtitle file or a media file with embedded tracks, onto the video. The
+// path and style are escaped for use in -vf or -filter_complex. Bitmap
+// tracks need the overlay filter used by Burn instead
+func Filter(path string, opts BurnOptions) string {
+	filter := "subtitles=filename=" + ffmpeg.EscapeFilterValue(path)
+	if opts.Track > 0 {
+		filter += ":si=" + strconv.Itoa(opts.Track)
+	}
+	if opts.Charset != "" {
+		filter += ":charenc=" + ffmpeg.EscapeFilterValue(opts.Charset)
+	}
+	if opts.Style != "" {
+		filter += ":force_style=" + ffmpeg.EscapeFilterValue(opts.Style)
+	}
+	return filter
+}
+
+// Burn re-encodes input into output with the subtitles of path drawn onto
+// the video, after any video filter of cfg.Options. Bitmap subtitles match
+// the source's size and are overlaid before the filter instead. Soft
+// subtitles are dropped
+func Burn(ctx context.Context, cfg Config, input, output, path string, opts BurnOptions) error {
+	o := cfg.Options
+	yes := true
+	o.Overwrite = &yes
+
+	if opts.Bitmap {
+		args := []string{"-i", input}
+		source := 0
+		if path != input {
+			args = append(args, "-i", path)
+			source = 1
+		}
+
+		graph := fmt.Sprintf("[0:v][%d:s:%d]overlay", source, opts.Track)
+		if o.VideoFilter != nil && *o.VideoFilter != "" {
+			graph += "," + *o.VideoFilter
+		}
+		o.VideoFilter = nil
+
+		args = append(args, "-filter_complex", graph+"[v]", "-map", "[v]", "-map", "0:a?")
+		args = append(args, o.GetStrArguments()...)
+		_, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, append(args, output)...)
+		return err
+	}
+
+	filter := Filter(path, opts)
+	if o.VideoFilter != nil && *o.VideoFilter != "" {
+		filter = *o.VideoFilter + "," + filter
+	}
+	o.VideoFilter = &filter
+	o.SkipSubtitle = &yes
+
+	args := append([]string{"-i", input}, o.GetStrArguments()...)
+	_, _, err := ffmpeg.Exec(ctx, cfg.FFmpeg, append(args, output)...)
+	return err
+}
@@ -0,0 +1,129 @@
+package subtitle_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+	"github.com/floostack/transcoder/subtitle"
+	"github.com/floostack/transcoder/transcodertest"
+)
+
+func subtitleStream(codec, language string, def, forced int) ffmpeg.Streams {
+	return ffmpeg.Streams{
+		CodecName:   codec,
+		CodecType:   "subtitle",
+		Tags:        ffmpeg.Tags{Language: language},
+		Disposition: ffmpeg.Disposition{Default: def, Forced: forced},
+	}
+}
+
+func TestTracksAndSelect(t *testing.T) {
+	probe := transcodertest.Probe(time.Minute,
+		transcodertest.VideoStream("h264", 1920, 1080),
+		transcodertest.AudioStream("aac"),
+		subtitleStream("subrip", "fre", 0, 0),
+		subtitleStream("subrip", "eng", 0, 1),
+		subtitleStream("ass", "eng", 0, 0),
+		subtitleStream("hdmv_pgs_subtitle", "eng", 1, 0),
+	)
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{Stdout: probe})
+	cfg := &ffmpeg.Config{FfmpegBinPath: "ffmpeg", FfprobeBinPath: "ffprobe", Runner: runner}
+
+	tracks, err := subtitle.Tracks(context.Background(), cfg, "movie.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 4 || tracks[2].Index != 4 || tracks[2].Position != 2 || tracks[2].Codec != "ass" {
+		t.Fatalf("tracks = %+v", tracks)
+	}
+
+	english := subtitle.Select(tracks, false, "eng", "fre")
+	var got []int
+	for _, tr := range english {
+		got = append(got, tr.Position)
+	}
+	if want := []int{3, 2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selected positions = %v, want %v", got, want)
+	}
+	if forced := subtitle.Select(tracks, true, "eng"); len(forced) != 1 || forced[0].Position != 1 {
+		t.Errorf("forced = %+v", forced)
+	}
+
+	if err := subtitle.Extract(context.Background(), cfg, "movie.mkv", english[0], "out.srt"); err != subtitle.ErrBitmap {
+		t.Errorf("extracting PGS: err = %v", err)
+	}
+	if err := subtitle.Extract(context.Background(), cfg, "movie.mkv", english[1], "out.vtt"); err != nil {
+		t.Fatal(err)
+	}
+	calls := runner.Calls()
+	if args := strings.Join(calls[len(calls)-1].Args, " "); args != "-y -i movie.mkv -map 0:4 -c:s webvtt out.vtt" {
+		t.Errorf("extract args = %s", args)
+	}
+}
+
+func TestMuxArgs(t *testing.T) {
+	tracks := []subtitle.Soft{
+		{Path: "en.srt", Language: "eng", Default: true},
+		{Path: "de.vtt", Language: "ger", Title: "Deutsch", Forced: true},
+	}
+
+	mp4 := strings.Join(subtitle.MuxArgs("in.mp4", "out.mp4", tracks...), " ")
+	want := "-y -i in.mp4 -i en.srt -i de.vtt -map 0:v? -map 0:a? -map 1:s:0 -map 2:s:0 -c:v copy -c:a copy -c:s mov_text " +
+		"-metadata:s:s:0 language=eng -disposition:s:0 default " +
+		"-metadata:s:s:1 language=ger -metadata:s:s:1 title=Deutsch -disposition:s:1 forced out.mp4"
+	if mp4 != want {
+		t.Errorf("mp4 args =\n%s\nwant\n%s", mp4, want)
+	}
+
+	hls := strings.Join(subtitle.MuxArgs("in.mp4", "stream.m3u8", tracks[0]), " ")
+	if !strings.Contains(hls, "-c:s webvtt") || !strings.HasSuffix(hls, "-f hls stream.m3u8") {
+		t.Errorf("hls args = %s", hls)
+	}
+
+	mkv := strings.Join(subtitle.MuxArgs("in.mkv", "out.mkv", tracks[0]), " ")
+	if !strings.Contains(mkv, "-c:s copy") {
+		t.Errorf("mkv args convert subtitles: %s", mkv)
+	}
+}
+
+func TestBurn(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{})
+	codec, scale := "libx264", "scale=1280:720"
+
+	err := subtitle.Burn(context.Background(), subtitle.Config{
+		FFmpeg:  &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		Options: ffmpeg.Options{VideoCodec: &codec, VideoFilter: &scale},
+	}, "in.mkv", "out.mp4", "/media/it's [final].mkv", subtitle.BurnOptions{Track: 2, Style: "FontName=Arial,FontSize=24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(runner.Calls()[0].Args, " ")
+	want := `-i in.mkv -c:v libx264 -vf scale=1280:720,subtitles=filename=/media/it\\\'s \[final\].mkv:si=2:force_style=FontName=Arial\,FontSize=24 -sn -y out.mp4`
+	if args != want {
+		t.Errorf("args =\n%s\nwant\n%s", args, want)
+	}
+}
+
+func TestBurnBitmap(t *testing.T) {
+	runner := transcodertest.Runner(transcodertest.Script{}, transcodertest.Script{})
+	codec, scale := "libx264", "scale=1280:720"
+
+	err := subtitle.Burn(context.Background(), subtitle.Config{
+		FFmpeg:  &ffmpeg.Config{FfmpegBinPath: "ffmpeg", Runner: runner},
+		Options: ffmpeg.Options{VideoCodec: &codec, VideoFilter: &scale},
+	}, "movie.mkv", "out.mp4", "movie.mkv", subtitle.BurnOptions{Track: 1, Bitmap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(runner.Calls()[0].Args, " ")
+	want := "-i movie.mkv -filter_complex [0:v][0:s:1]overlay,scale=1280:720[v] -map [v] -map 0:a? -c:v libx264 -y out.mp4"
+	if args != want {
+		t.Errorf("args =\n%s\nwant\n%s", args, want)
+	}
+}
@@ -19,3 +19,22 @@ type Transcoder interface {
 	WithContext(ctx *context.Context) Transcoder
 	GetMetadata() (Metadata, error)
 }
+
+// Commander is implemented by transcoders that can report the command they
+// run without running it
+type Commander interface {
+	Command() ([]string, error)
+}
+
+// Stopper is implemented by transcoders that can be waited for and stopped
+// gracefully
+type Stopper interface {
+	Wait() error
+	Stop() error
+}
+
+// Pauser is implemented by transcoders that can pause and resume encoding
+type Pauser interface {
+	Pause() error
+	Resume() error
+}
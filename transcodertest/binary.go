@@ -0,0 +1,88 @@
+package transcodertest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Binary is a fake executable written by FFmpeg or FFprobe
+type Binary struct {
+	Path string
+	log  string
+}
+
+// FFmpeg writes a fake ffmpeg executable running script into a temporary
+// directory removed when tb finishes
+func FFmpeg(tb testing.TB, script Script) *Binary {
+	return write(tb, "ffmpeg", script)
+}
+
+// FFprobe writes a fake ffprobe executable running script
+func FFprobe(tb testing.TB, script Script) *Binary {
+	return write(tb, "ffprobe", script)
+}
+
+// Calls returns the argv (without the binary itself) of every invocation
+func (b *Binary) Calls() ([][]string, error) {
+	data, err := ioutil.ReadFile(b.log)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var calls [][]string
+	for _, record := range bytes.Split(data, []byte{'\x1e'}) {
+		if len(record) == 0 {
+			continue
+		}
+		args := []string{}
+		for _, arg := range bytes.Split(bytes.TrimSuffix(record, []byte{0}), []byte{0}) {
+			args = append(args, string(arg))
+		}
+		calls = append(calls, args)
+	}
+
+	return calls, nil
+}
+
+// write renders script as a POSIX shell script named name
+func write(tb testing.TB, name string, script Script) *Binary {
+	tb.Helper()
+
+	dir, err := ioutil.TempDir("", "transcodertest")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	files := map[string]string{
+		path + ".stdout": script.Stdout,
+		path + ".stderr": script.Stderr,
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	sh := fmt.Sprintf(`#!/bin/sh
+for arg in "$@"; do printf '%%s\000' "$arg"; done >> '%[1]s.args'
+printf '\036' >> '%[1]s.args'
+cat '%[1]s.stdout'
+cat '%[1]s.stderr' >&2
+exit %[2]d
+`, path, script.ExitCode)
+
+	if err := ioutil.WriteFile(path, []byte(sh), 0755); err != nil {
+		tb.Fatal(err)
+	}
+
+	return &Binary{Path: path, log: path + ".args"}
+}
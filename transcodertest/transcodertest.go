@@ -0,0 +1,120 @@
+// Package transcodertest provides fake ffmpeg and ffprobe binaries for
+// testing code built on the ffmpeg transcoder without installing ffmpeg.
+//
+// Fakes come in two flavours sharing the same Script description: Runner
+// returns an ffmpeg.FakeRunner for in-process tests, while FFmpeg and FFprobe
+// write small executables that record the argv they receive, for code that
+// shells out through the default runner.
+package transcodertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/floostack/transcoder/ffmpeg"
+)
+
+// Script describes what a fake binary prints and how it exits
+type Script struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// LineDelay paces stderr lines. Only honoured by Runner
+	LineDelay time.Duration
+	// IgnoreStdin makes the process ignore "q" on stdin, like ffmpeg
+	// -nostdin. Only honoured by Runner
+	IgnoreStdin bool
+}
+
+// Runner returns a FakeRunner replaying the ffmpeg and ffprobe scripts
+func Runner(ffmpegScript, ffprobeScript Script) *ffmpeg.FakeRunner {
+	return ffmpeg.NewFakeRunner(ffmpegScript.result(), ffprobeScript.result())
+}
+
+// result ...
+func (s Script) result() ffmpeg.FakeResult {
+	return ffmpeg.FakeResult{
+		Stdout:      s.Stdout,
+		Stderr:      s.Stderr,
+		ExitCode:    s.ExitCode,
+		LineDelay:   s.LineDelay,
+		IgnoreStdin: s.IgnoreStdin,
+	}
+}
+
+// Progress returns an ffmpeg stderr transcript with steps progress lines
+// evenly spread over duration, terminated by '\r' like a real encode
+func Progress(duration time.Duration, steps int) string {
+	var b strings.Builder
+
+	b.WriteString("Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'input.mp4':\n")
+	b.WriteString("Output #0, mp4, to 'output.mp4':\n")
+
+	for i := 1; i <= steps; i++ {
+		at := duration * time.Duration(i) / time.Duration(steps)
+		fmt.Fprintf(&b, "frame=%5d fps=25.0 q=28.0 size=%8dkB time=%s bitrate=1024.0kbits/s speed=1.00x\r",
+			int(at.Seconds()*25), int(at.Seconds()*128), Timestamp(at))
+	}
+
+	return b.String()
+}
+
+// Timestamp formats d the way ffmpeg prints progress times (HH:MM:SS.cc)
+func Timestamp(d time.Duration) string {
+	cs := d.Round(10*time.Millisecond) / (10 * time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// Probe returns ffprobe JSON output for a file of the given duration
+// containing streams
+func Probe(duration time.Duration, streams ...ffmpeg.Streams) string {
+	for i := range streams {
+		streams[i].Index = i
+	}
+
+	metadata := ffmpeg.Metadata{
+		Format: ffmpeg.Format{
+			Filename:   "input.mp4",
+			NbStreams:  len(streams),
+			FormatName: "mov,mp4,m4a,3gp,3g2,mj2",
+			Duration:   fmt.Sprintf("%.6f", duration.Seconds()),
+			ProbeScore: 100,
+		},
+		Streams: streams,
+	}
+
+	out, err := json.Marshal(metadata)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(out)
+}
+
+// VideoStream returns a probed video stream fixture
+func VideoStream(codec string, width, height int) ffmpeg.Streams {
+	return ffmpeg.Streams{
+		CodecName:    codec,
+		CodecType:    "video",
+		Width:        width,
+		Height:       height,
+		PixFmt:       "yuv420p",
+		RFrameRrate:  "25/1",
+		AvgFrameRate: "25/1",
+		TimeBase:     "1/12800",
+	}
+}
+
+// AudioStream returns a probed audio stream fixture
+func AudioStream(codec string) ffmpeg.Streams {
+	return ffmpeg.Streams{
+		CodecName:     codec,
+		CodecType:     "audio",
+		TimeBase:      "1/48000",
+		SampleRate:    "48000",
+		Channels:      2,
+		ChannelLayout: "stereo",
+	}
+}
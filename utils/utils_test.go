@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestDurToSec(t *testing.T) {
+	cases := map[string]float64{
+		"00:00:10.50": 10.5,
+		"01:02:03.00": 3723,
+		"10.5":        0,
+	}
+	for in, want := range cases {
+		if got := DurToSec(in); got != want {
+			t.Errorf("DurToSec(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	got := ShellQuote([]string{"ffmpeg", "-i", "my clip's.mov", "-vf", "scale=1280:-2", ""})
+	want := `ffmpeg -i 'my clip'\''s.mov' -vf scale=1280:-2 ''`
+	if got != want {
+		t.Errorf("ShellQuote() = %s, want %s", got, want)
+	}
+}